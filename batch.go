@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+)
+
+// batchCreateRequest creates several volumes at once. When Atomic is set,
+// one failing volume rolls back the whole batch.
+type batchCreateRequest struct {
+	Atomic  bool                   `json:"atomic"`
+	Volumes []volume.CreateRequest `json:"volumes"`
+}
+
+// batchCreateResult is the outcome for one volume of a batch. Volumes that
+// already existed with the same options are neither created nor failed.
+type batchCreateResult struct {
+	Name    string `json:"name"`
+	Created bool   `json:"created"`
+	Error   string `json:"error,omitempty"`
+}
+
+// batchCreateResponse lists the outcome of each volume, in request order.
+type batchCreateResponse struct {
+	RolledBack bool                `json:"rolled_back"`
+	Volumes    []batchCreateResult `json:"volumes"`
+}
+
+// batchCreate creates the volumes of r as Create would, with a single
+// write of the state. Every volume is validated before any of them is
+// probed, has its identity file copied or runs its setup command, so an
+// atomic batch with an invalid volume has no side effects. As in Create,
+// provisioning runs without the driver lock. A setup command that already
+// ran on its server is not undone by a rollback.
+func (d *sshfsDriver) batchCreate(r *batchCreateRequest) *batchCreateResponse {
+	logrus.WithFields(logrus.Fields{"method": "batch create", "atomic": r.Atomic}).Debug(len(r.Volumes))
+
+	resp := &batchCreateResponse{Volumes: make([]batchCreateResult, len(r.Volumes))}
+	pending := make([]*sshfsVolume, len(r.Volumes))
+	failed := false
+	fail := func(i int, err error) {
+		resp.Volumes[i].Error = err.Error()
+		failed = true
+	}
+	// Validated volumes are reserved in d.creating until provisioned, so
+	// that the checks of later ones see them.
+	release := func() {
+		for i, v := range pending {
+			if v != nil {
+				delete(d.creating, resp.Volumes[i].Name)
+			}
+		}
+	}
+	rollback := func(all bool) {
+		for i, v := range pending {
+			if v == nil || (!all && resp.Volumes[i].Error == "") {
+				continue
+			}
+			name := resp.Volumes[i].Name
+			if d.volumes[name] == v {
+				delete(d.volumes, name)
+			}
+			d.discardCreate(name, v)
+			pending[i] = nil
+		}
+	}
+
+	d.Lock()
+	for i, req := range r.Volumes {
+		resp.Volumes[i].Name = req.Name
+		logrus.WithField("method", "batch create").Debugf("%#v", &volume.CreateRequest{Name: req.Name, Options: redactOptions(req.Options)})
+		v, exists, err := d.checkCreate(req.Name, req.Options)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		if !exists {
+			pending[i] = v
+			d.creating[req.Name] = v
+		}
+	}
+	if failed && r.Atomic {
+		release()
+		d.Unlock()
+		resp.RolledBack = true
+		return resp
+	}
+	d.Unlock()
+
+	for i, v := range pending {
+		if v == nil {
+			continue
+		}
+		if err := d.provision(resp.Volumes[i].Name, v); err != nil {
+			fail(i, err)
+			if r.Atomic {
+				break
+			}
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	release()
+	for i, v := range pending {
+		if v == nil || resp.Volumes[i].Error != "" {
+			continue
+		}
+		if err := d.recheckCreate(resp.Volumes[i].Name, v); err != nil {
+			fail(i, err)
+		}
+	}
+
+	if failed && r.Atomic {
+		rollback(true)
+		resp.RolledBack = true
+		return resp
+	}
+	rollback(false)
+
+	created := false
+	for i, v := range pending {
+		if v != nil {
+			d.volumes[resp.Volumes[i].Name] = v
+			created = true
+		}
+	}
+	if created {
+		if err := d.persist(); err != nil {
+			err = logError("failed to save state: %v", err)
+			for i, v := range pending {
+				if v != nil {
+					resp.Volumes[i].Error = err.Error()
+				}
+			}
+			rollback(true)
+			resp.RolledBack = true
+			return resp
+		}
+	}
+	for i, v := range pending {
+		if v != nil {
+			resp.Volumes[i].Created = true
+			d.emit("create", resp.Volumes[i].Name, "")
+		}
+	}
+	return resp
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestBatchCreate tests creating several volumes with one request
+func TestBatchCreate(t *testing.T) {
+	batch := func(atomic bool) string {
+		return `{"atomic": ` + map[bool]string{true: "true", false: "false"}[atomic] + `, "volumes": [
+			{"Name": "first", "Opts": {"sshcmd": "user@host:/first"}},
+			{"Name": "invalid", "Opts": {"min_free_percent": "150", "sshcmd": "user@host:/invalid"}},
+			{"Name": "second", "Opts": {"sshcmd": "user@host:/second", "password": "hunter2"}}
+		]}`
+	}
+	post := func(t *testing.T, driver *sshfsDriver, body string) (int, batchCreateResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/volumes", bytes.NewBufferString(body)))
+		var resp batchCreateResponse
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode batch response: %v", err)
+			}
+		}
+		return rec.Code, resp
+	}
+	countSaves := func(driver *sshfsDriver) *int {
+		saves := 0
+		driver.marshalState = func(v interface{}) ([]byte, error) {
+			saves++
+			return json.Marshal(v)
+		}
+		return &saves
+	}
+
+	t.Run("atomic batch is rolled back", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		saves := countSaves(driver)
+
+		code, resp := post(t, driver, batch(true))
+		AssertEqual(t, http.StatusOK, code, "status code")
+		AssertEqual(t, true, resp.RolledBack, "rolled back")
+		AssertEqual(t, 3, len(resp.Volumes), "results")
+		for i, name := range []string{"first", "invalid", "second"} {
+			AssertEqual(t, name, resp.Volumes[i].Name, "result order")
+			AssertEqual(t, false, resp.Volumes[i].Created, name+" created")
+		}
+		AssertContains(t, resp.Volumes[1].Error, "min_free_percent", "invalid volume error")
+		AssertEqual(t, "", resp.Volumes[0].Error, "error of a valid volume")
+		AssertEqual(t, 0, len(driver.volumes), "volumes")
+		AssertEqual(t, 0, *saves, "state writes")
+	})
+
+	t.Run("invalid names are rejected before provisioning", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		code, resp := post(t, driver, `{"volumes": [
+			{"Name": "../../volumes", "Opts": {"sshcmd": "user@host:/path", "setup_command": "true"}},
+			{"Name": "..", "Opts": {"sshcmd": "user@host:/path"}}
+		]}`)
+		AssertEqual(t, http.StatusOK, code, "status code")
+		for _, result := range resp.Volumes {
+			AssertContains(t, result.Error, "invalid volume name", result.Name+" error")
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		AssertEqual(t, 0, len(driver.volumes), "volumes")
+	})
+
+	t.Run("non-atomic batch keeps the valid volumes", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		saves := countSaves(driver)
+
+		code, resp := post(t, driver, batch(false))
+		AssertEqual(t, http.StatusOK, code, "status code")
+		AssertEqual(t, false, resp.RolledBack, "rolled back")
+		AssertEqual(t, true, resp.Volumes[0].Created, "first created")
+		AssertEqual(t, false, resp.Volumes[1].Created, "invalid created")
+		AssertNotEqual(t, "", resp.Volumes[1].Error, "invalid volume error")
+		AssertEqual(t, true, resp.Volumes[2].Created, "second created")
+		AssertEqual(t, 1, *saves, "state writes")
+
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to restart driver: %v", err)
+		}
+		AssertEqual(t, 2, len(restarted.volumes), "persisted volumes")
+		AssertEqual(t, "hunter2", restarted.volumes["second"].Password, "persisted password")
+
+		// Repeating the batch leaves the existing volumes alone.
+		_, resp = post(t, driver, batch(false))
+		AssertEqual(t, false, resp.Volumes[0].Created, "first created again")
+		AssertEqual(t, "", resp.Volumes[0].Error, "error of an existing volume")
+		AssertEqual(t, 1, *saves, "state writes")
+	})
+
+	t.Run("conflict within the batch", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		_, resp := post(t, driver, `{"volumes": [
+			{"Name": "vol", "Opts": {"sshcmd": "user@host:/a"}},
+			{"Name": "vol", "Opts": {"sshcmd": "user@host:/b"}}
+		]}`)
+		AssertEqual(t, true, resp.Volumes[0].Created, "first entry created")
+		AssertContains(t, resp.Volumes[1].Error, "already exists", "second entry error")
+		AssertEqual(t, "user@host:/a", driver.volumes["vol"].Sshcmd, "kept definition")
+	})
+
+	t.Run("malformed request", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		code, _ := post(t, driver, `{"volumes": {}}`)
+		AssertEqual(t, http.StatusBadRequest, code, "status code")
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// benchmarkBytes is how much data Benchmark pulls from the remote.
+const benchmarkBytes = 4 << 20
+
+// benchmarkResult is the outcome of a Benchmark run.
+type benchmarkResult struct {
+	LatencyMs float64 `json:"latency_ms"`
+	Bytes     int     `json:"bytes"`
+	MBps      float64 `json:"mb_per_sec"`
+}
+
+// Benchmark measures the round-trip latency and a rough throughput to the
+// volume's remote over ssh, without mounting it. Latency is the time of a
+// no-op ssh session; throughput is a fixed-size transfer with that session
+// overhead subtracted. Operators reach it at POST /volumes/<name>/benchmark.
+func (d *sshfsDriver) Benchmark(name string) (*benchmarkResult, error) {
+	logrus.WithField("method", "benchmark").Debug(name)
+
+	d.RLock()
+	v, ok := d.volumes[name]
+	if !ok {
+		d.RUnlock()
+		return nil, logError("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	vol := *v
+	d.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.BenchmarkTimeout)
+	defer cancel()
+
+	latency, err := d.measureLatency(ctx, &vol)
+	if err != nil {
+		return nil, logError("benchmark of %s failed: %v", name, err)
+	}
+
+	cmd, err := d.sshCommand(ctx, &vol, fmt.Sprintf("head -c %d /dev/zero", benchmarkBytes))
+	if err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	start := d.now()
+	output, err := d.executor.Run(cmd)
+	if err != nil {
+		return nil, logError("benchmark of %s failed: %v", name, err)
+	}
+	elapsed := d.now().Sub(start) - latency
+	if len(output) != benchmarkBytes {
+		return nil, logError("benchmark of %s received %d of %d bytes", name, len(output), benchmarkBytes)
+	}
+	if elapsed <= 0 {
+		elapsed = time.Millisecond
+	}
+
+	return &benchmarkResult{
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+		Bytes:     len(output),
+		MBps:      float64(len(output)) / 1e6 / elapsed.Seconds(),
+	}, nil
+}
+
+// measureLatency times a no-op ssh session to the volume's server.
+func (d *sshfsDriver) measureLatency(ctx context.Context, v *sshfsVolume) (time.Duration, error) {
+	cmd, err := d.sshCommand(ctx, v, "true")
+	if err != nil {
+		return 0, err
+	}
+	start := d.now()
+	if output, err := d.executor.Run(cmd); err != nil {
+		return 0, fmt.Errorf("%v (%s)", err, output)
+	}
+	return d.now().Sub(start), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a now function that yields the given offsets from a
+// fixed start time, one per call
+func fakeClock(offsets ...time.Duration) func() time.Time {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	i := 0
+	return func() time.Time {
+		offset := offsets[len(offsets)-1]
+		if i < len(offsets) {
+			offset = offsets[i]
+		}
+		i++
+		return start.Add(offset)
+	}
+}
+
+// TestBenchmark tests latency and throughput measurement over ssh
+func TestBenchmark(t *testing.T) {
+	t.Run("measures latency and throughput", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		driver.volumes["test-volume"] = &sshfsVolume{
+			Sshcmd: "user@host:/path",
+			Port:   "2222",
+		}
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(bytes.Repeat([]byte{0}, benchmarkBytes), nil)
+		driver.executor = executor
+
+		// 20ms for the no-op session, 1s20ms for the transfer
+		driver.now = fakeClock(0, 20*time.Millisecond, 20*time.Millisecond, 1040*time.Millisecond)
+
+		result, err := driver.Benchmark("test-volume")
+		if err != nil {
+			t.Fatalf("Failed to benchmark volume: %v", err)
+		}
+
+		if result.LatencyMs != 20 {
+			t.Errorf("Expected latency of 20ms, got %v", result.LatencyMs)
+		}
+
+		if result.Bytes != benchmarkBytes {
+			t.Errorf("Expected %d bytes, got %d", benchmarkBytes, result.Bytes)
+		}
+
+		expected := float64(benchmarkBytes) / 1e6
+		if result.MBps != expected {
+			t.Errorf("Expected %v MB/s, got %v", expected, result.MBps)
+		}
+
+		executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR -p 2222 user@host true")
+		executor.AssertCommand(t, fmt.Sprintf("ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR -p 2222 user@host head -c %d /dev/zero", benchmarkBytes))
+	})
+
+	t.Run("password volumes use the askpass helper", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		driver.volumes["test-volume"] = &sshfsVolume{
+			Sshcmd:   "user@host:/path",
+			Password: "secret",
+		}
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(bytes.Repeat([]byte{0}, benchmarkBytes), nil)
+		driver.executor = executor
+
+		if _, err := driver.Benchmark("test-volume"); err != nil {
+			t.Fatalf("Failed to benchmark volume: %v", err)
+		}
+
+		executor.AssertCommand(t, fmt.Sprintf("ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host head -c %d /dev/zero", benchmarkBytes))
+		env := strings.Join(executor.LastCmd().Env, "\n")
+		AssertContains(t, env, "SSH_ASKPASS_REQUIRE=force", "ssh environment")
+		AssertContains(t, env, askpassPasswordEnv+"=secret", "ssh environment")
+	})
+
+	t.Run("short transfer fails", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path"}
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse([]byte("truncated"), nil)
+		driver.executor = executor
+
+		if _, err := driver.Benchmark("test-volume"); err == nil {
+			t.Fatal("Expected error for a short transfer")
+		}
+	})
+
+	t.Run("benchmark non-existent volume fails", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		_, err := driver.Benchmark("non-existent")
+		if err == nil {
+			t.Fatal("Expected error when benchmarking non-existent volume")
+		}
+	})
+	t.Run("served on the control API", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path"}
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(bytes.Repeat([]byte{0}, benchmarkBytes), nil)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse([]byte("truncated"), nil)
+		driver.executor = executor
+		driver.now = fakeClock(0, 20*time.Millisecond, 20*time.Millisecond, 1040*time.Millisecond)
+
+		post := func(name string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/volumes/"+name+"/benchmark", nil))
+			return rec
+		}
+
+		rec := post("test-volume")
+		AssertEqual(t, http.StatusOK, rec.Code, "status")
+		var result benchmarkResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Failed to decode result: %v", err)
+		}
+		AssertEqual(t, float64(20), result.LatencyMs, "latency")
+		AssertEqual(t, benchmarkBytes, result.Bytes, "bytes")
+
+		rec = post("test-volume")
+		AssertEqual(t, http.StatusBadGateway, rec.Code, "status of a failed benchmark")
+		AssertContains(t, rec.Body.String(), "received 9 of", "body")
+
+		AssertEqual(t, http.StatusNotFound, post("missing").Code, "status of an unknown volume")
+	})
+}
+
+// TestSplitSshcmd tests splitting sshcmd into destination and remote path
+func TestSplitSshcmd(t *testing.T) {
+	tests := []struct {
+		sshcmd string
+		dest   string
+		path   string
+	}{
+		{"user@host:/path", "user@host", "/path"},
+		{"host:data", "host", "data"},
+		{"user@[::1]:/path", "user@[::1]", "/path"},
+		{"user@host:/a:b", "user@host", "/a:b"},
+		{"host", "host", ""},
+	}
+
+	for _, tt := range tests {
+		dest, path := splitSshcmd(tt.sshcmd)
+		if dest != tt.dest || path != tt.path {
+			t.Errorf("splitSshcmd(%q) = %q, %q; expected %q, %q", tt.sshcmd, dest, path, tt.dest, tt.path)
+		}
+	}
+}
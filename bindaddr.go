@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// localAddress checks that val is an IP address assigned to an interface of
+// the plugin host, as ssh can only bind its outgoing connections to one of
+// those, and returns it in canonical form.
+func localAddress(key, val string, interfaceAddrs func() ([]net.Addr, error)) (string, error) {
+	ip, err := netip.ParseAddr(val)
+	if err != nil || ip.Zone() != "" {
+		return "", fmt.Errorf("invalid value %q for option %s, expected an IP address", val, key)
+	}
+	ip = ip.Unmap()
+
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list local addresses for option %s: %v", key, err)
+	}
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err == nil && prefix.Addr().Unmap() == ip {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("invalid value %q for option %s, the address is not assigned to any local interface", val, key)
+}
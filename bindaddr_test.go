@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestBindAddress tests that bind_address only accepts local addresses
+func TestBindAddress(t *testing.T) {
+	localAddrs := func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("10.1.2.3"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("2001:db8::5"), Mask: net.CIDRMask(64, 128)},
+		}, nil
+	}
+
+	for _, tt := range []struct {
+		val     string
+		want    string
+		wantErr string
+	}{
+		{"10.1.2.3", "BindAddress=10.1.2.3", ""},
+		{"2001:db8:0::5", "BindAddress=2001:db8::5", ""},
+		{"10.1.2.4", "", "the address is not assigned to any local interface"},
+		{"eth0", "", "expected an IP address"},
+		{"fe80::1%eth0", "", "expected an IP address"},
+	} {
+		t.Run(tt.val, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.interfaceAddrs = localAddrs
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", "bind_address": tt.val},
+			})
+			if tt.wantErr != "" {
+				AssertError(t, err, "create")
+				AssertContains(t, err.Error(), tt.wantErr, "create error")
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+			AssertContains(t, strings.Join(executor.LastCmd().Args, " "), "-o "+tt.want, "mount command")
+		})
+	}
+
+	t.Run("BindAddress given as well", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.interfaceAddrs = localAddrs
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "bind_address": "10.1.2.3", "BindAddress": "10.1.2.3"},
+		})
+		AssertError(t, err, "create")
+		AssertContains(t, err.Error(), "cannot be combined with BindAddress", "create error")
+	})
+
+	t.Run("interfaces cannot be listed", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.interfaceAddrs = func() ([]net.Addr, error) { return nil, errors.New("netlink: permission denied") }
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "bind_address": "10.1.2.3"},
+		})
+		AssertError(t, err, "create")
+		AssertContains(t, err.Error(), "failed to list local addresses", "create error")
+	})
+}
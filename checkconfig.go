@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// checkConfig validates a configuration without starting the driver: the
+// option lists, the path base, the volumes manifest and the credentials
+// file and the files they reference, and the tools the driver runs. It returns every
+// problem found rather than stopping at the first.
+func checkConfig(config driverConfig, lookPath func(string) (string, error)) []error {
+	var problems []error
+
+	for _, key := range config.DeniedOptions {
+		if containsFold(config.AllowedOptions, key) {
+			problems = append(problems, fmt.Errorf("option %s is both allowed and denied", key))
+		}
+	}
+	if config.PathBase != "" {
+		if fi, err := os.Stat(config.PathBase); err != nil {
+			problems = append(problems, fmt.Errorf("PATH_BASE: %v", err))
+		} else if !fi.IsDir() {
+			problems = append(problems, fmt.Errorf("PATH_BASE %s is not a directory", config.PathBase))
+		}
+	}
+
+	if _, err := lookPath("sshfs"); err != nil {
+		problems = append(problems, fmt.Errorf("sshfs is not installed: %v", err))
+	}
+	if _, err := selectUnmountTool(config.UnmountTools, lookPath); err != nil {
+		problems = append(problems, err)
+	}
+
+	if config.VolumesManifest != "" {
+		problems = append(problems, checkManifest(config, lookPath)...)
+	}
+	if config.CredentialsFile != "" {
+		problems = append(problems, checkCredentials(config)...)
+	}
+	return problems
+}
+
+// checkManifest parses every volume of the manifest and checks the files
+// it references.
+func checkManifest(config driverConfig, lookPath func(string) (string, error)) []error {
+	manifest, err := readManifest(config.VolumesManifest)
+	if err != nil {
+		return []error{err}
+	}
+
+	d := newDriver("/mnt", config)
+	d.lookPath = lookPath
+	var problems []error
+	for _, name := range manifestNames(manifest) {
+		v, err := d.parseManifestVolume(name, manifest[name])
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		for _, file := range volumeFiles(v) {
+			if err := d.checkVolumeFile(file.key, file.path); err != nil {
+				problems = append(problems, fmt.Errorf("volume %s in manifest: %v", name, err))
+			}
+		}
+	}
+	return problems
+}
+
+// volumeFile is a local file a volume option refers to.
+type volumeFile struct {
+	key, path string
+}
+
+// volumeFiles returns the local files the volume's options refer to.
+func volumeFiles(v *sshfsVolume) []volumeFile {
+	var files []volumeFile
+	if v.SSHConfig != "" {
+		files = append(files, volumeFile{"ssh_config", v.SSHConfig})
+	}
+	if v.PasswordFile != "" {
+		files = append(files, volumeFile{"password_file", v.PasswordFile})
+	}
+	for _, option := range v.Options {
+		if key, val, ok := strings.Cut(option, "="); ok && isPathOption(key) {
+			files = append(files, volumeFile{key, val})
+		}
+	}
+	return files
+}
+
+// checkVolumeFile checks that the file of an option exists and that its
+// permissions are ones ssh accepts: secrets must not be readable, and
+// other files not writable, by anyone but their owner.
+func (d *sshfsDriver) checkVolumeFile(key, val string) error {
+	p, err := d.expandPath(val)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s %s does not exist", key, p)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s %s is a directory", key, p)
+	}
+
+	mode := fi.Mode().Perm()
+	if (strings.EqualFold(key, "IdentityFile") || key == "password_file") && mode&0o077 != 0 {
+		return fmt.Errorf("%s %s has mode %04o, it must not be accessible by group or others", key, p, mode)
+	}
+	if mode&0o022 != 0 {
+		return fmt.Errorf("%s %s has mode %04o, it must not be writable by group or others", key, p, mode)
+	}
+	return nil
+}
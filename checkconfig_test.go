@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCheckConfig tests validating the configuration without starting
+func TestCheckConfig(t *testing.T) {
+	installed := func(name string) (string, error) { return "/usr/bin/" + name, nil }
+
+	setup := func(t *testing.T) (string, driverConfig) {
+		t.Helper()
+		tmpDir := t.TempDir()
+		for name, mode := range map[string]os.FileMode{"id_ed25519": 0o600, "known_hosts": 0o644} {
+			if err := os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), mode); err != nil {
+				t.Fatalf("Failed to write %s: %v", name, err)
+			}
+		}
+		manifest := fmt.Sprintf(`{
+			"app": {"sshcmd": "user@host:/app", "IdentityFile": %q, "UserKnownHostsFile": %q}
+		}`, filepath.Join(tmpDir, "id_ed25519"), filepath.Join(tmpDir, "known_hosts"))
+		path := filepath.Join(tmpDir, "manifest.json")
+		if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		config := defaultDriverConfig()
+		config.VolumesManifest = path
+		return tmpDir, config
+	}
+
+	t.Run("valid configuration passes", func(t *testing.T) {
+		_, config := setup(t)
+		if problems := checkConfig(config, installed); len(problems) != 0 {
+			t.Errorf("Expected no problems, got %v", problems)
+		}
+	})
+
+	t.Run("missing key file fails", func(t *testing.T) {
+		tmpDir, config := setup(t)
+		key := filepath.Join(tmpDir, "id_ed25519")
+		os.Remove(key)
+
+		problems := checkConfig(config, installed)
+		if len(problems) != 1 {
+			t.Fatalf("Expected one problem, got %v", problems)
+		}
+		AssertEqual(t, "volume app in manifest: IdentityFile "+key+" does not exist", problems[0].Error(), "problem")
+	})
+
+	t.Run("readable key file fails", func(t *testing.T) {
+		tmpDir, config := setup(t)
+		os.Chmod(filepath.Join(tmpDir, "id_ed25519"), 0o644)
+
+		problems := checkConfig(config, installed)
+		if len(problems) != 1 {
+			t.Fatalf("Expected one problem, got %v", problems)
+		}
+		AssertContains(t, problems[0].Error(), "has mode 0644, it must not be accessible by group or others", "problem")
+	})
+
+	t.Run("readable key in credentials file fails", func(t *testing.T) {
+		tmpDir, config := setup(t)
+		key := filepath.Join(tmpDir, "id_ed25519")
+		os.Chmod(key, 0o640)
+		config.CredentialsFile = filepath.Join(tmpDir, "credentials.json")
+		if err := os.WriteFile(config.CredentialsFile, []byte(fmt.Sprintf(`{"nas": {"identity_file": %q}}`, key)), 0o600); err != nil {
+			t.Fatalf("Failed to write credentials file: %v", err)
+		}
+
+		problems := checkConfig(config, installed)
+		if len(problems) != 2 {
+			t.Fatalf("Expected two problems, got %v", problems)
+		}
+		AssertContains(t, problems[1].Error(), "host nas in credentials file: IdentityFile "+key+" has mode 0640", "problem")
+	})
+
+	t.Run("manifest volume using the credentials file", func(t *testing.T) {
+		tmpDir, config := setup(t)
+		config.CredentialsFile = filepath.Join(tmpDir, "credentials.json")
+		creds := fmt.Sprintf(`{"nas": {"identity_file": %q}}`, filepath.Join(tmpDir, "id_ed25519"))
+		if err := os.WriteFile(config.CredentialsFile, []byte(creds), 0o600); err != nil {
+			t.Fatalf("Failed to write credentials file: %v", err)
+		}
+		if err := os.WriteFile(config.VolumesManifest, []byte(`{"nas": {"sshcmd": "user@nas:/data"}}`), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+
+		if problems := checkConfig(config, installed); len(problems) != 0 {
+			t.Errorf("Expected no problems, got %v", problems)
+		}
+	})
+
+	t.Run("manifest volume with bind_address", func(t *testing.T) {
+		_, config := setup(t)
+		manifest := `{
+			"local": {"sshcmd": "user@host:/local", "bind_address": "127.0.0.1"},
+			"remote": {"sshcmd": "user@host:/remote", "bind_address": "192.0.2.1"}
+		}`
+		if err := os.WriteFile(config.VolumesManifest, []byte(manifest), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+
+		problems := checkConfig(config, installed)
+		if len(problems) != 1 {
+			t.Fatalf("Expected one problem, got %v", problems)
+		}
+		AssertContains(t, problems[0].Error(), "volume remote in manifest", "problem")
+		AssertContains(t, problems[0].Error(), "not assigned to any local interface", "problem")
+	})
+
+	t.Run("every problem is reported", func(t *testing.T) {
+		tmpDir, config := setup(t)
+		os.Remove(filepath.Join(tmpDir, "id_ed25519"))
+		config.AllowedOptions = []string{"IdentityFile", "UserKnownHostsFile", "allow_other"}
+		config.DeniedOptions = []string{"allow_other"}
+
+		problems := checkConfig(config, func(string) (string, error) { return "", os.ErrNotExist })
+		AssertEqual(t, 4, len(problems), "problems")
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkpoint writes the state if it differs from what the state file last
+// held, catching changes whose save was skipped or failed. It reports
+// whether it wrote.
+func (d *sshfsDriver) checkpoint() (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	data, err := d.stateData()
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, d.savedState) {
+		return false, nil
+	}
+	if err := d.saveState(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkpointLoop runs checkpoint about every interval, forever. Each wait is
+// jittered, so that drivers started together do not write together.
+func (d *sshfsDriver) checkpointLoop(interval time.Duration) {
+	for {
+		d.sleep(jitter(interval))
+		if wrote, err := d.checkpoint(); err != nil {
+			logrus.WithField("statePath", d.statePath).Warnf("state checkpoint failed: %v", err)
+		} else if wrote {
+			logrus.WithField("statePath", d.statePath).Debug("state checkpoint written")
+		}
+	}
+}
+
+// jitter returns a random duration within 20% of interval.
+func jitter(interval time.Duration) time.Duration {
+	spread := int64(interval) * 2 / 5
+	if spread <= 0 {
+		return interval
+	}
+	return interval - time.Duration(spread/2) + time.Duration(rand.Int64N(spread))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestCheckpoint tests that periodic checkpoints only write changed state
+func TestCheckpoint(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	saved, err := os.ReadFile(driver.statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+
+	wrote, err := driver.checkpoint()
+	AssertNoError(t, err, "clean checkpoint")
+	AssertEqual(t, false, wrote, "clean checkpoint wrote")
+	AssertFileNotExists(t, driver.statePath+".bak")
+
+	// Stand in for a change whose save was skipped.
+	driver.volumes["test-volume"].Disabled = true
+
+	sleeps := make(chan time.Duration)
+	stop := make(chan struct{})
+	defer close(stop)
+	driver.sleep = func(d time.Duration) {
+		select {
+		case sleeps <- d:
+		case <-stop:
+			runtime.Goexit()
+		}
+	}
+	go driver.checkpointLoop(time.Minute)
+
+	// The loop has run a checkpoint once it waits for the second time.
+	for i := 0; i < 2; i++ {
+		wait := <-sleeps
+		if wait < 48*time.Second || wait >= 72*time.Second {
+			t.Errorf("Expected a wait within 20%% of a minute, got %v", wait)
+		}
+	}
+	restarted, err := newSshfsDriver(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart driver: %v", err)
+	}
+	AssertEqual(t, true, restarted.volumes["test-volume"].Disabled, "checkpointed state")
+	backup, err := os.ReadFile(driver.statePath + ".bak")
+	AssertNoError(t, err, "read backup")
+	AssertEqual(t, string(saved), string(backup), "backup of the previous state")
+
+	// Nothing changed since, so the next round leaves the file alone.
+	info, err := os.Stat(driver.statePath)
+	AssertNoError(t, err, "stat state")
+	<-sleeps
+	after, err := os.Stat(driver.statePath)
+	AssertNoError(t, err, "stat state")
+	AssertEqual(t, true, os.SameFile(info, after), "state file left in place")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// compressionDecision is what auto_compression decided for a volume.
+type compressionDecision struct {
+	Compression string  `json:"compression"`
+	LatencyMs   float64 `json:"latency_ms"`
+}
+
+// roundTripSamples is how many no-op sessions measureRoundTrip times over
+// the established connection; the fastest counts.
+const roundTripSamples = 3
+
+// decideCompression measures the round-trip time to the volume's server
+// and turns compression on when it exceeds AutoCompressionLatency, where
+// the link is likely slow enough for compression to pay off. The decision
+// is kept with the volume, so remounts do not measure again.
+func (d *sshfsDriver) decideCompression(name string, v *sshfsVolume) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.BenchmarkTimeout)
+	defer cancel()
+
+	latency, err := d.measureRoundTrip(ctx, v)
+	if err != nil {
+		return err
+	}
+	v.LatencyMs = float64(latency) / float64(time.Millisecond)
+	v.Compression = "no"
+	if latency > d.config.AutoCompressionLatency {
+		v.Compression = "yes"
+	}
+	logrus.WithFields(logrus.Fields{"volume": name, "latency": latency, "compression": v.Compression}).Info("decided compression from the measured latency")
+	return nil
+}
+
+// measureRoundTrip measures the round-trip time to the volume's server
+// without the cost of starting ssh and setting up a connection, which
+// dwarfs it on a LAN: it opens one master connection and times no-op
+// sessions multiplexed over it. Each takes about two round trips, opening
+// the channel and running the command, so half of the fastest is returned.
+func (d *sshfsDriver) measureRoundTrip(ctx context.Context, v *sshfsVolume) (time.Duration, error) {
+	dir, err := os.MkdirTemp("", "sshfs-rtt-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	control := filepath.Join(dir, "control")
+
+	master, err := d.sshCommandWith(ctx, v, []string{"-M", "-S", control, "-f", "-N"})
+	if err != nil {
+		return 0, err
+	}
+	// Older ssh keeps the output pipes open once it went to the background.
+	master.WaitDelay = time.Second
+	start := d.now()
+	if output, err := d.executor.Run(master); err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		return 0, fmt.Errorf("%v (%s)", err, output)
+	}
+	logrus.WithField("handshake", d.now().Sub(start)).Debug("opened connection to measure the round-trip time")
+	defer func() {
+		stop, err := d.sshCommandWith(context.Background(), v, []string{"-S", control, "-O", "exit"})
+		if err == nil {
+			d.executor.Run(stop)
+		}
+	}()
+
+	var fastest time.Duration
+	for i := 0; i < roundTripSamples; i++ {
+		cmd, err := d.sshCommandWith(ctx, v, []string{"-S", control}, "true")
+		if err != nil {
+			return 0, err
+		}
+		start := d.now()
+		if output, err := d.executor.Run(cmd); err != nil {
+			return 0, fmt.Errorf("%v (%s)", err, output)
+		}
+		if elapsed := d.now().Sub(start); i == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	return fastest / 2, nil
+}
+
+// reprobeCompression measures the latency of a volume with auto_compression
+// again and records the new decision, which applies from its next mount.
+func (d *sshfsDriver) reprobeCompression(name string) (*compressionDecision, error) {
+	d.RLock()
+	v, ok := d.volumes[name]
+	if !ok {
+		d.RUnlock()
+		return nil, logError("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	vol := *v
+	d.RUnlock()
+
+	if !vol.AutoCompression {
+		return nil, logError("volume %s does not use auto_compression", name)
+	}
+	if err := d.decideCompression(name, &vol); err != nil {
+		return nil, logError("measuring the latency of volume %s failed: %v", name, err)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	v, ok = d.volumes[name]
+	if !ok {
+		return nil, logError("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	v.Compression, v.LatencyMs = vol.Compression, vol.LatencyMs
+	if err := d.persist(); err != nil {
+		return nil, logError("failed to save state: %v", err)
+	}
+	return &compressionDecision{Compression: v.Compression, LatencyMs: v.LatencyMs}, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// roundTripClock returns a clock for measureRoundTrip: opening the
+// connection takes handshake, and the multiplexed sessions take two round
+// trips of rtt each, the first two slower.
+func roundTripClock(handshake, rtt time.Duration) func() time.Time {
+	offsets := []time.Duration{0, handshake}
+	for i := roundTripSamples; i > 0; i-- {
+		start := offsets[len(offsets)-1]
+		offsets = append(offsets, start, start+2*rtt+time.Duration(i-1)*time.Millisecond)
+	}
+	return fakeClock(offsets...)
+}
+
+// TestAutoCompression tests that auto_compression decides from the measured
+// latency and keeps its decision across remounts
+func TestAutoCompression(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		latency     time.Duration
+		compression string
+	}{
+		{"high latency enables compression", 80 * time.Millisecond, "yes"},
+		{"low latency disables compression", 2 * time.Millisecond, "no"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			// Setting up the connection takes far longer than the
+			// threshold even on a LAN, and must not count.
+			driver.now = roundTripClock(150*time.Millisecond, tt.latency)
+
+			executor := NewTestCommandExecutor()
+			for i := 0; i < 9; i++ {
+				executor.AddMockResponse(nil, nil)
+			}
+			driver.executor = executor
+
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", "auto_compression": "true"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			control := executor.GetCommands()[0][5]
+			executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR -M -S "+control+" -f -N user@host")
+			executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR -S "+control+" user@host true")
+			executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR -S "+control+" -O exit user@host")
+			AssertEqual(t, 5, executor.GetCommandCount(), "master, three sessions and exit")
+			AssertEqual(t, tt.compression, driver.volumes["test-volume"].Compression, "decision")
+			AssertEqual(t, float64(tt.latency)/float64(time.Millisecond), driver.volumes["test-volume"].LatencyMs, "measured round trip")
+			AssertDirNotExists(t, filepath.Dir(control))
+
+			// The decision survives a restart and is not measured again.
+			driver, err = newSshfsDriver(tmpDir)
+			if err != nil {
+				t.Fatalf("Failed to restart driver: %v", err)
+			}
+			driver.executor = executor
+			for i := 0; i < 2; i++ {
+				if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+					t.Fatalf("Failed to mount volume: %v", err)
+				}
+				AssertContains(t, strings.Join(executor.LastCmd().Args, " "), "-o Compression="+tt.compression, "mount command")
+				if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+					t.Fatalf("Failed to unmount volume: %v", err)
+				}
+				AssertEqual(t, "sshfs", executor.GetCommands()[5+2*i][0], "mount without a new measurement")
+			}
+
+			resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+			AssertNoError(t, err, "get")
+			AssertEqual(t, tt.compression, resp.Volume.Status["compression"], "compression status")
+		})
+	}
+
+	t.Run("re-probe changes the decision", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.now = roundTripClock(150*time.Millisecond, 2*time.Millisecond)
+
+		executor := NewTestCommandExecutor()
+		for i := 0; i < 10; i++ {
+			executor.AddMockResponse(nil, nil)
+		}
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "auto_compression": "true"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		AssertEqual(t, "no", driver.volumes["test-volume"].Compression, "decision at create")
+
+		driver.now = roundTripClock(150*time.Millisecond, 150*time.Millisecond)
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/volumes/test-volume/compression", nil))
+		AssertEqual(t, http.StatusOK, rec.Code, "status code")
+		AssertContains(t, rec.Body.String(), `"compression":"yes"`, "response")
+		AssertEqual(t, "yes", driver.volumes["test-volume"].Compression, "decision after re-probe")
+		AssertEqual(t, float64(150), driver.volumes["test-volume"].LatencyMs, "measured latency")
+	})
+
+	t.Run("cannot be combined with compression", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "auto_compression": "true", "compression": "no"},
+		})
+		AssertError(t, err, "create")
+		AssertContains(t, err.Error(), "auto_compression cannot be combined with compression", "create error")
+	})
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// driverConfig holds the operator-level settings that apply to every volume.
+type driverConfig struct {
+	// Scope is the volume scope reported to Docker: "local", or "global"
+	// when every node reaches the same servers with the same credentials.
+	Scope string `json:"scope"`
+	// HomeDir is what a leading "~" expands to in file-path options.
+	HomeDir string `json:"home_dir"`
+	// PathBase, when set, is the directory that expanded file-path options
+	// must stay within.
+	PathBase string `json:"path_base"`
+	// DefaultUser and DefaultPort apply to volumes whose sshcmd has no user
+	// and that set no port option.
+	DefaultUser string `json:"default_user"`
+	DefaultPort string `json:"default_port"`
+	// DisableSshpass restricts the driver to key and agent authentication,
+	// rejecting passwords.
+	DisableSshpass bool `json:"disable_sshpass"`
+	// BenchmarkTimeout bounds a whole Benchmark run.
+	BenchmarkTimeout time.Duration `json:"benchmark_timeout"`
+	// PropagatedRoot is the plugin's propagated mount directory. Only mounts
+	// below it are visible to containers; it defaults to the volumes root.
+	PropagatedRoot string `json:"propagated_root"`
+	// ProbeCacheTTL is how long a successful TestConnection of a host is
+	// reused before the host is probed again.
+	ProbeCacheTTL time.Duration `json:"probe_cache_ttl"`
+	// RemoteCommandTimeout bounds each short-lived ssh command the driver
+	// runs on a volume's host, such as a setup_command or a connection test.
+	RemoteCommandTimeout time.Duration `json:"remote_command_timeout"`
+	// MaxClockSkew, when non-zero, makes connection tests compare the
+	// remote clock with the local one and warn when they differ by more.
+	MaxClockSkew time.Duration `json:"max_clock_skew"`
+	// KeyscanTimeout bounds a ScanHostKey run.
+	KeyscanTimeout time.Duration `json:"keyscan_timeout"`
+	// MountpointMode is the permission mode of mountpoint directories the
+	// driver creates, unless a volume sets mountpoint_mode.
+	MountpointMode os.FileMode `json:"mountpoint_mode"`
+	// AutoRemount replaces mounts whose sshfs connection has died instead of
+	// only reporting them as degraded.
+	AutoRemount bool `json:"auto_remount"`
+	// MountCheckTimeout is how long a stat of a mountpoint may take before
+	// its mount counts as hung, and with it as degraded.
+	MountCheckTimeout time.Duration `json:"mount_check_timeout"`
+	// RemovePolicy is what Remove does with a volume that has no
+	// connections but whose mountpoint, possibly shared with another
+	// volume, is still mounted: "strict" refuses, "unmount-if-unreferenced"
+	// unmounts it unless another volume uses the mountpoint, and "detach"
+	// only drops the volume.
+	RemovePolicy string `json:"remove_policy"`
+	// UnknownUnmountPolicy is what Unmount does for a volume the driver
+	// does not know, for example after it missed a Remove: "ignore"
+	// treats it as already unmounted, "error" fails the request.
+	UnknownUnmountPolicy string `json:"unknown_unmount_policy"`
+	// ReconcileInterval, when non-zero, is how often connection counts are
+	// checked against the mount table, so that volumes Docker never
+	// unmounted, for example after a daemon crash, can be removed again.
+	ReconcileInterval time.Duration `json:"reconcile_interval"`
+	// HealthCheckInterval, when non-zero, is how often every mounted
+	// volume is checked for a dead or hung mount, besides the check Get
+	// and Path make of their volume.
+	HealthCheckInterval time.Duration `json:"health_check_interval"`
+	// CheckpointInterval, when non-zero, is roughly how often the state is
+	// written if it changed without being saved.
+	CheckpointInterval time.Duration `json:"checkpoint_interval"`
+	// MountErrorWindow is the sliding window mount error rates are computed
+	// over.
+	MountErrorWindow time.Duration `json:"mount_error_window"`
+	// LogFile, when set, receives a copy of the driver log. It is rotated
+	// once it reaches LogMaxSize bytes, keeping LogMaxFiles old copies.
+	LogFile     string `json:"log_file"`
+	LogMaxSize  int64  `json:"log_max_size"`
+	LogMaxFiles int    `json:"log_max_files"`
+	// FeatureCheck is what happens when a volume requests an option the
+	// installed sshfs does not support: "warn", "error" or "off".
+	FeatureCheck string `json:"feature_check"`
+	// MaxHostMounts caps the sshfs mounts connected to one host at the
+	// same time; 0, the default, disables the cap. Mounts beyond it wait
+	// for another mount to that host to be unmounted, or fail if
+	// HostLimitPolicy is "fail".
+	MaxHostMounts   int    `json:"max_host_mounts"`
+	HostLimitPolicy string `json:"host_limit_policy"`
+	// AllowedOptions, when set, lists the only sshfs and ssh option keys
+	// volumes may pass through; DeniedOptions lists keys they may not.
+	// Options the driver handles itself are not subject to either.
+	AllowedOptions []string `json:"allowed_options"`
+	DeniedOptions  []string `json:"denied_options"`
+	// AllowedRemotePaths, when set, maps hosts to the remote path prefixes
+	// volumes may mount from them; the "" entry applies to every host.
+	AllowedRemotePaths map[string][]string `json:"allowed_remote_paths"`
+	// Compression, when set, is the ssh compression ("yes" or "no") of
+	// volumes that do not choose it themselves.
+	Compression string `json:"compression"`
+	// AutoCompressionLatency is the round-trip time above which volumes
+	// with auto_compression get compression. LAN and same-site links stay
+	// well below the default, WAN and VPN links reach it.
+	AutoCompressionLatency time.Duration `json:"auto_compression_latency"`
+	// VolumesManifest, when set, is a file of volumes to create at startup
+	// if they do not exist. ManifestPolicy is what happens to an existing
+	// volume the manifest declares with other options: "keep" or "update".
+	VolumesManifest string `json:"volumes_manifest"`
+	ManifestPolicy  string `json:"manifest_policy"`
+	// CredentialsFile, when set, maps hosts to the identity file and
+	// password file of volumes that do not configure their own.
+	CredentialsFile string `json:"credentials_file"`
+	// StateLoadRetries is how often a transient failure to read the state
+	// file at startup is retried, waiting StateLoadBackoff before the first
+	// retry and twice as long before each next one.
+	StateLoadRetries int           `json:"state_load_retries"`
+	StateLoadBackoff time.Duration `json:"state_load_backoff"`
+	// StateRetryInterval is how often the state is written again while
+	// the state directory is not writable.
+	StateRetryInterval time.Duration `json:"state_retry_interval"`
+	// NodeID is mixed into every mountpoint, so that nodes sharing a state
+	// directory never share mountpoints. It defaults to the hostname; see
+	// migrateMountpoints for volumes mounted when it changes.
+	NodeID string `json:"node_id"`
+	// MountNamespace, when set, is the mount namespace file, such as
+	// /proc/<pid>/ns/mnt, that mounts and unmounts are run in.
+	MountNamespace string `json:"mount_namespace"`
+	// ControlSocket, when set, is the unix socket of the control API.
+	ControlSocket string `json:"control_socket"`
+	// UnmountTools lists the unmount tools to use in order of preference;
+	// the first one installed is used.
+	UnmountTools []string `json:"unmount_tools"`
+}
+
+func defaultDriverConfig() driverConfig {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = "/root"
+	}
+	hostname, _ := os.Hostname()
+	return driverConfig{
+		NodeID:                 hostname,
+		Scope:                  "local",
+		HomeDir:                home,
+		BenchmarkTimeout:       30 * time.Second,
+		AutoCompressionLatency: 10 * time.Millisecond,
+		KeyscanTimeout:         10 * time.Second,
+		RemoteCommandTimeout:   30 * time.Second,
+		ProbeCacheTTL:          30 * time.Second,
+		MountpointMode:         0o755,
+		MountCheckTimeout:      10 * time.Second,
+		HealthCheckInterval:    time.Minute,
+		LogMaxSize:             10 << 20,
+		LogMaxFiles:            5,
+		UnmountTools:           unmountTools,
+		FeatureCheck:           "warn",
+		HostLimitPolicy:        "wait",
+		RemovePolicy:           "strict",
+		UnknownUnmountPolicy:   "ignore",
+		ManifestPolicy:         "keep",
+		MountErrorWindow:       5 * time.Minute,
+		CheckpointInterval:     15 * time.Minute,
+		StateLoadRetries:       3,
+		StateLoadBackoff:       100 * time.Millisecond,
+		StateRetryInterval:     30 * time.Second,
+	}
+}
+
+// driverConfigFromEnv returns the default configuration overridden by any
+// settings supplied through the plugin environment.
+func driverConfigFromEnv() (driverConfig, error) {
+	cfg := defaultDriverConfig()
+	if v := os.Getenv("SCOPE"); v != "" {
+		if v != "local" && v != "global" {
+			return cfg, fmt.Errorf("invalid SCOPE value %q", v)
+		}
+		cfg.Scope = v
+	}
+	if v := os.Getenv("SSH_HOME"); v != "" {
+		cfg.HomeDir = v
+	}
+	if v := os.Getenv("PATH_BASE"); v != "" {
+		cfg.PathBase = v
+	}
+	if v := os.Getenv("DEFAULT_USER"); v != "" {
+		cfg.DefaultUser = v
+	}
+	if v := os.Getenv("DEFAULT_PORT"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+			return cfg, fmt.Errorf("invalid DEFAULT_PORT value %q", v)
+		}
+		cfg.DefaultPort = v
+	}
+	if v := os.Getenv("DISABLE_SSHPASS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid DISABLE_SSHPASS value %q", v)
+		}
+		cfg.DisableSshpass = b
+	}
+	if v := os.Getenv("PROPAGATED_ROOT"); v != "" {
+		cfg.PropagatedRoot = v
+	}
+	if v := os.Getenv("PROBE_CACHE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl < 0 {
+			return cfg, fmt.Errorf("invalid PROBE_CACHE_TTL value %q", v)
+		}
+		cfg.ProbeCacheTTL = ttl
+	}
+	if v := os.Getenv("REMOTE_COMMAND_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return cfg, fmt.Errorf("invalid REMOTE_COMMAND_TIMEOUT value %q", v)
+		}
+		cfg.RemoteCommandTimeout = timeout
+	}
+	if v := os.Getenv("MAX_CLOCK_SKEW"); v != "" {
+		skew, err := time.ParseDuration(v)
+		if err != nil || skew < 0 {
+			return cfg, fmt.Errorf("invalid MAX_CLOCK_SKEW value %q", v)
+		}
+		cfg.MaxClockSkew = skew
+	}
+	if v := os.Getenv("MOUNTPOINT_MODE"); v != "" {
+		mode, err := parseFileMode("MOUNTPOINT_MODE", v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.MountpointMode = mode
+	}
+	if v := os.Getenv("AUTO_REMOUNT"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid AUTO_REMOUNT value %q", v)
+		}
+		cfg.AutoRemount = b
+	}
+	if v := os.Getenv("MOUNT_CHECK_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return cfg, fmt.Errorf("invalid MOUNT_CHECK_TIMEOUT value %q", v)
+		}
+		cfg.MountCheckTimeout = timeout
+	}
+	if v := os.Getenv("REMOVE_POLICY"); v != "" {
+		switch v {
+		case "strict", "unmount-if-unreferenced", "detach":
+			cfg.RemovePolicy = v
+		default:
+			return cfg, fmt.Errorf("invalid REMOVE_POLICY value %q", v)
+		}
+	}
+	if v := os.Getenv("UNKNOWN_UNMOUNT_POLICY"); v != "" {
+		switch v {
+		case "ignore", "error":
+			cfg.UnknownUnmountPolicy = v
+		default:
+			return cfg, fmt.Errorf("invalid UNKNOWN_UNMOUNT_POLICY value %q", v)
+		}
+	}
+	if v := os.Getenv("RECONCILE_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < 0 {
+			return cfg, fmt.Errorf("invalid RECONCILE_INTERVAL value %q", v)
+		}
+		cfg.ReconcileInterval = interval
+	}
+	if v := os.Getenv("HEALTH_CHECK_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < 0 {
+			return cfg, fmt.Errorf("invalid HEALTH_CHECK_INTERVAL value %q", v)
+		}
+		cfg.HealthCheckInterval = interval
+	}
+	if v := os.Getenv("CHECKPOINT_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < 0 || (interval > 0 && interval < time.Second) {
+			return cfg, fmt.Errorf("invalid CHECKPOINT_INTERVAL value %q", v)
+		}
+		cfg.CheckpointInterval = interval
+	}
+	if v := os.Getenv("MOUNT_ERROR_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window < time.Second {
+			return cfg, fmt.Errorf("invalid MOUNT_ERROR_WINDOW value %q", v)
+		}
+		cfg.MountErrorWindow = window
+	}
+	if v := os.Getenv("LOG_FILE"); v != "" {
+		cfg.LogFile = v
+	}
+	if v := os.Getenv("LOG_MAX_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid LOG_MAX_SIZE value %q", v)
+		}
+		cfg.LogMaxSize = n
+	}
+	if v := os.Getenv("LOG_MAX_FILES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid LOG_MAX_FILES value %q", v)
+		}
+		cfg.LogMaxFiles = n
+	}
+	if v := os.Getenv("FEATURE_CHECK"); v != "" {
+		switch v {
+		case "warn", "error", "off":
+			cfg.FeatureCheck = v
+		default:
+			return cfg, fmt.Errorf("invalid FEATURE_CHECK value %q", v)
+		}
+	}
+	if v := os.Getenv("MAX_HOST_MOUNTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid MAX_HOST_MOUNTS value %q", v)
+		}
+		cfg.MaxHostMounts = n
+	}
+	if v := os.Getenv("HOST_LIMIT_POLICY"); v != "" {
+		if v != "wait" && v != "fail" {
+			return cfg, fmt.Errorf("invalid HOST_LIMIT_POLICY value %q", v)
+		}
+		cfg.HostLimitPolicy = v
+	}
+	if v := os.Getenv("ALLOWED_OPTIONS"); v != "" {
+		cfg.AllowedOptions = parseList(v)
+	}
+	if v := os.Getenv("DENIED_OPTIONS"); v != "" {
+		cfg.DeniedOptions = parseList(v)
+	}
+	if v := os.Getenv("ALLOWED_REMOTE_PATHS"); v != "" {
+		paths, err := parseRemotePaths(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid ALLOWED_REMOTE_PATHS value %q: %v", v, err)
+		}
+		cfg.AllowedRemotePaths = paths
+	}
+	if v := os.Getenv("COMPRESSION"); v != "" {
+		if err := checkCompressionOption("COMPRESSION", v); err != nil {
+			return cfg, fmt.Errorf("invalid COMPRESSION value %q", v)
+		}
+		cfg.Compression = v
+	}
+	if v := os.Getenv("AUTO_COMPRESSION_LATENCY"); v != "" {
+		latency, err := time.ParseDuration(v)
+		if err != nil || latency <= 0 {
+			return cfg, fmt.Errorf("invalid AUTO_COMPRESSION_LATENCY value %q", v)
+		}
+		cfg.AutoCompressionLatency = latency
+	}
+	if v := os.Getenv("VOLUMES_MANIFEST"); v != "" {
+		cfg.VolumesManifest = v
+	}
+	if v := os.Getenv("CREDENTIALS_FILE"); v != "" {
+		cfg.CredentialsFile = v
+	}
+	if v := os.Getenv("MANIFEST_POLICY"); v != "" {
+		if v != "keep" && v != "update" {
+			return cfg, fmt.Errorf("invalid MANIFEST_POLICY value %q", v)
+		}
+		cfg.ManifestPolicy = v
+	}
+	if v := os.Getenv("STATE_LOAD_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid STATE_LOAD_RETRIES value %q", v)
+		}
+		cfg.StateLoadRetries = n
+	}
+	if v := os.Getenv("STATE_RETRY_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < time.Second {
+			return cfg, fmt.Errorf("invalid STATE_RETRY_INTERVAL value %q", v)
+		}
+		cfg.StateRetryInterval = interval
+	}
+	if v := os.Getenv("NODE_ID"); v != "" {
+		cfg.NodeID = v
+	}
+	if v := os.Getenv("MOUNT_NAMESPACE"); v != "" {
+		cfg.MountNamespace = v
+	}
+	if v := os.Getenv("CONTROL_SOCKET"); v != "" {
+		cfg.ControlSocket = v
+	}
+	if v := os.Getenv("UNMOUNT_TOOLS"); v != "" {
+		tools, err := parseUnmountTools(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid UNMOUNT_TOOLS value %q: %v", v, err)
+		}
+		cfg.UnmountTools = tools
+	}
+	return cfg, nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+)
+
+// version is the build version, set with -ldflags "-X main.version=...".
+var version = "dev"
+
+// driverStatus is the operational summary served at /status.
+type driverStatus struct {
+	Version        string  `json:"version"`
+	UptimeSeconds  float64 `json:"uptime_seconds"`
+	Volumes        int     `json:"volumes"`
+	ActiveMounts   int     `json:"active_mounts"`
+	DegradedMounts int     `json:"degraded_mounts"`
+	Draining       bool    `json:"draining"`
+	// StateDegraded is set while the state directory is not writable.
+	StateDegraded bool `json:"state_degraded"`
+}
+
+// driverFeatures are the optional behaviours enabled in the driver's
+// configuration, served at /features so tooling can adapt to them.
+type driverFeatures struct {
+	Scope            string `json:"scope"`
+	SshfsVersion     string `json:"sshfs_version,omitempty"`
+	AutoRemount      bool   `json:"auto_remount"`
+	PasswordAuth     bool   `json:"password_auth"`
+	OptionPolicy     bool   `json:"option_policy"`
+	RemotePathPolicy bool   `json:"remote_path_policy"`
+	HostMountLimit   int    `json:"host_mount_limit"`
+	MountNamespace   bool   `json:"mount_namespace"`
+	HostCredentials  bool   `json:"host_credentials"`
+	LogFile          bool   `json:"log_file"`
+}
+
+func (d *sshfsDriver) features() driverFeatures {
+	return driverFeatures{
+		Scope:            d.config.Scope,
+		SshfsVersion:     d.sshfsVersion,
+		AutoRemount:      d.config.AutoRemount,
+		PasswordAuth:     !d.config.DisableSshpass,
+		OptionPolicy:     len(d.config.AllowedOptions) > 0 || len(d.config.DeniedOptions) > 0,
+		RemotePathPolicy: len(d.config.AllowedRemotePaths) > 0,
+		HostMountLimit:   d.config.MaxHostMounts,
+		MountNamespace:   d.mountNamespace != "",
+		HostCredentials:  d.config.CredentialsFile != "",
+		LogFile:          d.config.LogFile != "",
+	}
+}
+
+// status summarizes the driver's volumes and mounts.
+func (d *sshfsDriver) status() driverStatus {
+	d.RLock()
+	s := driverStatus{
+		Version:       version,
+		UptimeSeconds: d.now().Sub(d.started).Seconds(),
+		Volumes:       len(d.volumes),
+		Draining:      d.draining,
+		StateDegraded: d.stateDegraded,
+	}
+	var expected []string
+	for _, v := range d.volumes {
+		if v.connections > 0 {
+			s.ActiveMounts++
+		}
+		if mountExpected(v) {
+			expected = append(expected, v.Mountpoint)
+		}
+	}
+	d.RUnlock()
+
+	dead := d.deadMountpoints(expected)
+	for _, mountpoint := range expected {
+		if dead[mountpoint] {
+			s.DegradedMounts++
+		}
+	}
+	return s
+}
+
+// controlHandler serves the operator-facing control API.
+func (d *sshfsDriver) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.status())
+	})
+	mux.HandleFunc("GET /features", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.features())
+	})
+	mux.HandleFunc("GET /info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.info())
+	})
+	mux.HandleFunc("GET /events", d.serveEvents)
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		d.writeMetrics(w)
+	})
+	mux.HandleFunc("GET /volumes/{name}/containers", func(w http.ResponseWriter, r *http.Request) {
+		ids, err := d.containers(r.PathValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, ids)
+	})
+	mux.HandleFunc("GET /volumes/{name}/history", func(w http.ResponseWriter, r *http.Request) {
+		ops, err := d.history(r.PathValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, ops)
+	})
+	mux.HandleFunc("GET /volumes/{name}/dump", func(w http.ResponseWriter, r *http.Request) {
+		dump, err := d.dump(r.PathValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, dump)
+	})
+	mux.HandleFunc("GET /volumes", func(w http.ResponseWriter, r *http.Request) {
+		var selectors []labelSelector
+		for _, s := range r.URL.Query()["label"] {
+			selector, err := parseLabelSelector(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			selectors = append(selectors, selector)
+		}
+		writeJSON(w, d.listVolumes(selectors))
+	})
+	mux.HandleFunc("POST /volumes", func(w http.ResponseWriter, r *http.Request) {
+		var req batchCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid batch: %v", err), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, d.batchCreate(&req))
+	})
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		result, err := d.reloadState()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, result)
+	})
+	for action, draining := range map[string]bool{"drain": true, "resume": false} {
+		mux.HandleFunc("POST /"+action, func(w http.ResponseWriter, r *http.Request) {
+			d.setDraining(draining)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	for action, disabled := range map[string]bool{"disable": true, "enable": false} {
+		mux.HandleFunc("POST /volumes/{name}/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if err := d.setDisabled(r.PathValue("name"), disabled); err != nil {
+				code := http.StatusInternalServerError
+				if errors.Is(err, ErrVolumeNotFound) {
+					code = http.StatusNotFound
+				}
+				http.Error(w, err.Error(), code)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	mux.HandleFunc("POST /volumes/{name}/compression", func(w http.ResponseWriter, r *http.Request) {
+		decision, err := d.reprobeCompression(r.PathValue("name"))
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrVolumeNotFound) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeJSON(w, decision)
+	})
+	mux.HandleFunc("POST /volumes/{name}/benchmark", serveCheck(d.Benchmark))
+	mux.HandleFunc("POST /volumes/{name}/probe", serveCheck(d.TestConnection))
+	mux.HandleFunc("POST /volumes/{name}/selftest", serveCheck(d.SelfTest))
+	mux.HandleFunc("POST /volumes/{name}/remove", func(w http.ResponseWriter, r *http.Request) {
+		result, err := d.remove(r.PathValue("name"))
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrVolumeNotFound) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeJSON(w, result)
+	})
+	mux.HandleFunc("POST /hosts/{host}/scan", func(w http.ResponseWriter, r *http.Request) {
+		host, port := r.PathValue("host"), r.URL.Query().Get("port")
+		write := false
+		if s := r.URL.Query().Get("write"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid write %q", s), http.StatusBadRequest)
+				return
+			}
+			write = b
+		}
+		if err := checkScanTarget(host, port); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		keys, err := d.ScanHostKey(host, port, write)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, keys)
+	})
+	mux.HandleFunc("POST /containers/{id}/unmount", func(w http.ResponseWriter, r *http.Request) {
+		names, err := d.unmountContainer(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, names)
+	})
+	return mux
+}
+
+// serveCheck serves check, which reaches the server of the volume named in
+// the path, as SelfTest does. A failed check is a 502, with the result if
+// the check has one to show how far it got.
+func serveCheck[T any](check func(name string) (*T, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := check(r.PathValue("name"))
+		switch {
+		case errors.Is(err, ErrVolumeNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil && result != nil:
+			writeJSONStatus(w, http.StatusBadGateway, result)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			writeJSON(w, result)
+		}
+	}
+}
+
+// setDraining starts or ends draining: while the driver drains, Mount
+// refuses containers that do not hold the volume yet.
+func (d *sshfsDriver) setDraining(draining bool) {
+	d.Lock()
+	defer d.Unlock()
+	if d.draining != draining {
+		logrus.WithField("draining", draining).Info("drain mode changed")
+	}
+	d.draining = draining
+}
+
+// unmountContainer releases every volume the container with the given ID
+// still holds, as if Docker had unmounted them, and returns their names. It
+// recovers from containers that died without their volumes being unmounted.
+func (d *sshfsDriver) unmountContainer(id string) ([]string, error) {
+	d.RLock()
+	var held []string
+	for name, v := range d.volumes {
+		if _, ok := v.containers[id]; ok {
+			held = append(held, name)
+		}
+	}
+	d.RUnlock()
+	sort.Strings(held)
+
+	names := make([]string, 0, len(held))
+	var errs []error
+	for _, name := range held {
+		if err := d.Unmount(&volume.UnmountRequest{Name: name, ID: id}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, errors.Join(errs...)
+}
+
+// containers returns the IDs of the containers holding the named volume.
+func (d *sshfsDriver) containers(name string) ([]string, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	v, ok := d.volumes[name]
+	if !ok {
+		return nil, fmt.Errorf("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	return v.containerIDs(), nil
+}
+
+// serveEvents streams driver events as server-sent events until the client
+// goes away.
+func (d *sshfsDriver) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := d.events.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tells the client it is subscribed before the first event arrives.
+	fmt.Fprint(w, ": subscribed\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				logrus.WithField("method", "control").Error(err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus is writeJSON with a status code other than 200.
+func writeJSONStatus(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithField("method", "control").Error(err)
+	}
+}
+
+// serveControl serves the control API on a unix socket at path, replacing
+// a socket left behind by an earlier run.
+func serveControl(path string, h http.Handler) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("control API listening on %s", path)
+	return http.Serve(l, h)
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestControlStatus tests the /status endpoint of the control API
+func TestControlStatus(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.now = func() time.Time { return driver.started.Add(90 * time.Second) }
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	for _, name := range []string{"first", "second", "third"} {
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"sshcmd": "user@host:/" + name}}); err != nil {
+			t.Fatalf("Failed to create volume %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"first", "second"} {
+		if _, err := driver.Mount(&volume.MountRequest{Name: name, ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume %s: %v", name, err)
+		}
+	}
+	degraded := driver.volumes["second"].Mountpoint
+	driver.stat = func(name string) (os.FileInfo, error) {
+		if name == degraded {
+			return staleStat(name)
+		}
+		return os.Stat(name)
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	AssertEqual(t, "application/json", rec.Header().Get("Content-Type"), "content type")
+
+	var status driverStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	AssertEqual(t, driverStatus{
+		Version:        version,
+		UptimeSeconds:  90,
+		Volumes:        3,
+		ActiveMounts:   2,
+		DegradedMounts: 1,
+	}, status, "status")
+
+	rec = httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	AssertEqual(t, http.StatusMethodNotAllowed, rec.Code, "POST status code")
+}
+
+// TestControlFeatures tests that the /features endpoint reflects the driver configuration
+func TestControlFeatures(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.config.Scope = "global"
+	driver.config.AutoRemount = true
+	driver.config.DisableSshpass = true
+	driver.config.DeniedOptions = []string{"allow_other"}
+	driver.config.MaxHostMounts = 4
+	driver.sshfsVersion = "3.7.3"
+
+	AssertEqual(t, "global", driver.Capabilities().Capabilities.Scope, "capabilities scope")
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/features", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var features driverFeatures
+	if err := json.Unmarshal(rec.Body.Bytes(), &features); err != nil {
+		t.Fatalf("Failed to decode features: %v", err)
+	}
+	AssertEqual(t, driverFeatures{
+		Scope:          "global",
+		SshfsVersion:   "3.7.3",
+		AutoRemount:    true,
+		PasswordAuth:   false,
+		OptionPolicy:   true,
+		HostMountLimit: 4,
+		LogFile:        false,
+	}, features, "features")
+}
+
+// TestControlInfo tests that the /info endpoint reflects the configured
+// limits and features
+func TestControlInfo(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.config.MaxHostMounts = 2
+	driver.config.HostLimitPolicy = "fail"
+	driver.config.RemoteCommandTimeout = 15 * time.Second
+	driver.config.CredentialsFile = "/mnt/state/credentials.json"
+	driver.config.ControlSocket = "/run/sshfs-control.sock"
+	driver.config.AutoRemount = true
+
+	get := func(t *testing.T) driverInfo {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/info", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		var info driverInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatalf("Failed to decode info: %v", err)
+		}
+		return info
+	}
+
+	info := get(t)
+	AssertEqual(t, version, info.Version, "version")
+	AssertEqual(t, driverLimits{
+		MaxHostMounts:               2,
+		HostLimitPolicy:             "fail",
+		RemoteCommandTimeoutSeconds: 15,
+		BenchmarkTimeoutSeconds:     30,
+		KeyscanTimeoutSeconds:       10,
+		ProbeCacheTTLSeconds:        30,
+	}, info.Limits, "limits")
+	AssertEqual(t, "publickey,password,keyboard-interactive,host_credentials", strings.Join(info.AuthMethods, ","), "auth methods")
+	AssertEqual(t, "/run/sshfs-control.sock", info.ControlSocket, "control socket")
+	AssertEqual(t, infoEndpoints{Metrics: "/metrics", Status: "/status", Events: "/events"}, info.Endpoints, "endpoints")
+	AssertEqual(t, true, info.Features.AutoRemount, "auto_remount feature")
+	AssertEqual(t, true, info.Features.HostCredentials, "host_credentials feature")
+	AssertEqual(t, 2, info.Features.HostMountLimit, "host_mount_limit feature")
+
+	driver.config.DisableSshpass = true
+	driver.config.CredentialsFile = ""
+	info = get(t)
+	AssertEqual(t, "publickey", strings.Join(info.AuthMethods, ","), "auth methods without sshpass")
+	AssertEqual(t, false, info.Features.PasswordAuth, "password_auth feature")
+}
+
+// TestContainerIDs tests listing the containers that hold a volume
+func TestContainerIDs(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	for _, id := range []string{"c3", "c1", "c2"} {
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: id}); err != nil {
+			t.Fatalf("Failed to mount volume for %s: %v", id, err)
+		}
+	}
+
+	assertContainers := func(want []string) {
+		t.Helper()
+		resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+		if err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+		if len(want) == 0 {
+			if _, ok := resp.Volume.Status["containers"]; ok {
+				t.Errorf("Expected no containers in status, got %v", resp.Volume.Status["containers"])
+			}
+		} else {
+			ids, _ := resp.Volume.Status["containers"].([]string)
+			AssertEqual(t, strings.Join(want, ","), strings.Join(ids, ","), "status containers")
+		}
+
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/test-volume/containers", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		var ids []string
+		if err := json.Unmarshal(rec.Body.Bytes(), &ids); err != nil {
+			t.Fatalf("Failed to decode containers: %v", err)
+		}
+		AssertEqual(t, strings.Join(want, ","), strings.Join(ids, ","), "control containers")
+	}
+
+	assertContainers([]string{"c1", "c2", "c3"})
+	for _, step := range []struct {
+		id   string
+		want []string
+	}{
+		{"c2", []string{"c1", "c3"}},
+		{"c1", []string{"c3"}},
+		{"c3", nil},
+	} {
+		if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: step.id}); err != nil {
+			t.Fatalf("Failed to unmount volume for %s: %v", step.id, err)
+		}
+		assertContainers(step.want)
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/missing/containers", nil))
+	AssertEqual(t, http.StatusNotFound, rec.Code, "unknown volume status code")
+}
+
+// TestUnmountContainer tests releasing every volume a container holds
+func TestUnmountContainer(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	for i := 0; i < 3; i++ {
+		executor.AddMockResponse(nil, nil)
+	}
+	driver.executor = executor
+
+	for _, name := range []string{"first", "second", "third"} {
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"sshcmd": "user@host:/" + name}}); err != nil {
+			t.Fatalf("Failed to create volume %s: %v", name, err)
+		}
+	}
+	for _, m := range []struct{ name, id string }{
+		{"first", "dead"}, {"second", "dead"}, {"second", "alive"}, {"third", "alive"},
+	} {
+		if _, err := driver.Mount(&volume.MountRequest{Name: m.name, ID: m.id}); err != nil {
+			t.Fatalf("Failed to mount volume %s for %s: %v", m.name, m.id, err)
+		}
+	}
+
+	// Only first loses its last container and is actually unmounted.
+	executor.AddMockResponse(nil, nil)
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/containers/dead/unmount", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var names []string
+	if err := json.Unmarshal(rec.Body.Bytes(), &names); err != nil {
+		t.Fatalf("Failed to decode released volumes: %v", err)
+	}
+	AssertEqual(t, "first,second", strings.Join(names, ","), "released volumes")
+	AssertEqual(t, 4, executor.GetCommandCount(), "commands run")
+
+	AssertEqual(t, 0, driver.volumes["first"].connections, "first connections")
+	AssertEqual(t, 1, driver.volumes["second"].connections, "second connections")
+	AssertEqual(t, "alive", strings.Join(driver.volumes["second"].containerIDs(), ","), "second containers")
+	AssertEqual(t, 1, driver.volumes["third"].connections, "third connections")
+}
+
+// TestDisableVolume tests disabling and re-enabling a volume
+func TestDisableVolume(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	post := func(path string) int {
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec.Code
+	}
+
+	AssertEqual(t, http.StatusNoContent, post("/volumes/test-volume/disable"), "disable status code")
+
+	_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c2"})
+	if err == nil {
+		t.Fatal("Expected mount of a disabled volume to fail")
+	}
+	AssertContains(t, err.Error(), "disabled", "mount error")
+	AssertEqual(t, 1, driver.volumes["test-volume"].connections, "connections")
+
+	resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+	if err != nil {
+		t.Fatalf("Failed to get volume: %v", err)
+	}
+	AssertEqual(t, true, resp.Volume.Status["disabled"], "get disabled status")
+	list, err := driver.List()
+	if err != nil {
+		t.Fatalf("Failed to list volumes: %v", err)
+	}
+	AssertEqual(t, true, list.Volumes[0].Status["disabled"], "list disabled status")
+
+	restarted, err := newSshfsDriver(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart driver: %v", err)
+	}
+	AssertEqual(t, true, restarted.volumes["test-volume"].Disabled, "persisted disabled flag")
+	AssertEqual(t, "user@host:/path", restarted.volumes["test-volume"].Sshcmd, "persisted definition")
+
+	AssertEqual(t, http.StatusNoContent, post("/volumes/test-volume/enable"), "enable status code")
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c2"}); err != nil {
+		t.Fatalf("Expected mount of a re-enabled volume to succeed, got %v", err)
+	}
+	AssertEqual(t, 2, driver.volumes["test-volume"].connections, "connections")
+
+	AssertEqual(t, http.StatusNotFound, post("/volumes/missing/disable"), "unknown volume status code")
+}
+
+// TestRemoveEndpoint tests removing a volume through the control API
+func TestRemoveEndpoint(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	mountpoint := driver.volumes["test-volume"].Mountpoint
+	if err := os.MkdirAll(mountpoint, 0o755); err != nil {
+		t.Fatalf("Failed to create mountpoint: %v", err)
+	}
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/volumes/test-volume/remove", nil))
+		return rec
+	}
+
+	rec := post()
+	AssertEqual(t, http.StatusOK, rec.Code, "status code")
+	var result removeResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode remove result: %v", err)
+	}
+	AssertEqual(t, mountpoint, result.Mountpoint, "removed mountpoint")
+	if _, ok := driver.volumes["test-volume"]; ok {
+		t.Error("Expected volume to be removed")
+	}
+
+	AssertEqual(t, http.StatusNotFound, post().Code, "status code of a second remove")
+}
+
+// TestDrain tests that draining refuses new mounts but not unmounts
+func TestDrain(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	for _, name := range []string{"first", "second"} {
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"sshcmd": "user@host:/" + name}}); err != nil {
+			t.Fatalf("Failed to create volume %s: %v", name, err)
+		}
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "first", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	post := func(path string) int {
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec.Code
+	}
+
+	AssertEqual(t, http.StatusNoContent, post("/drain"), "drain status code")
+	AssertEqual(t, true, driver.status().Draining, "status while draining")
+
+	for _, req := range []*volume.MountRequest{{Name: "first", ID: "c2"}, {Name: "second", ID: "c1"}} {
+		_, err := driver.Mount(req)
+		if err == nil {
+			t.Fatalf("Expected mount of %s for %s to fail while draining", req.Name, req.ID)
+		}
+		AssertContains(t, err.Error(), "draining", "mount error")
+	}
+	// A replayed Mount of a container already holding the volume is not new.
+	if _, err := driver.Mount(&volume.MountRequest{Name: "first", ID: "c1"}); err != nil {
+		t.Fatalf("Expected a replayed mount to succeed, got %v", err)
+	}
+	AssertEqual(t, 1, driver.volumes["first"].connections, "connections while draining")
+
+	if err := driver.Unmount(&volume.UnmountRequest{Name: "first", ID: "c1"}); err != nil {
+		t.Fatalf("Expected unmount to succeed while draining, got %v", err)
+	}
+	if err := driver.Remove(&volume.RemoveRequest{Name: "first"}); err != nil {
+		t.Fatalf("Expected remove to succeed while draining, got %v", err)
+	}
+
+	AssertEqual(t, http.StatusNoContent, post("/resume"), "resume status code")
+	AssertEqual(t, false, driver.status().Draining, "status after resume")
+	executor.AddMockResponse(nil, nil)
+	if _, err := driver.Mount(&volume.MountRequest{Name: "second", ID: "c1"}); err != nil {
+		t.Fatalf("Expected mount to succeed after resume, got %v", err)
+	}
+}
+
+// TestVolumeDump tests the support dump of a volume
+func TestVolumeDump(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse([]byte("Connection reset by peer"), errors.New("exit status 1"))
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	err := driver.Create(&volume.CreateRequest{
+		Name: "test-volume",
+		Options: map[string]string{
+			"sshcmd":     "user@host:/path",
+			"password":   "hunter2",
+			"secret_env": "TOKEN=s3cr3t",
+			"env":        "REGION=eu",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	_, err = driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+	AssertError(t, err, "failed mount")
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c2"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	mountpoint := driver.volumes["test-volume"].Mountpoint
+	driver.mountsPath = filepath.Join(tmpDir, "mounts")
+	if err := os.WriteFile(driver.mountsPath, []byte("user@host:/path "+mountpoint+" fuse.sshfs rw 0 0\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write mount table: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/test-volume/dump", nil))
+	AssertEqual(t, http.StatusOK, rec.Code, "status code")
+	body := rec.Body.String()
+	AssertNotContains(t, body, "hunter2", "dump")
+	AssertNotContains(t, body, "s3cr3t", "dump")
+
+	var dump volumeDump
+	if err := json.Unmarshal(rec.Body.Bytes(), &dump); err != nil {
+		t.Fatalf("Failed to decode dump: %v", err)
+	}
+	AssertEqual(t, "test-volume", dump.Name, "name")
+	AssertEqual(t, mountpoint, dump.Mountpoint, "mountpoint")
+	AssertEqual(t, "user@host:/path", dump.Config.Sshcmd, "sshcmd")
+	AssertEqual(t, "<redacted>", dump.Config.Password, "password")
+	AssertEqual(t, "TOKEN=<redacted>", strings.Join(dump.Config.SecretEnv, ","), "secret_env")
+	AssertEqual(t, "REGION=eu", strings.Join(dump.Config.Env, ","), "env")
+	AssertEqual(t, 1, dump.Connections, "connections")
+	AssertEqual(t, "c2", strings.Join(dump.Containers, ","), "containers")
+	AssertEqual(t, "user@host", dump.Host, "host")
+	AssertEqual(t, true, dump.Mounted, "mounted")
+	AssertEqual(t, false, dump.Degraded, "degraded")
+	AssertEqual(t, 2, len(dump.History), "history")
+	if dump.LastError == nil {
+		t.Fatal("Expected the failed mount as last error")
+	}
+	AssertEqual(t, "c1", dump.LastError.Container, "last error container")
+	AssertContains(t, dump.LastError.Error, "Connection reset by peer", "last error")
+
+	rec = httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/missing/dump", nil))
+	AssertEqual(t, http.StatusNotFound, rec.Code, "status code of an unknown volume")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// hostCredentials are the default credentials of a host in
+// CREDENTIALS_FILE: a private key, a file holding the password, or both.
+type hostCredentials struct {
+	IdentityFile string `json:"identity_file"`
+	PasswordFile string `json:"password_file"`
+}
+
+// parseCredentials parses a credentials file, which maps hosts to their
+// credentials. Hosts are matched as remoteHost returns them.
+func parseCredentials(path string, data []byte) (map[string]hostCredentials, error) {
+	var raw map[string]hostCredentials
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %v", path, err)
+	}
+	creds := make(map[string]hostCredentials, len(raw))
+	for host, c := range raw {
+		if c.IdentityFile == "" && c.PasswordFile == "" {
+			return nil, fmt.Errorf("credentials file %s: host %s needs an identity_file or password_file", path, host)
+		}
+		creds[remoteHost(host)] = c
+	}
+	return creds, nil
+}
+
+// readCredentials reads CREDENTIALS_FILE afresh, so that changes to it
+// apply to the next mount without restarting the driver.
+func (d *sshfsDriver) readCredentials() (map[string]hostCredentials, error) {
+	data, err := d.readFile(d.config.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+	return parseCredentials(d.config.CredentialsFile, data)
+}
+
+// hasExplicitAuth reports whether the volume names its own credentials.
+func hasExplicitAuth(v *sshfsVolume) bool {
+	return v.Password != "" || v.PasswordFile != "" || hasOption(v, "IdentityFile")
+}
+
+// inheritCredentials marks a volume without credentials of its own to use
+// those CREDENTIALS_FILE configures for its host. Only the mark is kept:
+// the files are looked up again at every mount.
+func (d *sshfsDriver) inheritCredentials(v *sshfsVolume) error {
+	if d.config.CredentialsFile == "" || hasExplicitAuth(v) {
+		return nil
+	}
+	creds, err := d.readCredentials()
+	if err != nil {
+		return err
+	}
+	dest, _ := splitSshcmd(v.Sshcmd)
+	c, ok := creds[remoteHost(dest)]
+	if !ok {
+		return nil
+	}
+	if d.config.DisableSshpass && c.PasswordFile != "" {
+		return fmt.Errorf("password authentication is disabled; credentials of host %s use a password_file", remoteHost(dest))
+	}
+	v.HostCredentials = true
+	return nil
+}
+
+// volumeCredentials returns the CREDENTIALS_FILE entry of the host in the
+// volume's sshcmd, or nil for a volume that does not inherit credentials.
+// A host without an entry, e.g. a fallback host, is an error rather than a
+// silent fallback to other authentication.
+func (d *sshfsDriver) volumeCredentials(v *sshfsVolume) (*hostCredentials, error) {
+	if !v.HostCredentials {
+		return nil, nil
+	}
+	if d.config.CredentialsFile == "" {
+		return nil, fmt.Errorf("volume inherits host credentials but CREDENTIALS_FILE is not set")
+	}
+	creds, err := d.readCredentials()
+	if err != nil {
+		return nil, err
+	}
+	dest, _ := splitSshcmd(v.Sshcmd)
+	c, ok := creds[remoteHost(dest)]
+	if !ok {
+		return nil, fmt.Errorf("credentials file has no entry for host %s", remoteHost(dest))
+	}
+	return &c, nil
+}
+
+// credentialOptions returns the ssh options for the identity file a volume
+// inherits from its host, if any.
+func (d *sshfsDriver) credentialOptions(v *sshfsVolume) ([]string, error) {
+	c, err := d.volumeCredentials(v)
+	if err != nil || c == nil || c.IdentityFile == "" {
+		return nil, err
+	}
+	p, err := d.expandPath(c.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("identity_file: %v", err)
+	}
+	return []string{"IdentityFile=" + p}, nil
+}
+
+// checkCredentials checks the credentials file and the files it references.
+func checkCredentials(config driverConfig) []error {
+	data, err := os.ReadFile(config.CredentialsFile)
+	if err != nil {
+		return []error{fmt.Errorf("failed to read credentials file: %v", err)}
+	}
+	creds, err := parseCredentials(config.CredentialsFile, data)
+	if err != nil {
+		return []error{err}
+	}
+
+	d := newDriver("/mnt", config)
+	hosts := make([]string, 0, len(creds))
+	for host := range creds {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	var problems []error
+	for _, host := range hosts {
+		c := creds[host]
+		if config.DisableSshpass && c.PasswordFile != "" {
+			problems = append(problems, fmt.Errorf("host %s in credentials file: password authentication is disabled", host))
+		}
+		for _, file := range []volumeFile{{"IdentityFile", c.IdentityFile}, {"password_file", c.PasswordFile}} {
+			if file.path == "" {
+				continue
+			}
+			if err := d.checkVolumeFile(file.key, file.path); err != nil {
+				problems = append(problems, fmt.Errorf("host %s in credentials file: %v", host, err))
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestHostCredentials tests that volumes without their own credentials
+// inherit those of their host from the credentials file
+func TestHostCredentials(t *testing.T) {
+	const password = "host-s3cr3t"
+
+	setup := func(t *testing.T) (*sshfsDriver, string, *TestCommandExecutor) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		if err := os.WriteFile(filepath.Join(tmpDir, "nas.key"), []byte("key"), 0o600); err != nil {
+			t.Fatalf("Failed to write key: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "legacy.pass"), []byte(password+"\n"), 0o600); err != nil {
+			t.Fatalf("Failed to write password file: %v", err)
+		}
+		creds := fmt.Sprintf(`{
+			"NAS": {"identity_file": %q},
+			"legacy": {"password_file": %q}
+		}`, filepath.Join(tmpDir, "nas.key"), filepath.Join(tmpDir, "legacy.pass"))
+		driver.config.CredentialsFile = filepath.Join(tmpDir, "credentials.json")
+		if err := os.WriteFile(driver.config.CredentialsFile, []byte(creds), 0o600); err != nil {
+			t.Fatalf("Failed to write credentials file: %v", err)
+		}
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+		return driver, tmpDir, executor
+	}
+
+	mount := func(t *testing.T, driver *sshfsDriver, executor *TestCommandExecutor, options map[string]string) []string {
+		t.Helper()
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		executor.AddMockResponse(nil, nil)
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		return executor.LastCmd().Args
+	}
+
+	t.Run("identity file inherited from host", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		args := mount(t, driver, executor, map[string]string{"sshcmd": "user@nas:/path"})
+		AssertContains(t, strings.Join(args, " "), "-o IdentityFile="+filepath.Join(tmpDir, "nas.key"), "sshfs arguments")
+		AssertEqual(t, true, driver.volumes["test-volume"].HostCredentials, "inherits host credentials")
+	})
+
+	t.Run("password inherited from host but not persisted", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		args := mount(t, driver, executor, map[string]string{"sshcmd": "user@legacy:/path"})
+		AssertNotContains(t, strings.Join(args, " "), "IdentityFile", "sshfs arguments")
+		AssertContains(t, strings.Join(executor.LastCmd().Env, "\n"), askpassPasswordEnv+"="+password, "sshfs environment")
+
+		state, err := os.ReadFile(driver.statePath)
+		AssertNoError(t, err, "read state")
+		AssertNotContains(t, string(state), password, "state file")
+		AssertNotContains(t, string(state), "legacy.pass", "state file")
+	})
+
+	t.Run("explicit credentials take precedence", func(t *testing.T) {
+		for name, options := range map[string]map[string]string{
+			"identity file": {"IdentityFile": "/keys/own"},
+			"password":      {"password": "own"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				driver, tmpDir, executor := setup(t)
+				defer cleanupTestDriver(tmpDir)
+
+				options["sshcmd"] = "user@nas:/path"
+				args := mount(t, driver, executor, options)
+				AssertNotContains(t, strings.Join(args, " "), "nas.key", "sshfs arguments")
+				AssertEqual(t, false, driver.volumes["test-volume"].HostCredentials, "inherits host credentials")
+			})
+		}
+	})
+
+	t.Run("host without an entry", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		args := mount(t, driver, executor, map[string]string{"sshcmd": "user@other:/path"})
+		AssertNotContains(t, strings.Join(args, " "), "IdentityFile", "sshfs arguments")
+		AssertEqual(t, false, driver.volumes["test-volume"].HostCredentials, "inherits host credentials")
+	})
+
+	t.Run("entry removed after create", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@nas:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if err := os.WriteFile(driver.config.CredentialsFile, []byte(`{}`), 0o600); err != nil {
+			t.Fatalf("Failed to write credentials file: %v", err)
+		}
+		_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		AssertError(t, err, "mount")
+		AssertContains(t, err.Error(), "credentials file has no entry for host nas", "mount error")
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("password file on a driver with sshpass disabled", func(t *testing.T) {
+		driver, tmpDir, _ := setup(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.DisableSshpass = true
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@legacy:/path"}})
+		AssertError(t, err, "create")
+		AssertContains(t, err.Error(), "password authentication is disabled", "create error")
+	})
+}
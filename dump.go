@@ -0,0 +1,76 @@
+package main
+
+import "fmt"
+
+// volumeDump is a snapshot of one volume's state to attach to bug reports.
+// Config holds the volume's definition as in the state file, with secret
+// values redacted.
+type volumeDump struct {
+	Name        string      `json:"name"`
+	Mountpoint  string      `json:"mountpoint"`
+	Config      sshfsVolume `json:"config"`
+	Connections int         `json:"connections"`
+	Containers  []string    `json:"containers"`
+	Host        string      `json:"host,omitempty"`
+	Mounting    bool        `json:"mounting"`
+	Mounted     bool        `json:"mounted"`
+	MountCheck  string      `json:"mount_check_error,omitempty"`
+	Degraded    bool        `json:"degraded"`
+	LowSpace    bool        `json:"low_space"`
+	Remounts    int         `json:"remounts"`
+	History     []volumeOp  `json:"history"`
+	LastError   *volumeOp   `json:"last_error,omitempty"`
+}
+
+// dump returns a snapshot of the named volume's configuration and runtime
+// state. Passwords and secret_env values are replaced by "<redacted>".
+func (d *sshfsDriver) dump(name string) (*volumeDump, error) {
+	d.RLock()
+	v, ok := d.volumes[name]
+	if !ok {
+		d.RUnlock()
+		return nil, fmt.Errorf("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	config := definition(v)
+	config.IdentitySource = v.IdentitySource
+	config.SetupDone = v.SetupDone
+	config.LastHost = v.LastHost
+	config.RemoteOS = v.RemoteOS
+	config.Disabled = v.Disabled
+	if config.Password != "" {
+		config.Password = "<redacted>"
+	}
+	config.SecretEnv = redactEnv(config.SecretEnv)
+
+	dump := &volumeDump{
+		Name:        name,
+		Mountpoint:  v.Mountpoint,
+		Config:      config,
+		Connections: v.connections,
+		Containers:  v.containerIDs(),
+		Mounting:    v.mounting != nil,
+		LowSpace:    v.lowSpace,
+		Remounts:    v.remountCount(),
+		History:     append([]volumeOp{}, v.history...),
+	}
+	if v.connections > 0 {
+		dump.Host = v.host
+	}
+	expected := mountExpected(v)
+	d.RUnlock()
+
+	dump.Degraded = expected && d.mountpointDead(dump.Mountpoint)
+
+	mounted, err := d.isMounted(dump.Mountpoint)
+	if err != nil {
+		dump.MountCheck = err.Error()
+	}
+	dump.Mounted = mounted
+	for i := len(dump.History) - 1; i >= 0; i-- {
+		if dump.History[i].Error != "" {
+			dump.LastError = &dump.History[i]
+			break
+		}
+	}
+	return dump, nil
+}
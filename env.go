@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// envName matches the names of environment variables a volume may set.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedEnv are the variables the driver sets for sshfs and ssh itself.
+var reservedEnv = []string{"SSH_ASKPASS", "SSH_ASKPASS_REQUIRE", "DISPLAY", "SSHPASS", "SHELL", askpassPasswordEnv, askpassShellEnv}
+
+// parseEnvOption parses an env or secret_env option: comma-separated
+// KEY=VALUE pairs. Variables that change how programs are loaded, or that
+// the driver sets itself, are rejected. Errors never include a value.
+func parseEnvOption(key, val string) ([]string, error) {
+	var env []string
+	for _, pair := range strings.Split(val, ",") {
+		name, _, ok := strings.Cut(pair, "=")
+		if !ok || !envName.MatchString(name) {
+			return nil, fmt.Errorf("invalid entry in option %s, expected comma-separated KEY=VALUE pairs", key)
+		}
+		if strings.HasPrefix(name, "LD_") || containsFold(reservedEnv, name) {
+			return nil, fmt.Errorf("option %s cannot set %s", key, name)
+		}
+		env = append(env, pair)
+	}
+	return env, nil
+}
+
+// envNames returns the variable names of KEY=VALUE pairs.
+func envNames(env []string) []string {
+	names := make([]string, len(env))
+	for i, pair := range env {
+		names[i], _, _ = strings.Cut(pair, "=")
+	}
+	return names
+}
+
+// volumeEnv returns the variables the volume adds to its sshfs and ssh
+// processes. Secret values are not persisted, so after a restart only their
+// names are left.
+func volumeEnv(v *sshfsVolume) ([]string, error) {
+	for _, pair := range v.SecretEnv {
+		if !strings.Contains(pair, "=") {
+			return nil, fmt.Errorf("secret_env %s was not persisted; create the volume again with the same options", pair)
+		}
+	}
+	return append(append([]string(nil), v.Env...), v.SecretEnv...), nil
+}
+
+// applyVolumeEnv adds the volume's variables to the environment of cmd.
+func applyVolumeEnv(cmd *exec.Cmd, v *sshfsVolume) error {
+	env, err := volumeEnv(v)
+	if err != nil || len(env) == 0 {
+		return err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, env...)
+	return nil
+}
+
+// redactOptions returns a copy of Create options that is safe to log, with
+// the password and the values of secret_env masked.
+func redactOptions(options map[string]string) map[string]string {
+	redacted := make(map[string]string, len(options))
+	for key, val := range options {
+		switch key {
+		case "password":
+			val = "<redacted>"
+		case "secret_env":
+			val = strings.Join(redactEnv(strings.Split(val, ",")), ",")
+		}
+		redacted[key] = val
+	}
+	return redacted
+}
+
+// redactEnv masks the values of KEY=VALUE pairs.
+func redactEnv(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	redacted := make([]string, len(env))
+	for i, pair := range env {
+		name, _, _ := strings.Cut(pair, "=")
+		redacted[i] = name + "=<redacted>"
+	}
+	return redacted
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// TestEnvOptions tests that env and secret_env reach sshfs without leaking
+// secret values
+func TestEnvOptions(t *testing.T) {
+	const secret = "s3cr3t-token"
+
+	t.Run("applied to sshfs and redacted in logs", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		level := logrus.GetLevel()
+		logrus.SetLevel(logrus.DebugLevel)
+		defer logrus.SetLevel(level)
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		err := driver.Create(&volume.CreateRequest{
+			Name: "test-volume",
+			Options: map[string]string{
+				"sshcmd":     "user@host:/path",
+				"env":        "ENDPOINT=https://gw.example.com,REGION=",
+				"secret_env": "TOKEN=" + secret,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+
+		env := executor.LastCmd().Env
+		AssertEqual(t, "ENDPOINT=https://gw.example.com,REGION=,TOKEN="+secret, strings.Join(env[len(env)-3:], ","), "sshfs environment")
+		AssertEqual(t, len(os.Environ())+3, len(env), "inherited environment")
+
+		logged := 0
+		for _, entry := range hook.AllEntries() {
+			logged++
+			AssertNotContains(t, entry.Message, secret, "log message")
+		}
+		if logged == 0 {
+			t.Fatal("Expected debug log entries")
+		}
+
+		state, err := os.ReadFile(driver.statePath)
+		AssertNoError(t, err, "read state")
+		AssertNotContains(t, string(state), secret, "state file")
+		AssertContains(t, string(state), "https://gw.example.com", "state file")
+
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to restart driver: %v", err)
+		}
+		restarted.executor = NewTestCommandExecutor()
+		_, err = restarted.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		AssertError(t, err, "mount after restart")
+		AssertContains(t, err.Error(), "secret_env TOKEN was not persisted", "mount error")
+
+		// Running the same Create again supplies the values.
+		recreated := NewTestCommandExecutor()
+		recreated.AddMockResponse(nil, nil)
+		restarted.executor = recreated
+		err = restarted.Create(&volume.CreateRequest{
+			Name: "test-volume",
+			Options: map[string]string{
+				"sshcmd":     "user@host:/path",
+				"env":        "ENDPOINT=https://gw.example.com,REGION=",
+				"secret_env": "TOKEN=" + secret,
+			},
+		})
+		AssertNoError(t, err, "re-create after restart")
+		if _, err := restarted.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount re-created volume: %v", err)
+		}
+		env = recreated.LastCmd().Env
+		AssertEqual(t, "TOKEN="+secret, env[len(env)-1], "secret_env after re-create")
+	})
+
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr string
+	}{
+		{"missing equals sign", map[string]string{"env": "ENDPOINT"}, "invalid entry in option env, expected comma-separated KEY=VALUE pairs"},
+		{"invalid name", map[string]string{"env": "1ST=x"}, "invalid entry in option env"},
+		{"empty entry", map[string]string{"env": "A=1,"}, "invalid entry in option env"},
+		{"loader variable", map[string]string{"env": "LD_PRELOAD=/tmp/x.so"}, "option env cannot set LD_PRELOAD"},
+		{"driver variable", map[string]string{"secret_env": "SSHPASS=" + secret}, "option secret_env cannot set SSHPASS"},
+		{"same variable twice", map[string]string{"env": "TOKEN=a", "secret_env": "TOKEN=" + secret}, "env and secret_env cannot set the same variable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+
+			tt.options["sshcmd"] = "user@host:/path"
+			err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: tt.options})
+			AssertError(t, err, "create")
+			AssertContains(t, err.Error(), tt.wantErr, "create error")
+			AssertNotContains(t, err.Error(), secret, "create error")
+		})
+	}
+}
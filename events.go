@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// eventBuffer is how many events a subscriber may fall behind before
+// further events to it are dropped.
+const eventBuffer = 64
+
+// driverEvent is a change to a volume, streamed at /events. It carries no
+// options, so no secrets.
+type driverEvent struct {
+	Type      string    `json:"type"`
+	Volume    string    `json:"volume"`
+	Container string    `json:"container,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// eventBus fans driver events out to subscribers. Publishing never blocks:
+// a subscriber whose buffer is full misses the event.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan driverEvent]struct{}
+}
+
+// subscribe returns a channel of the events published from now on, and a
+// function that ends the subscription.
+func (b *eventBus) subscribe() (<-chan driverEvent, func()) {
+	ch := make(chan driverEvent, eventBuffer)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[chan driverEvent]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+func (b *eventBus) publish(e driverEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// emit publishes an event of the given type for the named volume. Events
+// carry the wall-clock time rather than d.now, which tests drive step by
+// step.
+func (d *sshfsDriver) emit(typ, name, container string) {
+	d.events.publish(driverEvent{Type: typ, Volume: name, Container: container, Time: time.Now()})
+}
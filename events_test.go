@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestEventStream tests that volume operations are streamed at /events in order
+func TestEventStream(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	server := httptest.NewServer(driver.controlHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+	AssertEqual(t, "text/event-stream", resp.Header.Get("Content-Type"), "content type")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("Event stream ended")
+			}
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the event stream")
+		}
+		return ""
+	}
+	for next() != ": subscribed" {
+	}
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path", "password": "secret"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	driver.stat = staleStat
+	for i := 0; i < 2; i++ {
+		if _, err := driver.Get(&volume.GetRequest{Name: "test-volume"}); err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+	}
+	driver.stat = os.Stat
+	if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to unmount volume: %v", err)
+	}
+	if err := driver.Remove(&volume.RemoveRequest{Name: "test-volume"}); err != nil {
+		t.Fatalf("Failed to remove volume: %v", err)
+	}
+
+	var got []string
+	for len(got) < 5 {
+		line := next()
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		AssertNotContains(t, data, "secret", "event")
+		var e driverEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatalf("Failed to decode event %q: %v", data, err)
+		}
+		AssertEqual(t, "test-volume", e.Volume, "event volume")
+		got = append(got, e.Type+":"+e.Container)
+	}
+	AssertEqual(t, "create:,mount:c1,degraded:,unmount:c1,remove:", strings.Join(got, ","), "events")
+}
+
+// TestEventBusDropsForSlowSubscribers tests that publishing never blocks
+func TestEventBusDropsForSlowSubscribers(t *testing.T) {
+	var bus eventBus
+	events, cancel := bus.subscribe()
+
+	for i := 0; i < eventBuffer+10; i++ {
+		bus.publish(driverEvent{Type: "mount", Volume: "test-volume"})
+	}
+	AssertEqual(t, eventBuffer, len(events), "buffered events")
+
+	cancel()
+	bus.publish(driverEvent{Type: "mount", Volume: "test-volume"})
+	AssertEqual(t, eventBuffer, len(events), "events after cancel")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CommandExecutor runs the external commands the driver depends on, so
+// that tests can observe them without sshfs being installed.
+type CommandExecutor interface {
+	Run(cmd *exec.Cmd) ([]byte, error)
+}
+
+// execCommandExecutor runs commands on the host.
+type execCommandExecutor struct{}
+
+func (execCommandExecutor) Run(cmd *exec.Cmd) ([]byte, error) {
+	return cmd.CombinedOutput()
+}
+
+// execRetries is how often a command whose start was interrupted by a
+// signal is started again.
+const execRetries = 3
+
+// contextExecutor is implemented by executors that bind commands to a
+// context themselves, so that a command they start again is bound to it
+// as well.
+type contextExecutor interface {
+	RunContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error)
+}
+
+// runContext runs cmd with e, killing it once ctx is done.
+func runContext(ctx context.Context, e CommandExecutor, cmd *exec.Cmd) ([]byte, error) {
+	if ce, ok := e.(contextExecutor); ok {
+		return ce.RunContext(ctx, cmd)
+	}
+	return e.Run(cloneCommand(ctx, cmd))
+}
+
+// retryExecutor runs commands with next, starting a command again when a
+// signal interrupted starting it (EINTR), which on busy hosts otherwise
+// surfaces as a spurious failure. Commands that ran are never repeated.
+// Commands that Run gets already bound to a context are not repeated
+// either, since a copy of them could not be cancelled; RunContext binds
+// each copy to the context instead.
+type retryExecutor struct {
+	next CommandExecutor
+}
+
+func (e retryExecutor) Run(cmd *exec.Cmd) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		output, err := e.next.Run(cmd)
+		if !errors.Is(err, syscall.EINTR) || cmd.ProcessState != nil || cmd.Cancel != nil {
+			return output, err
+		}
+		if attempt == execRetries {
+			return output, fmt.Errorf("starting %s was interrupted %d times: %w", cmd.Path, attempt+1, err)
+		}
+		logrus.WithField("command", cmd.Path).Debugf("start interrupted, retrying: %v", err)
+		cmd = cloneCommand(nil, cmd)
+	}
+}
+
+func (e retryExecutor) RunContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		c := cloneCommand(ctx, cmd)
+		output, err := e.next.Run(c)
+		if !errors.Is(err, syscall.EINTR) || c.ProcessState != nil || ctx.Err() != nil {
+			return output, err
+		}
+		if attempt == execRetries {
+			return output, fmt.Errorf("starting %s was interrupted %d times: %w", cmd.Path, attempt+1, err)
+		}
+		logrus.WithField("command", cmd.Path).Debugf("start interrupted, retrying: %v", err)
+	}
+}
+
+// cloneCommand returns an unstarted copy of cmd, as a command can only be
+// started once, bound to ctx unless that is nil.
+func cloneCommand(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	var c *exec.Cmd
+	if ctx == nil {
+		c = exec.Command(cmd.Path, cmd.Args[1:]...)
+	} else {
+		c = exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+	}
+	c.Args = cmd.Args
+	c.Env = cmd.Env
+	c.Dir = cmd.Dir
+	c.Stdin = cmd.Stdin
+	c.ExtraFiles = cmd.ExtraFiles
+	c.SysProcAttr = cmd.SysProcAttr
+	c.WaitDelay = cmd.WaitDelay
+	return c
+}
+
+// Linux limits a single argument or environment string to 128KiB, and all
+// of them together to a quarter of the stack limit, usually 2MiB. The total
+// is checked against half of that to leave room for a smaller stack.
+const (
+	maxArgLen  = 128 << 10
+	maxArgvLen = 1 << 20
+)
+
+// checkArgv reports a command whose arguments and environment would exceed
+// the kernel's limits, which exec only reports as an opaque E2BIG.
+func checkArgv(cmd *exec.Cmd) error {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	total := 0
+	for _, s := range append(cmd.Args, env...) {
+		if len(s) >= maxArgLen {
+			return fmt.Errorf("argument of %d bytes exceeds the limit of %d", len(s), maxArgLen)
+		}
+		total += len(s) + 1
+	}
+	if total > maxArgvLen {
+		return fmt.Errorf("arguments of %d bytes exceed the limit of %d", total, maxArgvLen)
+	}
+	return nil
+}
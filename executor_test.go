@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// interruptingExecutor fails the first starts with EINTR, the way an exec
+// interrupted by a signal does, and then returns output.
+type interruptingExecutor struct {
+	interrupts int
+	err        error
+	cmds       []*exec.Cmd
+}
+
+func (e *interruptingExecutor) Run(cmd *exec.Cmd) ([]byte, error) {
+	e.cmds = append(e.cmds, cmd)
+	if len(e.cmds) <= e.interrupts {
+		return nil, &os.PathError{Op: "fork/exec", Path: cmd.Path, Err: syscall.EINTR}
+	}
+	return []byte("ok"), e.err
+}
+
+// executorFunc runs commands with a function.
+type executorFunc func(cmd *exec.Cmd) ([]byte, error)
+
+func (f executorFunc) Run(cmd *exec.Cmd) ([]byte, error) {
+	return f(cmd)
+}
+
+func TestRetryExecutor(t *testing.T) {
+	t.Run("interrupted start is retried", func(t *testing.T) {
+		fake := &interruptingExecutor{interrupts: 1}
+		cmd := exec.Command("sshfs", "host:/data", "/mnt")
+		cmd.Env = []string{"SSHPASS=secret"}
+
+		output, err := retryExecutor{next: fake}.Run(cmd)
+		AssertNoError(t, err, "run")
+		AssertEqual(t, "ok", string(output), "output")
+		AssertEqual(t, 2, len(fake.cmds), "attempts")
+		retry := fake.cmds[1]
+		AssertNotEqual(t, cmd, retry, "retry command")
+		AssertEqual(t, "sshfs host:/data /mnt", strings.Join(retry.Args, " "), "args")
+		AssertEqual(t, "SSHPASS=secret", strings.Join(retry.Env, " "), "env")
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		fake := &interruptingExecutor{interrupts: execRetries + 10}
+		_, err := retryExecutor{next: fake}.Run(exec.Command("sshfs"))
+		AssertError(t, err, "run")
+		AssertEqual(t, true, errors.Is(err, syscall.EINTR), "error wraps EINTR")
+		AssertContains(t, err.Error(), "interrupted 4 times", "error")
+		AssertEqual(t, execRetries+1, len(fake.cmds), "attempts")
+	})
+
+	t.Run("retries keep the context", func(t *testing.T) {
+		fake := &interruptingExecutor{interrupts: 1}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		output, err := retryExecutor{next: fake}.RunContext(ctx, exec.Command("sshfs", "host:/data", "/mnt"))
+		AssertNoError(t, err, "run")
+		AssertEqual(t, "ok", string(output), "output")
+		AssertEqual(t, 2, len(fake.cmds), "attempts")
+		for i, cmd := range fake.cmds {
+			AssertEqual(t, true, cmd.Cancel != nil, fmt.Sprintf("attempt %d bound to the context", i+1))
+			AssertEqual(t, "sshfs host:/data /mnt", strings.Join(cmd.Args, " "), "args")
+		}
+	})
+
+	t.Run("cancelled context is not retried", func(t *testing.T) {
+		fake := &interruptingExecutor{interrupts: 1}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := retryExecutor{next: fake}.RunContext(ctx, exec.Command("sshfs"))
+		AssertError(t, err, "run")
+		AssertEqual(t, 1, len(fake.cmds), "attempts")
+	})
+
+	t.Run("command errors are not retried", func(t *testing.T) {
+		fake := &interruptingExecutor{err: errors.New("exit status 1")}
+		output, err := retryExecutor{next: fake}.Run(exec.Command("sshfs"))
+		AssertError(t, err, "run")
+		AssertEqual(t, "ok", string(output), "output")
+		AssertEqual(t, 1, len(fake.cmds), "attempts")
+	})
+}
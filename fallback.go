@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseFallbackHosts parses a comma-separated list of [user@]host[:port]
+// entries.
+func parseFallbackHosts(val string) ([]string, error) {
+	var hosts []string
+	for _, spec := range strings.Split(val, ",") {
+		spec = strings.TrimSpace(spec)
+		dest, port := splitSshcmd(spec)
+		if dest == "" || strings.HasSuffix(dest, "@") {
+			return nil, fmt.Errorf("invalid fallback host %q", spec)
+		}
+		if port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf("invalid port in fallback host %q", spec)
+			}
+		}
+		hosts = append(hosts, spec)
+	}
+	return hosts, nil
+}
+
+// mountTargets returns the volume as it is followed by a copy for each of
+// its fallback hosts. The copies share the remote path and credentials, and
+// the user and port of the primary host unless they set their own. The host
+// the volume was last mounted from comes first, so a volume that failed over
+// stays on its fallback instead of flapping back.
+func mountTargets(v *sshfsVolume) []*sshfsVolume {
+	targets := []*sshfsVolume{v}
+	dest, remotePath := splitSshcmd(v.Sshcmd)
+	user, _, hasUser := strings.Cut(dest, "@")
+	for _, spec := range v.FallbackHosts {
+		host, port := splitSshcmd(spec)
+		if hasUser && !strings.Contains(host, "@") {
+			host = user + "@" + host
+		}
+		if port == "" {
+			port = v.Port
+		}
+		target := *v
+		target.Sshcmd = host + ":" + remotePath
+		target.Port = port
+		targets = append(targets, &target)
+	}
+
+	for i, target := range targets {
+		if i > 0 && hostLabel(target) == v.LastHost {
+			copy(targets[1:i+1], targets[:i])
+			targets[0] = target
+			break
+		}
+	}
+	return targets
+}
+
+// hostLabel names the host a volume target connects to, for status.
+func hostLabel(v *sshfsVolume) string {
+	dest, _ := splitSshcmd(v.Sshcmd)
+	if v.Port == "" {
+		return dest
+	}
+	return dest + ":" + v.Port
+}
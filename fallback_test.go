@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestFallbackHosts tests mounting from fallback hosts when the primary fails
+func TestFallbackHosts(t *testing.T) {
+	t.Run("fallback succeeds", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+		executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name: "test-volume",
+			Options: map[string]string{
+				"sshcmd":         "user@primary:/data",
+				"port":           "2222",
+				"fallback_hosts": "backup1, admin@backup2:22",
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Expected mount through a fallback host, got %v", err)
+		}
+
+		cmds := executor.GetCommands()
+		AssertEqual(t, 3, len(cmds), "mount attempts")
+		AssertContains(t, strings.Join(cmds[0], " "), "user@primary:/data", "first attempt")
+		AssertContains(t, strings.Join(cmds[1], " "), "user@backup1:/data "+driver.volumes["test-volume"].Mountpoint+" -p 2222", "second attempt")
+		AssertContains(t, strings.Join(cmds[2], " "), "admin@backup2:/data "+driver.volumes["test-volume"].Mountpoint+" -p 22", "third attempt")
+
+		resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+		if err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+		AssertEqual(t, "admin@backup2:22", resp.Volume.Status["host"], "host status")
+	})
+
+	t.Run("all hosts fail", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+		executor.AddMockResponse([]byte("no route to host"), fmt.Errorf("exit status 1"))
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@primary:/data", "fallback_hosts": "backup"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		_, err = driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		if err == nil {
+			t.Fatal("Expected mount to fail when every host fails")
+		}
+		AssertContains(t, err.Error(), "no route to host", "mount error")
+		AssertEqual(t, 0, driver.volumes["test-volume"].connections, "connections")
+	})
+
+	t.Run("invalid entries", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, hosts := range []string{"backup,", "user@", "backup:/data", "backup:99999"} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@primary:/data", "fallback_hosts": hosts},
+			})
+			if err == nil {
+				t.Errorf("Expected fallback_hosts %q to be rejected", hosts)
+			}
+		}
+	})
+}
+
+// TestFallbackHostPreferred tests that the host a volume last mounted from is tried first
+func TestFallbackHostPreferred(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "test-volume",
+		Options: map[string]string{"sshcmd": "user@primary:/data", "fallback_hosts": "backup1,backup2"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to unmount volume: %v", err)
+	}
+	AssertEqual(t, "user@backup1", driver.volumes["test-volume"].LastHost, "last host")
+
+	// The recorded host survives a restart and is tried first.
+	restarted, err := newSshfsDriver(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart driver: %v", err)
+	}
+	executor.Reset()
+	executor.AddMockResponse(nil, nil)
+	restarted.executor = executor
+
+	if _, err := restarted.Mount(&volume.MountRequest{Name: "test-volume", ID: "c2"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	AssertEqual(t, 1, executor.GetCommandCount(), "mount attempts")
+	AssertContains(t, strings.Join(executor.LastCmd().Args, " "), "user@backup1:/data", "mount command")
+
+	// When the recorded host fails, the list is scanned again from the top.
+	executor.Reset()
+	executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+	executor.AddMockResponse(nil, nil)
+	restarted.volumes["test-volume"].connections = 0
+	if _, err := restarted.Mount(&volume.MountRequest{Name: "test-volume", ID: "c3"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	cmds := executor.GetCommands()
+	AssertEqual(t, 2, len(cmds), "mount attempts")
+	AssertContains(t, strings.Join(cmds[1], " "), "user@primary:/data", "second attempt")
+	AssertEqual(t, "user@primary", restarted.volumes["test-volume"].LastHost, "last host")
+}
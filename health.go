@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mountExpected reports whether the volume is supposed to be mounted, with
+// no mount in flight. The caller must hold the driver lock.
+func mountExpected(v *sshfsVolume) bool {
+	return v.connections > 0 && v.mounting == nil
+}
+
+// mountpointDead reports whether the sshfs connection of a mountpoint has
+// died, leaving it failing with ENOTCONN, or hangs, not answering a stat
+// within MountCheckTimeout. A stat of a FUSE mount can block for as long
+// as its server does, so it must not be called with the driver lock held;
+// a hung stat is left behind rather than waited for.
+func (d *sshfsDriver) mountpointDead(mountpoint string) bool {
+	result := make(chan error, 1)
+	go func() {
+		_, err := d.stat(mountpoint)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return errors.Is(err, syscall.ENOTCONN)
+	case <-time.After(d.config.MountCheckTimeout):
+		logrus.WithField("mountpoint", mountpoint).Warnf("mountpoint did not answer within %v", d.config.MountCheckTimeout)
+		return true
+	}
+}
+
+// deadMountpoints checks the mountpoints concurrently, so that hung mounts
+// cost one MountCheckTimeout in all, and returns the dead ones.
+func (d *sshfsDriver) deadMountpoints(mountpoints []string) map[string]bool {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	dead := make(map[string]bool)
+	checked := make(map[string]bool)
+	for _, mountpoint := range mountpoints {
+		if checked[mountpoint] {
+			continue
+		}
+		checked[mountpoint] = true
+		wg.Add(1)
+		go func(mountpoint string) {
+			defer wg.Done()
+			if d.mountpointDead(mountpoint) {
+				mu.Lock()
+				dead[mountpoint] = true
+				mu.Unlock()
+			}
+		}(mountpoint)
+	}
+	wg.Wait()
+	return dead
+}
+
+// checkMount reports whether the named volume's mount is degraded. When
+// auto-remount is enabled a degraded mount is remounted first, and only
+// reported if that fails.
+func (d *sshfsDriver) checkMount(name string) bool {
+	d.RLock()
+	v, ok := d.volumes[name]
+	if !ok || !mountExpected(v) {
+		d.RUnlock()
+		return false
+	}
+	mountpoint, last := v.Mountpoint, v.lastRemount
+	d.RUnlock()
+
+	if !d.mountpointDead(mountpoint) {
+		return false
+	}
+	d.Lock()
+	if v, ok := d.volumes[name]; ok && !v.degraded {
+		v.degraded = true
+		d.emit("degraded", name, "")
+	}
+	d.Unlock()
+	if !d.config.AutoRemount {
+		return true
+	}
+	if err := d.remount(name, last); err != nil {
+		return true
+	}
+	return false
+}
+
+// remount replaces the dead sshfs mount of the named volume with a new one,
+// unless the volume was remounted since last or is no longer mounted. Like
+// a Mount it runs without the driver lock, through the host limit, as the
+// volume's in-flight mount: Mount calls wait for it and Remove cancels it.
+func (d *sshfsDriver) remount(name string, last time.Time) error {
+	d.Lock()
+	v, ok := d.volumes[name]
+	if !ok || !mountExpected(v) || !v.lastRemount.Equal(last) {
+		d.Unlock()
+		return nil
+	}
+
+	logrus.WithField("volume", name).Warn("remounting degraded mount")
+	dead := v.host
+	if dead == "" {
+		dead = hostLabel(v)
+	}
+	if v.remounts == nil {
+		v.remounts = make(map[string]int)
+	}
+	v.remounts[dead]++
+	v.lastRemount = d.now()
+
+	call := newMountCall()
+	v.mounting = call
+	d.Unlock()
+
+	var host string
+	if call.err = d.unmountVolume(v.Mountpoint); call.err == nil {
+		host, call.err = d.prepareAndMount(call.ctx, name, v)
+	}
+	call.cancel()
+
+	d.Lock()
+	v.mounting = nil
+	if call.err == nil {
+		d.recordHost(v, host)
+		v.degraded = false
+		// The last container may have let go of the volume meanwhile.
+		if v.connections == 0 && !d.mountpointHeld(name, v.Mountpoint) {
+			if err := d.unmountVolume(v.Mountpoint); err != nil {
+				logrus.WithField("mountpoint", v.Mountpoint).Warnf("failed to unmount released volume: %v", err)
+			}
+		}
+	}
+	d.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return logError("remount of volume %s failed: %v", name, call.err)
+	}
+	return nil
+}
+
+// checkHealth checks the mount of every volume that is supposed to be
+// mounted, as Get and Path do for theirs, so that dead mounts are reported
+// and, with auto-remount, repaired even when Docker asks for none of them.
+// The free space of healthy mounts is checked too, keeping low_space
+// current for /status and the metrics. It returns the number of mounts
+// left degraded.
+func (d *sshfsDriver) checkHealth() int {
+	d.RLock()
+	var names []string
+	for name, v := range d.volumes {
+		if mountExpected(v) {
+			names = append(names, name)
+		}
+	}
+	d.RUnlock()
+
+	degraded := 0
+	for _, name := range names {
+		if d.checkMount(name) {
+			degraded++
+			continue
+		}
+		d.checkSpace(name)
+	}
+	return degraded
+}
+
+// healthLoop runs checkHealth every interval, forever.
+func (d *sshfsDriver) healthLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.checkHealth()
+	}
+}
+
+// remountCount returns how often the volume's mount was remounted.
+func (v *sshfsVolume) remountCount() int {
+	n := 0
+	for _, count := range v.remounts {
+		n += count
+	}
+	return n
+}
+
+// reconcile resets the connection count of volumes that Docker believes are
+// mounted but that are missing from the mount table. Such phantom
+// connections are left behind when the daemon dies without unmounting, and
+// would otherwise block Remove forever. It returns the number of volumes
+// corrected.
+func (d *sshfsDriver) reconcile() int {
+	d.Lock()
+	defer d.Unlock()
+
+	corrected := 0
+	for name, v := range d.volumes {
+		if v.connections == 0 || v.mounting != nil {
+			continue
+		}
+		mounted, err := d.isMounted(v.Mountpoint)
+		if err != nil {
+			logrus.Warnf("failed to read mount table: %v", err)
+			return corrected
+		}
+		if mounted {
+			continue
+		}
+		logrus.WithField("volume", name).Warnf("resetting %d connection(s) of a volume that is not mounted", v.connections)
+		v.connections = 0
+		v.containers = nil
+		v.host = ""
+		d.hostLimit.drop(v.Mountpoint)
+		corrected++
+	}
+	return corrected
+}
+
+// reconcileLoop runs reconcile every interval, forever.
+func (d *sshfsDriver) reconcileLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.reconcile()
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// staleStat simulates a mountpoint whose sshfs connection has died
+func staleStat(name string) (os.FileInfo, error) {
+	return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.ENOTCONN}
+}
+
+// TestDegradedMount tests Path and Get on a mount that fails with ENOTCONN
+func TestDegradedMount(t *testing.T) {
+	setup := func(t *testing.T) (*sshfsDriver, string) {
+		driver, tmpDir := setupTestDriver(t)
+		driver.volumes["test-volume"] = &sshfsVolume{
+			Sshcmd:      "user@host:/path",
+			Mountpoint:  filepath.Join(tmpDir, "volumes", "test"),
+			connections: 1,
+		}
+		driver.stat = staleStat
+		return driver, tmpDir
+	}
+
+	t.Run("path reports degraded mount", func(t *testing.T) {
+		driver, tmpDir := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		_, err := driver.Path(&volume.PathRequest{Name: "test-volume"})
+		if err == nil {
+			t.Fatal("Expected error for a degraded mount")
+		}
+		AssertContains(t, err.Error(), "degraded", "error message")
+	})
+
+	t.Run("get reports degraded status", func(t *testing.T) {
+		driver, tmpDir := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+		if err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+		if resp.Volume.Status["degraded"] != true {
+			t.Errorf("Expected degraded status, got %v", resp.Volume.Status)
+		}
+	})
+
+	t.Run("unmounted volume is not degraded", func(t *testing.T) {
+		driver, tmpDir := setup(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.volumes["test-volume"].connections = 0
+
+		if _, err := driver.Path(&volume.PathRequest{Name: "test-volume"}); err != nil {
+			t.Fatalf("Expected no error for an unmounted volume, got %v", err)
+		}
+	})
+
+	t.Run("auto-remount restores the mount", func(t *testing.T) {
+		driver, tmpDir := setup(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.AutoRemount = true
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		resp, err := driver.Path(&volume.PathRequest{Name: "test-volume"})
+		if err != nil {
+			t.Fatalf("Expected remount to succeed, got %v", err)
+		}
+		if resp.Mountpoint != driver.volumes["test-volume"].Mountpoint {
+			t.Errorf("Expected mountpoint %s, got %s", driver.volumes["test-volume"].Mountpoint, resp.Mountpoint)
+		}
+
+		executor.AssertCommandContains(t, driver.unmountTool)
+		executor.AssertCommandContains(t, "sshfs")
+	})
+
+	t.Run("auto-remount is limited and runs without the lock", func(t *testing.T) {
+		driver, tmpDir := setup(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.AutoRemount = true
+		driver.config.MaxHostMounts = 1
+		driver.config.HostLimitPolicy = "fail"
+		driver.volumes["other"] = &sshfsVolume{
+			Sshcmd:     "user@host:/other",
+			Mountpoint: filepath.Join(tmpDir, "volumes", "other"),
+		}
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		executor.OnRun = func(cmd *exec.Cmd) {
+			if cmd.Args[0] == "sshfs" {
+				close(started)
+				<-release
+			}
+		}
+		driver.executor = executor
+
+		remounted := make(chan error)
+		go func() {
+			_, err := driver.Path(&volume.PathRequest{Name: "test-volume"})
+			remounted <- err
+		}()
+		<-started
+
+		_, err := driver.Mount(&volume.MountRequest{Name: "other", ID: "c1"})
+		AssertError(t, err, "mount while the remount holds the host slot")
+		AssertContains(t, err.Error(), "too many concurrent mounts", "mount error")
+
+		close(release)
+		AssertNoError(t, <-remounted, "remount")
+		AssertEqual(t, (*mountCall)(nil), driver.volumes["test-volume"].mounting, "mount in flight")
+	})
+}
+
+// TestHungMount tests that a mountpoint that does not answer is degraded
+// and checked without holding the driver lock
+func TestHungMount(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.config.MountCheckTimeout = 50 * time.Millisecond
+	driver.volumes["test-volume"] = &sshfsVolume{
+		Sshcmd:      "user@host:/path",
+		Mountpoint:  filepath.Join(tmpDir, "volumes", "test"),
+		connections: 1,
+	}
+
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	defer close(release)
+	driver.stat = func(name string) (os.FileInfo, error) {
+		started <- struct{}{}
+		<-release
+		return nil, nil
+	}
+
+	status := make(chan driverStatus)
+	go func() { status <- driver.status() }()
+	<-started
+	if _, err := driver.List(); err != nil {
+		t.Fatalf("Failed to list volumes during a mount check: %v", err)
+	}
+	AssertEqual(t, 1, (<-status).DegradedMounts, "degraded mounts")
+
+	dump, err := driver.dump("test-volume")
+	AssertNoError(t, err, "dump")
+	AssertEqual(t, true, dump.Degraded, "dump degraded")
+
+	_, err = driver.Path(&volume.PathRequest{Name: "test-volume"})
+	AssertError(t, err, "path of a hung mount")
+}
+
+// TestCheckHealth tests the periodic check of every mounted volume
+func TestCheckHealth(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.stat = staleStat
+	driver.volumes["dead"] = &sshfsVolume{Sshcmd: "user@host:/dead", Mountpoint: filepath.Join(tmpDir, "volumes", "dead"), connections: 1}
+	driver.volumes["idle"] = &sshfsVolume{Sshcmd: "user@host:/idle", Mountpoint: filepath.Join(tmpDir, "volumes", "idle")}
+	events, cancel := driver.events.subscribe()
+	defer cancel()
+
+	AssertEqual(t, 1, driver.checkHealth(), "degraded mounts")
+	AssertEqual(t, true, driver.volumes["dead"].degraded, "dead volume degraded")
+	AssertEqual(t, false, driver.volumes["idle"].degraded, "idle volume degraded")
+	AssertEqual(t, "degraded", (<-events).Type, "event")
+
+	driver.config.AutoRemount = true
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	AssertEqual(t, 0, driver.checkHealth(), "degraded mounts after remounting")
+	AssertEqual(t, 1, driver.volumes["dead"].remountCount(), "remounts")
+}
+
+// TestReconcile tests that phantom connection counts are reset
+func TestReconcile(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	mounted := filepath.Join(tmpDir, "volumes", "mounted")
+	driver.mountsPath = filepath.Join(tmpDir, "mounts")
+	table := "proc /proc proc rw 0 0\nuser@host:/a " + mounted + " fuse.sshfs rw 0 0\n"
+	if err := os.WriteFile(driver.mountsPath, []byte(table), 0o644); err != nil {
+		t.Fatalf("Failed to write mount table: %v", err)
+	}
+	driver.volumes["mounted"] = &sshfsVolume{Sshcmd: "user@host:/a", Mountpoint: mounted, connections: 2, host: "host"}
+	driver.volumes["phantom"] = &sshfsVolume{Sshcmd: "user@host:/b", Mountpoint: filepath.Join(tmpDir, "volumes", "phantom"), connections: 1, host: "host"}
+	driver.volumes["mounting"] = &sshfsVolume{Sshcmd: "user@host:/c", Mountpoint: filepath.Join(tmpDir, "volumes", "mounting"), connections: 1, mounting: &mountCall{}}
+
+	AssertEqual(t, 1, driver.reconcile(), "volumes corrected")
+	AssertEqual(t, 0, driver.volumes["phantom"].connections, "phantom connections")
+	AssertEqual(t, "", driver.volumes["phantom"].host, "phantom host")
+	AssertEqual(t, 2, driver.volumes["mounted"].connections, "mounted connections")
+	AssertEqual(t, 1, driver.volumes["mounting"].connections, "mounting connections")
+
+	if err := driver.Remove(&volume.RemoveRequest{Name: "phantom"}); err != nil {
+		t.Errorf("Expected a reconciled volume to be removable: %v", err)
+	}
+}
+
+// TestRemountCounter tests the remount count, timestamp and metric
+func TestRemountCounter(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.config.AutoRemount = true
+	driver.stat = staleStat
+	driver.now = fakeClock(time.Minute, 5*time.Minute)
+	driver.volumes["test-volume"] = &sshfsVolume{
+		Sshcmd:      "user@host:/path",
+		Mountpoint:  filepath.Join(tmpDir, "volumes", "test"),
+		connections: 1,
+		host:        "user@host",
+	}
+
+	executor := NewTestCommandExecutor()
+	driver.executor = executor
+
+	for i, want := range []string{"2024-01-01T00:01:00Z", "2024-01-01T00:05:00Z"} {
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+
+		resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+		if err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+		AssertEqual(t, i+1, resp.Volume.Status["remounts"], "remounts")
+		AssertEqual(t, want, resp.Volume.Status["last_remount"], "last remount")
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	AssertContains(t, rec.Body.String(), "# TYPE sshfs_remounts_total counter", "metrics")
+	AssertContains(t, rec.Body.String(), `sshfs_remounts_total{volume="test-volume",host="user@host"} 2`, "metrics")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// historySize is how many mount and unmount attempts are kept per volume.
+const historySize = 20
+
+// volumeOp is a mount or unmount attempt in a volume's history.
+type volumeOp struct {
+	Op        string    `json:"op"`
+	Container string    `json:"container,omitempty"`
+	Time      time.Time `json:"time"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// record adds the outcome of an attempt to the volume's history, dropping
+// the oldest attempt once historySize are kept. The history lives in memory
+// only, like the mounts it describes.
+func (v *sshfsVolume) record(op, container string, err error) {
+	entry := volumeOp{Op: op, Container: container, Time: time.Now()}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if len(v.history) == historySize {
+		v.history = append(v.history[:0], v.history[1:]...)
+	}
+	v.history = append(v.history, entry)
+}
+
+// history returns the latest mount and unmount attempts of the named
+// volume, oldest first.
+func (d *sshfsDriver) history(name string) ([]volumeOp, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	v, ok := d.volumes[name]
+	if !ok {
+		return nil, fmt.Errorf("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	return append([]volumeOp{}, v.history...), nil
+}
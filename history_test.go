@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestVolumeHistory tests that mount and unmount attempts are kept per volume
+func TestVolumeHistory(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+	driver.executor = executor
+
+	for _, name := range []string{"test-volume", "other-volume"} {
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"sshcmd": "user@host:/" + name}}); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	mount := func(id string) error {
+		_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: id})
+		return err
+	}
+	unmount := func(id string) error {
+		return driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: id})
+	}
+	for _, step := range []struct {
+		op      func(string) error
+		id      string
+		wantErr bool
+	}{
+		{mount, "c1", false},
+		{mount, "c2", false},
+		{unmount, "c2", false},
+		{unmount, "c1", false},
+		{mount, "c3", true},
+	} {
+		if err := step.op(step.id); (err != nil) != step.wantErr {
+			t.Fatalf("Unexpected outcome for %s: %v", step.id, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/test-volume/history", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var ops []volumeOp
+	if err := json.Unmarshal(rec.Body.Bytes(), &ops); err != nil {
+		t.Fatalf("Failed to decode history: %v", err)
+	}
+	var got []string
+	for _, op := range ops {
+		got = append(got, fmt.Sprintf("%s:%s:%t", op.Op, op.Container, op.Error == ""))
+		if op.Time.IsZero() {
+			t.Errorf("Expected a time for %s of %s", op.Op, op.Container)
+		}
+	}
+	AssertEqual(t, "mount:c1:true,mount:c2:true,unmount:c2:true,unmount:c1:true,mount:c3:false",
+		strings.Join(got, ","), "history")
+	AssertContains(t, ops[len(ops)-1].Error, "connection refused", "failed mount error")
+
+	other, err := driver.history("other-volume")
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	AssertEqual(t, 0, len(other), "history of other volume")
+
+	rec = httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes/missing/history", nil))
+	AssertEqual(t, http.StatusNotFound, rec.Code, "unknown volume status code")
+}
+
+// TestVolumeHistorySize tests that only the latest attempts are kept
+func TestVolumeHistorySize(t *testing.T) {
+	v := &sshfsVolume{}
+	for i := 0; i < historySize+5; i++ {
+		v.record("mount", fmt.Sprintf("c%d", i), nil)
+	}
+	AssertEqual(t, historySize, len(v.history), "history length")
+	AssertEqual(t, "c5", v.history[0].Container, "oldest kept")
+	AssertEqual(t, fmt.Sprintf("c%d", historySize+4), v.history[historySize-1].Container, "newest")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hostKey is one public key a server presented to ssh-keyscan.
+type hostKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	line        string
+}
+
+// ScanHostKey fetches the host keys of host with ssh-keyscan and returns
+// their SHA256 fingerprints. When write is set the keys are also added to
+// the managed known_hosts file, so volumes can be created with strict host
+// key checking against a server that was pre-seeded this way.
+func (d *sshfsDriver) ScanHostKey(host, port string, write bool) ([]hostKey, error) {
+	logrus.WithField("method", "scan host key").Debugf("%s %s", host, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.KeyscanTimeout)
+	defer cancel()
+
+	args := []string{"-T", fmt.Sprint(int(d.config.KeyscanTimeout.Seconds()))}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, host)
+	cmd := exec.CommandContext(ctx, "ssh-keyscan", args...)
+	logrus.Debug(cmd.Args)
+	output, err := d.executor.Run(cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, logError("ssh-keyscan of %s timed out after %v", host, d.config.KeyscanTimeout)
+	}
+	if err != nil {
+		return nil, logError("ssh-keyscan of %s failed: %v (%s)", host, err, output)
+	}
+
+	keys := parseKeyscan(output)
+	if len(keys) == 0 {
+		return nil, logError("no host keys received from %s; is it reachable? (%s)", host, strings.TrimSpace(string(output)))
+	}
+
+	if write {
+		if err := d.addKnownHosts(keys); err != nil {
+			return nil, logError("failed to write %s: %v", d.knownHostsPath, err)
+		}
+	}
+	return keys, nil
+}
+
+// checkScanTarget rejects a host or port that ssh-keyscan would take for
+// an option or that is not a port number.
+func checkScanTarget(host, port string) error {
+	if host == "" || strings.HasPrefix(host, "-") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port %q", port)
+		}
+	}
+	return nil
+}
+
+// parseKeyscan extracts the host keys from ssh-keyscan output, skipping
+// comments and any diagnostics mixed in from stderr.
+func parseKeyscan(output []byte) []hostKey {
+	var keys []hostKey
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil || !isKeyBlob(blob, fields[1]) {
+			continue
+		}
+		sum := sha256.Sum256(blob)
+		keys = append(keys, hostKey{
+			Type:        fields[1],
+			Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]),
+			line:        strings.Join(fields[:3], " "),
+		})
+	}
+	return keys
+}
+
+// isKeyBlob reports whether blob is a public key in ssh wire format, which
+// starts with its length-prefixed key type.
+func isKeyBlob(blob []byte, keyType string) bool {
+	if len(blob) < 4 {
+		return false
+	}
+	n := binary.BigEndian.Uint32(blob)
+	return uint64(n) <= uint64(len(blob)-4) && string(blob[4:4+n]) == keyType
+}
+
+// addKnownHosts appends the keys that are not yet in the managed
+// known_hosts file.
+func (d *sshfsDriver) addKnownHosts(keys []hostKey) error {
+	d.Lock()
+	defer d.Unlock()
+
+	existing := map[string]bool{}
+	data, err := os.ReadFile(d.knownHostsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.knownHostsPath), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(d.knownHostsPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if existing[key.line] {
+			continue
+		}
+		if _, err := fmt.Fprintln(f, key.line); err != nil {
+			f.Close()
+			return err
+		}
+		existing[key.line] = true
+	}
+	return f.Close()
+}
+
+// hostKeyOptions returns the host key options ssh and sshfs get ahead of
+// the volume's own, which ssh would not override as it keeps the first
+// value of an option. Host key checking is off unless the volume sets
+// StrictHostKeyChecking or UserKnownHostsFile. Once the managed known_hosts
+// file has been seeded, keys are looked up there unless the volume names
+// its own file.
+func (d *sshfsDriver) hostKeyOptions(v *sshfsVolume) []string {
+	var options []string
+	if !hasOption(v, "StrictHostKeyChecking") && !hasOption(v, "UserKnownHostsFile") {
+		options = append(options, "-oStrictHostKeyChecking=no")
+	}
+	if !hasOption(v, "UserKnownHostsFile") {
+		if fi, err := os.Stat(d.knownHostsPath); err == nil && fi.Size() > 0 {
+			options = append(options, "-oUserKnownHostsFile="+d.knownHostsPath)
+		}
+	}
+	return options
+}
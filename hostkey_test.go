@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const keyscanOutput = `# host:22 SSH-2.0-OpenSSH_9.6
+host ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAILuJcn1hRh+WbRZiVJEM9wkU8/0uN8QzFcFjScc+HQPY
+# host:22 SSH-2.0-OpenSSH_9.6
+`
+
+// TestScanHostKey tests host key scanning with mocked ssh-keyscan output
+func TestScanHostKey(t *testing.T) {
+	t.Run("returns fingerprints", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte(keyscanOutput), nil)
+		driver.executor = executor
+
+		keys, err := driver.ScanHostKey("host", "2222", false)
+		if err != nil {
+			t.Fatalf("Failed to scan host key: %v", err)
+		}
+		if len(keys) != 1 {
+			t.Fatalf("Expected 1 key, got %d", len(keys))
+		}
+		AssertEqual(t, "ssh-ed25519", keys[0].Type, "key type")
+		AssertEqual(t, "SHA256:J+wDB8Xv7yk2z0R8ly+XI8Ta2w8unTowh093PLKhtio", keys[0].Fingerprint, "fingerprint")
+
+		executor.AssertCommand(t, "ssh-keyscan -T 10 -p 2222 host")
+		AssertFileNotExists(t, driver.knownHostsPath)
+	})
+
+	t.Run("writes known_hosts once", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte(keyscanOutput), nil)
+		executor.AddMockResponse([]byte(keyscanOutput), nil)
+		driver.executor = executor
+
+		for i := 0; i < 2; i++ {
+			if _, err := driver.ScanHostKey("host", "", true); err != nil {
+				t.Fatalf("Failed to scan host key: %v", err)
+			}
+		}
+
+		data, err := os.ReadFile(driver.knownHostsPath)
+		if err != nil {
+			t.Fatalf("Failed to read known_hosts: %v", err)
+		}
+		if n := strings.Count(string(data), "ssh-ed25519"); n != 1 {
+			t.Errorf("Expected 1 known_hosts entry, got %d:\n%s", n, data)
+		}
+	})
+
+	t.Run("unreachable host", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("connect to host port 22: Connection refused\n"), nil)
+		driver.executor = executor
+
+		_, err := driver.ScanHostKey("host", "", true)
+		if err == nil {
+			t.Fatal("Expected error for an unreachable host")
+		}
+		AssertContains(t, err.Error(), "no host keys received from host", "scan error")
+		AssertContains(t, err.Error(), "Connection refused", "scan error")
+		AssertFileNotExists(t, driver.knownHostsPath)
+	})
+
+	t.Run("keyscan failure", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("getaddrinfo host: Name or service not known"), fmt.Errorf("exit status 1"))
+		driver.executor = executor
+
+		_, err := driver.ScanHostKey("host", "", false)
+		if err == nil {
+			t.Fatal("Expected error when ssh-keyscan fails")
+		}
+		AssertContains(t, err.Error(), "Name or service not known", "scan error")
+	})
+}
+
+// TestScanHostKeyEndpoint tests host key scanning through the control API
+func TestScanHostKeyEndpoint(t *testing.T) {
+	scan := func(t *testing.T, driver *sshfsDriver, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		return rec
+	}
+
+	t.Run("returns fingerprints and writes known_hosts", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte(keyscanOutput), nil)
+		driver.executor = executor
+
+		rec := scan(t, driver, "/hosts/host/scan?port=2222&write=true")
+		AssertEqual(t, http.StatusOK, rec.Code, "status")
+		var keys []hostKey
+		if err := json.Unmarshal(rec.Body.Bytes(), &keys); err != nil {
+			t.Fatalf("Failed to decode keys: %v", err)
+		}
+		AssertEqual(t, 1, len(keys), "keys")
+		AssertEqual(t, "SHA256:J+wDB8Xv7yk2z0R8ly+XI8Ta2w8unTowh093PLKhtio", keys[0].Fingerprint, "fingerprint")
+		executor.AssertCommand(t, "ssh-keyscan -T 10 -p 2222 host")
+		AssertFileExists(t, driver.knownHostsPath)
+	})
+
+	t.Run("invalid targets are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		for _, target := range []string{
+			"/hosts/-oProxyCommand=x/scan",
+			"/hosts/host/scan?port=ssh",
+			"/hosts/host/scan?write=maybe",
+		} {
+			AssertEqual(t, http.StatusBadRequest, scan(t, driver, target).Code, "status of "+target)
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("unreachable host", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		rec := scan(t, driver, "/hosts/host/scan")
+		AssertEqual(t, http.StatusBadGateway, rec.Code, "status")
+		AssertContains(t, rec.Body.String(), "no host keys received from host", "body")
+	})
+}
+
+// TestHostKeyOptions tests that a volume's own host key options are not
+// overridden by the defaults and that a seeded known_hosts file is used
+func TestHostKeyOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  []string
+		seeded   bool
+		expected []string
+		absent   []string
+	}{
+		{"default", nil, false, []string{"-oStrictHostKeyChecking=no"}, []string{"UserKnownHostsFile"}},
+		{"seeded", nil, true, []string{"-oStrictHostKeyChecking=no", "-oUserKnownHostsFile=<managed>"}, nil},
+		{"strict", []string{"StrictHostKeyChecking=yes"}, false, []string{"StrictHostKeyChecking=yes"}, []string{"StrictHostKeyChecking=no", "UserKnownHostsFile"}},
+		{"strict and seeded", []string{"StrictHostKeyChecking=yes"}, true, []string{"-oUserKnownHostsFile=<managed>", "StrictHostKeyChecking=yes"}, []string{"StrictHostKeyChecking=no"}},
+		{"own known_hosts", []string{"UserKnownHostsFile=/etc/ssh/pinned"}, true, []string{"UserKnownHostsFile=/etc/ssh/pinned"}, []string{"StrictHostKeyChecking=no", "<managed>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			if tt.seeded {
+				if err := driver.addKnownHosts(parseKeyscan([]byte(keyscanOutput))); err != nil {
+					t.Fatalf("Failed to seed known_hosts: %v", err)
+				}
+			}
+			v := &sshfsVolume{Sshcmd: "user@host:/path", Mountpoint: "/mnt/volumes/test", Options: tt.options}
+
+			sshfs, err := driver.sshfsCommand("test-volume", v)
+			if err != nil {
+				t.Fatalf("Failed to build sshfs command: %v", err)
+			}
+			ssh, err := driver.sshCommand(context.Background(), v, "true")
+			if err != nil {
+				t.Fatalf("Failed to build ssh command: %v", err)
+			}
+			for _, args := range [][]string{sshfs.Args, ssh.Args} {
+				joined := strings.ReplaceAll(strings.Join(args, " "), driver.knownHostsPath, "<managed>")
+				last := -1
+				for _, option := range tt.expected {
+					i := strings.Index(joined, option)
+					if i <= last {
+						t.Errorf("Expected %q after the previous options in %s", option, joined)
+					}
+					last = i
+				}
+				for _, option := range tt.absent {
+					AssertNotContains(t, joined, option, args[0]+" args")
+				}
+			}
+		})
+	}
+}
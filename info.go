@@ -0,0 +1,60 @@
+package main
+
+// driverInfo describes the driver for tooling: its version, the limits and
+// authentication methods its configuration sets, where its control API is
+// served, and its features. It is served at /info; Docker's Capabilities
+// call only carries the scope.
+type driverInfo struct {
+	Version       string         `json:"version"`
+	Limits        driverLimits   `json:"limits"`
+	AuthMethods   []string       `json:"auth_methods"`
+	ControlSocket string         `json:"control_socket,omitempty"`
+	Endpoints     infoEndpoints  `json:"endpoints"`
+	Features      driverFeatures `json:"features"`
+}
+
+// driverLimits are the configured bounds on mounts and remote commands.
+// The driver caps neither the number of volumes nor the mounts across all
+// hosts, only those connected to each host.
+type driverLimits struct {
+	MaxHostMounts               int     `json:"max_host_mounts"`
+	HostLimitPolicy             string  `json:"host_limit_policy"`
+	RemoteCommandTimeoutSeconds float64 `json:"remote_command_timeout_seconds"`
+	BenchmarkTimeoutSeconds     float64 `json:"benchmark_timeout_seconds"`
+	KeyscanTimeoutSeconds       float64 `json:"keyscan_timeout_seconds"`
+	ProbeCacheTTLSeconds        float64 `json:"probe_cache_ttl_seconds"`
+}
+
+// infoEndpoints are the control API paths serving metrics, the health
+// summary and events.
+type infoEndpoints struct {
+	Metrics string `json:"metrics"`
+	Status  string `json:"status"`
+	Events  string `json:"events"`
+}
+
+// info describes the driver as /info serves it.
+func (d *sshfsDriver) info() driverInfo {
+	methods := []string{"publickey"}
+	if !d.config.DisableSshpass {
+		methods = append(methods, "password", "keyboard-interactive")
+	}
+	if d.config.CredentialsFile != "" {
+		methods = append(methods, "host_credentials")
+	}
+	return driverInfo{
+		Version: version,
+		Limits: driverLimits{
+			MaxHostMounts:               d.config.MaxHostMounts,
+			HostLimitPolicy:             d.config.HostLimitPolicy,
+			RemoteCommandTimeoutSeconds: d.config.RemoteCommandTimeout.Seconds(),
+			BenchmarkTimeoutSeconds:     d.config.BenchmarkTimeout.Seconds(),
+			KeyscanTimeoutSeconds:       d.config.KeyscanTimeout.Seconds(),
+			ProbeCacheTTLSeconds:        d.config.ProbeCacheTTL.Seconds(),
+		},
+		AuthMethods:   methods,
+		ControlSocket: d.config.ControlSocket,
+		Endpoints:     infoEndpoints{Metrics: "/metrics", Status: "/status", Events: "/events"},
+		Features:      d.features(),
+	}
+}
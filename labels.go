@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxLabelKey   = 63
+	maxLabelValue = 255
+)
+
+var (
+	labelKey   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	labelValue = regexp.MustCompile(`^[A-Za-z0-9._:@/-]*$`)
+)
+
+// parseLabels parses the labels option: comma-separated key=value pairs.
+// Keys start and end with a letter or digit and may contain ._-/ in
+// between; values may be empty and contain letters, digits and ._-:@/.
+func parseLabels(val string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(val, ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		if err := checkLabel(key, value); err != nil {
+			return nil, err
+		}
+		if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("label %s is given more than once", key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func checkLabel(key, value string) error {
+	if len(key) > maxLabelKey || !labelKey.MatchString(key) {
+		return fmt.Errorf("invalid label key %q, expected up to %d letters, digits and ._-/ starting and ending with a letter or digit", key, maxLabelKey)
+	}
+	if len(value) > maxLabelValue || !labelValue.MatchString(value) {
+		return fmt.Errorf("invalid value %q for label %s, expected up to %d letters, digits and ._-:@/", value, key, maxLabelValue)
+	}
+	return nil
+}
+
+// labelSelector matches volumes by label: key=value requires the label to
+// have that value, a bare key only requires the label.
+type labelSelector struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+func parseLabelSelector(s string) (labelSelector, error) {
+	key, value, hasValue := strings.Cut(s, "=")
+	if err := checkLabel(key, value); err != nil {
+		return labelSelector{}, err
+	}
+	return labelSelector{key: key, value: value, hasValue: hasValue}, nil
+}
+
+func (s labelSelector) matches(labels map[string]string) bool {
+	value, ok := labels[s.key]
+	return ok && (!s.hasValue || value == s.value)
+}
+
+// volumeSummary is one volume in the control API listing.
+type volumeSummary struct {
+	Name        string            `json:"name"`
+	Mountpoint  string            `json:"mountpoint"`
+	Connections int               `json:"connections"`
+	Disabled    bool              `json:"disabled,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// listVolumes returns the volumes matching every selector, sorted by name.
+func (d *sshfsDriver) listVolumes(selectors []labelSelector) []volumeSummary {
+	d.RLock()
+	defer d.RUnlock()
+
+	list := []volumeSummary{}
+	for name, v := range d.volumes {
+		matched := true
+		for _, s := range selectors {
+			matched = matched && s.matches(v.Labels)
+		}
+		if matched {
+			list = append(list, volumeSummary{
+				Name:        name,
+				Mountpoint:  v.Mountpoint,
+				Connections: v.connections,
+				Disabled:    v.Disabled,
+				Labels:      v.Labels,
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
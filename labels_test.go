@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestLabels tests that labels persist, show in the status and filter the
+// control API listing
+func TestLabels(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	for name, labels := range map[string]string{
+		"web-data":   "team=web,env=prod",
+		"web-stage":  "team=web,env=staging,com.example/owner=alice",
+		"batch-data": "team=batch,env=prod",
+		"unlabeled":  "",
+	} {
+		options := map[string]string{"sshcmd": "user@host:/" + name}
+		if labels != "" {
+			options["labels"] = labels
+		}
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+			t.Fatalf("Failed to create volume %s: %v", name, err)
+		}
+	}
+
+	driver, err := newSshfsDriver(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart driver: %v", err)
+	}
+	AssertEqual(t, "map[com.example/owner:alice env:staging team:web]", fmt.Sprint(driver.volumes["web-stage"].Labels), "loaded labels")
+	AssertEqual(t, 0, len(driver.volumes["unlabeled"].Labels), "labels of an unlabeled volume")
+
+	resp, err := driver.Get(&volume.GetRequest{Name: "web-data"})
+	AssertNoError(t, err, "get")
+	AssertEqual(t, "map[env:prod team:web]", fmt.Sprint(resp.Volume.Status["labels"]), "labels status")
+	list, err := driver.List()
+	AssertNoError(t, err, "list")
+	AssertEqual(t, "map[env:prod team:batch]", fmt.Sprint(list.Volumes[0].Status["labels"]), "labels in list")
+
+	// The same options with other labels are a different definition.
+	err = driver.Create(&volume.CreateRequest{Name: "web-data", Options: map[string]string{"sshcmd": "user@host:/web-data", "labels": "team=web"}})
+	AssertError(t, err, "create with other labels")
+
+	for _, tt := range []struct {
+		query string
+		names string
+	}{
+		{"", "batch-data,unlabeled,web-data,web-stage"},
+		{"?label=team=web", "web-data,web-stage"},
+		{"?label=env=prod", "batch-data,web-data"},
+		{"?label=team=web&label=env=prod", "web-data"},
+		{"?label=com.example/owner", "web-stage"},
+		{"?label=team=ops", ""},
+	} {
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes"+tt.query, nil))
+		AssertEqual(t, http.StatusOK, rec.Code, "status code for "+tt.query)
+		var vols []volumeSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &vols); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		names := make([]string, len(vols))
+		for i, v := range vols {
+			names[i] = v.Name
+		}
+		AssertEqual(t, tt.names, strings.Join(names, ","), "volumes matching "+tt.query)
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/volumes?label=-bad", nil))
+	AssertEqual(t, http.StatusBadRequest, rec.Code, "status code of an invalid selector")
+}
+
+// TestParseLabels tests validation of the labels option
+func TestParseLabels(t *testing.T) {
+	for _, tt := range []struct {
+		val     string
+		wantErr string
+	}{
+		{"team=web,tier=", ""},
+		{"com.example/owner=alice@example.com", ""},
+		{"", "invalid label key"},
+		{"team", ""},
+		{"-team=web", "invalid label key"},
+		{"team.=web", "invalid label key"},
+		{strings.Repeat("k", maxLabelKey+1) + "=v", "invalid label key"},
+		{"team=web ops", "invalid value"},
+		{"team=" + strings.Repeat("v", maxLabelValue+1), "invalid value"},
+		{"team=web,team=ops", "label team is given more than once"},
+	} {
+		t.Run(tt.val, func(t *testing.T) {
+			_, err := parseLabels(tt.val)
+			if tt.wantErr == "" {
+				AssertNoError(t, err, "parse")
+				return
+			}
+			AssertError(t, err, "parse")
+			AssertContains(t, err.Error(), tt.wantErr, "parse error")
+		})
+	}
+}
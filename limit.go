@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// hostLimiter caps the number of sshfs mounts against each host. A mount
+// takes a slot of the host it connects to before sshfs runs and holds it,
+// by mountpoint, until the mountpoint is unmounted.
+type hostLimiter struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+	held  map[string]func()
+}
+
+// hostOf returns the host part of an sshcmd, without user or path.
+func hostOf(sshcmd string) string {
+	dest, _ := splitSshcmd(sshcmd)
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		dest = dest[i+1:]
+	}
+	return dest
+}
+
+// acquire takes one of max slots for host. With wait unset it fails instead
+// of queueing when all slots are taken, and a queued caller gives up when
+// ctx is done. A max of 0 means no limit.
+func (l *hostLimiter) acquire(ctx context.Context, host string, max int, wait bool) (release func(), err error) {
+	if max <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	if l.slots == nil {
+		l.slots = map[string]chan struct{}{}
+	}
+	slots, ok := l.slots[host]
+	if !ok || cap(slots) != max {
+		slots = make(chan struct{}, max)
+		l.slots[host] = slots
+	}
+	l.mu.Unlock()
+
+	if wait {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		select {
+		case slots <- struct{}{}:
+		default:
+			return nil, fmt.Errorf("too many concurrent mounts to %s (limit %d)", host, max)
+		}
+	}
+	return func() { <-slots }, nil
+}
+
+// hold keeps the slot that release frees taken until drop is called for
+// mountpoint.
+func (l *hostLimiter) hold(mountpoint string, release func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = map[string]func(){}
+	}
+	if prev, ok := l.held[mountpoint]; ok {
+		prev()
+	}
+	l.held[mountpoint] = release
+}
+
+// drop frees the slot held for mountpoint, if any.
+func (l *hostLimiter) drop(mountpoint string) {
+	l.mu.Lock()
+	release, ok := l.held[mountpoint]
+	delete(l.held, mountpoint)
+	l.mu.Unlock()
+	if ok {
+		release()
+	}
+}
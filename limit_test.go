@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestHostMountLimit tests the per-host cap on concurrent mounts
+func TestHostMountLimit(t *testing.T) {
+	createVolumes := func(t *testing.T, driver *sshfsDriver, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			host := "backend"
+			if i%3 == 0 {
+				host = "other"
+			}
+			err := driver.Create(&volume.CreateRequest{
+				Name:    fmt.Sprintf("volume-%d", i),
+				Options: map[string]string{"sshcmd": fmt.Sprintf("user%d@%s:/data/%d", i, host, i)},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+		}
+	}
+
+	t.Run("cap is respected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.MaxHostMounts = 2
+		createVolumes(t, driver, 12)
+		hosts := map[string]string{}
+		for _, v := range driver.volumes {
+			hosts[v.Mountpoint] = hostOf(v.Sshcmd)
+		}
+
+		// A mount holds its slot until it is unmounted, not just while
+		// sshfs runs.
+		var mu sync.Mutex
+		active, peak := map[string]int{}, map[string]int{}
+		executor := NewTestCommandExecutor()
+		executor.OnRun = func(cmd *exec.Cmd) {
+			mu.Lock()
+			defer mu.Unlock()
+			if cmd.Args[0] != "sshfs" {
+				active[hosts[cmd.Args[len(cmd.Args)-1]]]--
+				return
+			}
+			host := hostOf(cmd.Args[2])
+			active[host]++
+			if active[host] > peak[host] {
+				peak[host] = active[host]
+			}
+		}
+		for i := 0; i < 24; i++ {
+			executor.AddMockResponse(nil, nil)
+		}
+		driver.executor = executor
+
+		var wg sync.WaitGroup
+		for i := 0; i < 12; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := fmt.Sprintf("volume-%d", i)
+				if _, err := driver.Mount(&volume.MountRequest{Name: name, ID: "c1"}); err != nil {
+					t.Errorf("Failed to mount %s: %v", name, err)
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+				if err := driver.Unmount(&volume.UnmountRequest{Name: name, ID: "c1"}); err != nil {
+					t.Errorf("Failed to unmount %s: %v", name, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		AssertEqual(t, 24, executor.GetCommandCount(), "mounts and unmounts")
+		for host, n := range peak {
+			if n > 2 {
+				t.Errorf("Expected at most 2 concurrent mounts to %s, got %d", host, n)
+			}
+		}
+		AssertEqual(t, 2, peak["backend"], "peak mounts to backend")
+	})
+
+	t.Run("fail policy", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.MaxHostMounts = 1
+		driver.config.HostLimitPolicy = "fail"
+		createVolumes(t, driver, 3)
+
+		started, unblock := make(chan struct{}), make(chan struct{})
+		executor := NewTestCommandExecutor()
+		executor.OnRun = func(cmd *exec.Cmd) {
+			close(started)
+			<-unblock
+		}
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		done := make(chan error)
+		go func() {
+			_, err := driver.Mount(&volume.MountRequest{Name: "volume-1", ID: "c1"})
+			done <- err
+		}()
+		<-started
+
+		_, err := driver.Mount(&volume.MountRequest{Name: "volume-2", ID: "c1"})
+		if err == nil {
+			t.Error("Expected mount beyond the cap to fail")
+		} else {
+			AssertContains(t, err.Error(), "too many concurrent mounts to backend", "mount error")
+		}
+		close(unblock)
+		if err := <-done; err != nil {
+			t.Errorf("Failed to mount volume-1: %v", err)
+		}
+	})
+
+	t.Run("slot is held by the host used until unmount", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.MaxHostMounts = 1
+		driver.config.HostLimitPolicy = "fail"
+		for name, options := range map[string]map[string]string{
+			"primary":  {"sshcmd": "user@backend:/a"},
+			"fallback": {"sshcmd": "user@backend:/b", "fallback_hosts": "spare"},
+			"spare":    {"sshcmd": "user@spare:/c"},
+		} {
+			if err := driver.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+		}
+		executor := NewTestCommandExecutor()
+		for i := 0; i < 4; i++ {
+			executor.AddMockResponse(nil, nil)
+		}
+		driver.executor = executor
+
+		mount := func(name string) error {
+			_, err := driver.Mount(&volume.MountRequest{Name: name, ID: "c1"})
+			return err
+		}
+		AssertNoError(t, mount("primary"), "mount primary")
+		AssertNoError(t, mount("fallback"), "mount with backend full")
+		AssertEqual(t, "user@spare", driver.volumes["fallback"].host, "host of the fallback mount")
+
+		err := mount("spare")
+		AssertError(t, err, "mount with spare held by the fallback mount")
+		AssertContains(t, err.Error(), "too many concurrent mounts to spare", "mount error")
+
+		AssertNoError(t, driver.Unmount(&volume.UnmountRequest{Name: "fallback", ID: "c1"}), "unmount fallback")
+		AssertNoError(t, mount("spare"), "mount once spare is free")
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestRotatingFile tests size-based rotation of on-disk logs
+func TestRotatingFile(t *testing.T) {
+	t.Run("rotates past the size threshold and prunes old files", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "sshfs.log")
+
+		f, err := newRotatingFile(path, 100, 2)
+		if err != nil {
+			t.Fatalf("Failed to open log file: %v", err)
+		}
+		defer f.Close()
+
+		line := strings.Repeat("x", 39) + "\n"
+		for i := 0; i < 12; i++ {
+			if _, err := f.Write([]byte(line)); err != nil {
+				t.Fatalf("Failed to write: %v", err)
+			}
+		}
+
+		AssertFileExists(t, path)
+		AssertFileExists(t, path+".1")
+		AssertFileExists(t, path+".2")
+		AssertFileNotExists(t, path+".3")
+
+		for _, p := range []string{path, path + ".1", path + ".2"} {
+			info, err := os.Stat(p)
+			if err != nil {
+				t.Fatalf("Failed to stat %s: %v", p, err)
+			}
+			if info.Size() > 100 {
+				t.Errorf("Expected %s to be at most 100 bytes, got %d", p, info.Size())
+			}
+		}
+	})
+
+	t.Run("continues an existing file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "sshfs.log")
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", 90)), 0o644); err != nil {
+			t.Fatalf("Failed to write log file: %v", err)
+		}
+
+		f, err := newRotatingFile(path, 100, 1)
+		if err != nil {
+			t.Fatalf("Failed to open log file: %v", err)
+		}
+		defer f.Close()
+
+		if _, err := f.Write([]byte(strings.Repeat("y", 20))); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+
+		AssertFileExists(t, path+".1")
+	})
+
+	t.Run("concurrent writes are not lost", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "sshfs.log")
+
+		f, err := newRotatingFile(path, 1000, 100)
+		if err != nil {
+			t.Fatalf("Failed to open log file: %v", err)
+		}
+		defer f.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					f.Write([]byte("0123456789\n"))
+				}
+			}()
+		}
+		wg.Wait()
+
+		matches, err := filepath.Glob(path + "*")
+		if err != nil {
+			t.Fatalf("Failed to list log files: %v", err)
+		}
+		var total int64
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				t.Fatalf("Failed to stat %s: %v", m, err)
+			}
+			total += info.Size()
+		}
+		if total != 10*50*11 {
+			t.Errorf("Expected %d bytes across log files, got %d", 10*50*11, total)
+		}
+	})
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/sirupsen/logrus"
@@ -18,43 +26,231 @@ import (
 
 const socketAddress = "/run/docker/plugins/sshfs.sock"
 
+// ErrVolumeConflict is returned by Create when a volume of the same name
+// already exists with different options.
+var ErrVolumeConflict = errors.New("volume already exists with different options")
+
+// ErrVolumeNotFound is returned for requests naming a volume that does not
+// exist.
+var ErrVolumeNotFound = errors.New("volume not found")
+
 type sshfsVolume struct {
-	Password string
-	Sshcmd   string
-	Port     string
+	Password            string
+	PasswordFile        string
+	NoPersistPassword   bool
+	Sshcmd              string
+	Port                string
+	SSHConfig           string
+	CopyIdentityFile    bool
+	IdentitySource      string
+	MountpointMode      string
+	MountpointUID       string
+	MountpointGID       string
+	Umask               string
+	ServerAliveInterval string
+	ServerAliveCountMax string
+	Sync                bool
+	NoCache             bool
+	EntryTimeout        string
+	AttrTimeout         string
+	DcacheTimeout       string
+	CleanMountpoint     bool
+	KeepMountpoint      bool
+	VerifyWritable      bool
+	VerifySFTP          bool
+	DetectOS            bool
+	AutoCompression     bool
+	MountLabel          string
+	SetupCommand        string
+	SSHCommand          string
+	Env                 []string
+	MinFreeBytes        int64
+	MinFreePercent      int
+	SetupDone           bool
+	FallbackHosts       []string
+	Labels              map[string]string
+	// HostCredentials is set when the volume, having no credentials of its
+	// own, authenticates with those CREDENTIALS_FILE configures for its
+	// host.
+	HostCredentials bool
+	// SecretEnv holds KEY=VALUE pairs in memory; the state file only keeps
+	// their names.
+	SecretEnv []string
+	// LastHost is the host the volume was last mounted from, tried first
+	// on the next mount.
+	LastHost string
+	// RemoteOS is the server's operating system as detected at Create.
+	RemoteOS string
+	// Compression is "yes" or "no" as auto_compression decided from
+	// LatencyMs, the round-trip time it measured to the server.
+	Compression string
+	LatencyMs   float64
+	// Disabled volumes keep their definition but cannot be mounted.
+	Disabled bool
 
 	Options []string
 
 	Mountpoint  string
 	connections int
+	// containers are the IDs of the mount requests holding the volume.
+	containers map[string]struct{}
+	mounting   *mountCall
+	// host is where the current mount connected to.
+	host string
+	// probe asks Create to test the connection first.
+	probe bool
+	// remounts counts the remounts of degraded mounts by the host whose
+	// connection died; lastRemount is when the latest one happened.
+	remounts    map[string]int
+	lastRemount time.Time
+	// lowSpace is the outcome of the latest free-space check.
+	lowSpace bool
+	// degraded is set once a degraded event was emitted for the mount.
+	degraded bool
+	// history holds the latest mount and unmount attempts.
+	history []volumeOp
+}
+
+// addContainer records that the container with the given ID mounted the
+// volume.
+func (v *sshfsVolume) addContainer(id string) {
+	v.connections++
+	if v.containers == nil {
+		v.containers = make(map[string]struct{})
+	}
+	v.containers[id] = struct{}{}
+}
+
+// containerIDs returns the IDs of the containers holding the volume, sorted.
+func (v *sshfsVolume) containerIDs() []string {
+	ids := make([]string, 0, len(v.containers))
+	for id := range v.containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// mountCall is an sshfs mount in progress, shared by every Mount request
+// for the volume that arrives before it completes. cancel stops it from
+// waiting for a host slot, kills a running sshfs and keeps further hosts
+// from being tried, as Remove does.
+type mountCall struct {
+	done   chan struct{}
+	err    error
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newMountCall returns a mount call that has yet to complete.
+func newMountCall() *mountCall {
+	call := &mountCall{done: make(chan struct{})}
+	call.ctx, call.cancel = context.WithCancel(context.Background())
+	return call
 }
 
 type sshfsDriver struct {
 	sync.RWMutex
 
-	root      string
-	statePath string
-	volumes   map[string]*sshfsVolume
+	root           string
+	statePath      string
+	keysDir        string
+	knownHostsPath string
+	config         driverConfig
+	executor       CommandExecutor
+	askpass        string
+	lookPath       func(string) (string, error)
+	interfaceAddrs func() ([]net.Addr, error)
+	unmountTool    string
+	sshfsVersion   string
+	mountNamespace string
+	started        time.Time
+	probes         probeCache
+	hostLimit      hostLimiter
+	events         eventBus
+	mountErrors    errorRates
+	mountsPath     string
+	now            func() time.Time
+	stat           func(string) (os.FileInfo, error)
+	readFile       func(string) ([]byte, error)
+	marshalState   func(interface{}) ([]byte, error)
+	writeFile      func(string, []byte, os.FileMode) error
+	sleep          func(time.Duration)
+	volumes        map[string]*sshfsVolume
+	// creating holds the volumes Create is provisioning without the lock,
+	// so that no other Create takes their name or mountpoint meanwhile.
+	creating map[string]*sshfsVolume
+
+	// draining refuses new mounts while unmounts and removals go on.
+	draining bool
+	// savedState is the state last read from or written to the state file.
+	savedState []byte
+	// stateDegraded is set while the state directory is not writable and
+	// changes are only kept in memory; stateRetrying while a goroutine
+	// retries the write.
+	stateDegraded bool
+	stateRetrying bool
 }
 
 func newSshfsDriver(root string) (*sshfsDriver, error) {
+	return newSshfsDriverWithConfig(root, defaultDriverConfig())
+}
+
+// newDriver returns a driver below root with its default dependencies,
+// without touching the filesystem or loading any state.
+func newDriver(root string, config driverConfig) *sshfsDriver {
+	return &sshfsDriver{
+		root:           filepath.Join(root, "volumes"),
+		statePath:      filepath.Join(root, "state", "sshfs-state.json"),
+		keysDir:        filepath.Join(root, "state", "keys"),
+		knownHostsPath: filepath.Join(root, "state", "known_hosts"),
+		config:         config,
+		executor:       retryExecutor{next: execCommandExecutor{}},
+		askpass:        askpassPath(),
+		lookPath:       exec.LookPath,
+		interfaceAddrs: net.InterfaceAddrs,
+		now:            time.Now,
+		stat:           os.Stat,
+		readFile:       os.ReadFile,
+		marshalState:   json.Marshal,
+		writeFile:      writeFileAtomic,
+		sleep:          time.Sleep,
+		mountsPath:     "/proc/mounts",
+		volumes:        map[string]*sshfsVolume{},
+		creating:       map[string]*sshfsVolume{},
+	}
+}
+
+func newSshfsDriverWithConfig(root string, config driverConfig) (*sshfsDriver, error) {
 	logrus.WithField("method", "new driver").Debug(root)
 
-	d := &sshfsDriver{
-		root:      filepath.Join(root, "volumes"),
-		statePath: filepath.Join(root, "state", "sshfs-state.json"),
-		volumes:   map[string]*sshfsVolume{},
+	d := newDriver(root, config)
+	d.started = d.now()
+
+	if !isWithin(d.propagatedRoot(), d.root) {
+		return nil, fmt.Errorf("volumes root %s is not under the propagated mount %s", d.root, d.propagatedRoot())
 	}
 
-	data, err := os.ReadFile(d.statePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			logrus.WithField("statePath", d.statePath).Debug("no state found")
-		} else {
+	for _, dir := range []string{filepath.Dir(d.statePath), d.root} {
+		if err := ensureWritableDir(dir); err != nil {
 			return nil, err
 		}
-	} else {
-		if err := json.Unmarshal(data, &d.volumes); err != nil {
+	}
+
+	tool, err := selectUnmountTool(config.UnmountTools, d.lookPath)
+	if err != nil {
+		return nil, err
+	}
+	d.unmountTool = tool
+	logrus.WithField("tool", tool).Debug("selected unmount tool")
+
+	if err := d.loadState(); err != nil {
+		return nil, err
+	}
+	d.migrateMountpoints()
+
+	if config.VolumesManifest != "" {
+		if err := d.loadManifest(config.VolumesManifest); err != nil {
 			return nil, err
 		}
 	}
@@ -62,35 +258,547 @@ func newSshfsDriver(root string) (*sshfsDriver, error) {
 	return d, nil
 }
 
-func (d *sshfsDriver) saveState() {
-	data, err := json.Marshal(d.volumes)
+// loadState reads the volumes from the state file. Read errors that are
+// likely transient, as on a busy network filesystem, are retried with
+// backoff; a state file that cannot be parsed is replaced by its backup.
+func (d *sshfsDriver) loadState() error {
+	data, err := d.readState(d.statePath)
+	if os.IsNotExist(err) {
+		logrus.WithField("statePath", d.statePath).Debug("no state found")
+		return nil
+	}
 	if err != nil {
-		logrus.WithField("statePath", d.statePath).Error(err)
-		return
+		return err
+	}
+	err = json.Unmarshal(data, &d.volumes)
+	if err == nil {
+		d.savedState = data
+		return nil
+	}
+
+	d.volumes = map[string]*sshfsVolume{}
+	backup, bakErr := d.readState(d.statePath + ".bak")
+	if bakErr != nil || json.Unmarshal(backup, &d.volumes) != nil {
+		return fmt.Errorf("state file %s is corrupt: %v", d.statePath, err)
+	}
+	logrus.WithField("statePath", d.statePath).Warnf("state file is corrupt, recovered from its backup: %v", err)
+	return nil
+}
+
+// readState reads a state file, retrying transient errors.
+func (d *sshfsDriver) readState(path string) ([]byte, error) {
+	backoff := d.config.StateLoadBackoff
+	for attempt := 0; ; attempt++ {
+		data, err := d.readFile(path)
+		if err == nil || !isTransient(err) || attempt >= d.config.StateLoadRetries {
+			return data, err
+		}
+		logrus.WithField("statePath", path).Warnf("failed to read state, retrying in %v: %v", backoff, err)
+		d.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether a file read may succeed when retried.
+func isTransient(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EAGAIN, syscall.EINTR, syscall.ESTALE, syscall.EIO} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureWritableDir creates dir if it is missing and checks that files can
+// be created in it.
+func ensureWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkStateRoundTrip parses marshaled state and checks that it holds the
+// same volumes, so that an in-memory state corrupted, for example by a data
+// race, never replaces a good state file.
+func checkStateRoundTrip(data []byte, volumes map[string]*sshfsVolume) error {
+	var parsed map[string]*sshfsVolume
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
 	}
+	if len(parsed) != len(volumes) {
+		return fmt.Errorf("%d of %d volumes read back", len(parsed), len(volumes))
+	}
+	for name := range volumes {
+		if parsed[name] == nil {
+			return fmt.Errorf("volume %s does not read back", name)
+		}
+	}
+	return nil
+}
+
+// saveState writes the volumes to the state file, keeping the previous file
+// as a .bak copy. Only the state write itself can fail the call; it is
+// atomic, so a failure leaves the previous state intact, and it is skipped
+// for state that does not read back. A failed backup is logged as a warning
+// and otherwise ignored.
+func (d *sshfsDriver) saveState() error {
+	data, err := d.stateData()
+	if err != nil {
+		return err
+	}
+	if err := d.backupState(); err != nil {
+		logrus.WithField("statePath", d.statePath).Warnf("failed to back up state: %v", err)
+	}
+	if err := d.writeFile(d.statePath, data, 0o644); err != nil {
+		return err
+	}
+	d.savedState = data
+	if d.stateDegraded {
+		d.stateDegraded = false
+		logrus.WithField("statePath", d.statePath).Warn("state directory is writable again, state written")
+	}
+	return nil
+}
+
+// stateData marshals the volumes as the state file stores them.
+func (d *sshfsDriver) stateData() ([]byte, error) {
+	volumes := make(map[string]*sshfsVolume, len(d.volumes))
+	for name, v := range d.volumes {
+		if v.NoPersistPassword || len(v.SecretEnv) > 0 {
+			stored := *v
+			if v.NoPersistPassword {
+				stored.Password = ""
+			}
+			stored.SecretEnv = envNames(v.SecretEnv)
+			v = &stored
+		}
+		volumes[name] = v
+	}
+
+	data, err := d.marshalState(volumes)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStateRoundTrip(data, volumes); err != nil {
+		return nil, fmt.Errorf("refusing to write state that does not read back: %v", err)
+	}
+	return data, nil
+}
+
+// backupState copies the current state file to its .bak path.
+func (d *sshfsDriver) backupState() error {
+	data, err := os.ReadFile(d.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return d.writeFile(d.statePath+".bak", data, 0o644)
+}
 
-	if err := os.WriteFile(d.statePath, data, 0o644); err != nil {
-		logrus.WithField("savestate", d.statePath).Error(err)
+// writeFileAtomic replaces path with data through a synced temporary file
+// in the same directory, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
 	}
+	return nil
 }
 
 func (d *sshfsDriver) Create(r *volume.CreateRequest) error {
 
-	logrus.WithField("method", "create").Debugf("%#v", r)
+	logrus.WithField("method", "create").Debugf("%#v", &volume.CreateRequest{Name: r.Name, Options: redactOptions(r.Options)})
+
+	// Provisioning reaches the server and may take a while, so it runs
+	// without the lock; the name is reserved in the meantime.
+	d.Lock()
+	v, exists, err := d.checkCreate(r.Name, r.Options)
+	if err == nil && !exists {
+		d.creating[r.Name] = v
+	}
+	d.Unlock()
+	if err != nil || exists {
+		return err
+	}
+
+	err = d.provision(r.Name, v)
 
 	d.Lock()
 	defer d.Unlock()
+
+	delete(d.creating, r.Name)
+	if err != nil {
+		return err
+	}
+	if err := d.recheckCreate(r.Name, v); err != nil {
+		d.discardCreate(r.Name, v)
+		return err
+	}
+
+	d.volumes[r.Name] = v
+
+	if err := d.persist(); err != nil {
+		delete(d.volumes, r.Name)
+		return logError("failed to save state: %v", err)
+	}
+	d.emit("create", r.Name, "")
+
+	return nil
+}
+
+// checkCreate parses and validates the options of a volume to be created.
+// It reports whether a volume with the same definition already exists,
+// which Create treats as a no-op. A matching Create for a volume whose
+// password or secret_env values were not persisted supplies them again.
+func (d *sshfsDriver) checkCreate(name string, options map[string]string) (*sshfsVolume, bool, error) {
+	if err := validateVolumeName(name); err != nil {
+		return nil, false, logError("%s", err.Error())
+	}
+	v, err := d.parseVolume(options)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := d.checkFeatures(v); err != nil {
+		return nil, false, logError("%s", err.Error())
+	}
+
+	if pending, ok := d.creating[name]; ok {
+		if !sameDefinition(pending, v) {
+			return nil, false, logError("volume %s: %w", name, ErrVolumeConflict)
+		}
+		return nil, false, logError("volume %s is already being created", name)
+	}
+
+	// Docker may legitimately send Create again for an existing volume.
+	// That is a no-op as long as nothing changed; never overwrite it.
+	if existing, ok := d.volumes[name]; ok {
+		resupplied := *existing
+		restoreSecrets(&resupplied, v)
+		if sameDefinition(&resupplied, v) {
+			logrus.WithField("volume", name).Debug("volume already exists with the same options")
+			existing.Password, existing.SecretEnv = resupplied.Password, resupplied.SecretEnv
+			return nil, true, nil
+		}
+		return nil, false, logError("volume %s: %w", name, ErrVolumeConflict)
+	}
+
+	if err := d.mountpointCollision(name, v); err != nil {
+		return nil, false, logError("%s", err.Error())
+	}
+	return v, false, nil
+}
+
+// recheckCreate checks again, once provision has run without the lock,
+// that no volume took the name or the mountpoint of v meanwhile.
+func (d *sshfsDriver) recheckCreate(name string, v *sshfsVolume) error {
+	if _, ok := d.volumes[name]; ok {
+		return logError("volume %s: %w", name, ErrVolumeConflict)
+	}
+	if err := d.mountpointCollision(name, v); err != nil {
+		return logError("%s", err.Error())
+	}
+	return nil
+}
+
+// discardCreate removes the identity file copied for a volume that was
+// provisioned but not created, unless a volume of that name exists by now
+// and the copy is its own.
+func (d *sshfsDriver) discardCreate(name string, v *sshfsVolume) {
+	if _, ok := d.volumes[name]; ok {
+		return
+	}
+	d.removeVolumeKeys(name, v)
+}
+
+// provision runs the steps of Create that reach the server or the plugin
+// host: the probe, OS detection, copying the identity file and the setup
+// command. It runs without the driver lock and only touches v.
+func (d *sshfsDriver) provision(name string, v *sshfsVolume) error {
+	if v.probe || v.VerifySFTP {
+		if _, err := d.testConnection(v); err != nil {
+			return logErrorWith(logrus.Fields{"volume": name, "op": "probe"}, "probe of volume %s failed: %v", name, err)
+		}
+	}
+
+	if v.DetectOS {
+		if err := d.detectRemoteOS(v); err != nil {
+			return logErrorWith(logrus.Fields{"volume": name, "op": "detect os"}, "detecting the OS of volume %s failed: %v", name, err)
+		}
+		logrus.WithFields(logrus.Fields{"volume": name, "os": v.RemoteOS}).Debug("detected remote OS")
+	}
+
+	if v.AutoCompression {
+		if err := d.decideCompression(name, v); err != nil {
+			return logErrorWith(logrus.Fields{"volume": name, "op": "auto compression"}, "measuring the latency of volume %s failed: %v", name, err)
+		}
+	}
+
+	if v.CopyIdentityFile {
+		if err := d.copyIdentityFile(name, v); err != nil {
+			return logError("%s", err.Error())
+		}
+	}
+
+	if v.SetupCommand != "" {
+		if err := d.runSetupCommand(v); err != nil {
+			d.removeVolumeKeys(name, v)
+			return logErrorWith(logrus.Fields{"volume": name, "op": "setup command"}, "setup_command of volume %s failed: %v", name, err)
+		}
+		v.SetupDone = true
+	}
+	return nil
+}
+
+// parseVolume builds a volume definition from Create options without any
+// side effects, so it can also be compared with an existing volume.
+func (d *sshfsDriver) parseVolume(options map[string]string) (*sshfsVolume, error) {
 	v := &sshfsVolume{}
 
-	for key, val := range r.Options {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var proxyKey, proxy, bindAddress string
+	for _, key := range keys {
+		val := options[key]
 		switch key {
+		case "http_proxy", "socks_proxy":
+			if proxyKey != "" {
+				return nil, logError("options %s and %s cannot be combined", proxyKey, key)
+			}
+			cmd, err := proxyCommand(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			proxyKey, proxy = key, cmd
+		case "bind_address":
+			addr, err := localAddress(key, val, d.interfaceAddrs)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			bindAddress = addr
 		case "sshcmd":
 			v.Sshcmd = val
 		case "password":
 			v.Password = val
+		case "password_file":
+			v.PasswordFile = val
+		case "no_persist_password":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.NoPersistPassword = b
 		case "port":
 			v.Port = val
+		case "ssh_config":
+			v.SSHConfig = val
+		case "copy_identity_file":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.CopyIdentityFile = b
+		case "mountpoint_mode":
+			if _, err := parseFileMode(key, val); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.MountpointMode = val
+		case "mountpoint_uid", "mountpoint_gid":
+			id, err := parseIDOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			if key == "mountpoint_uid" {
+				err = checkChown(id, -1)
+				v.MountpointUID = val
+			} else {
+				err = checkChown(-1, id)
+				v.MountpointGID = val
+			}
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+		case "umask":
+			// It used to pass through to sshfs, so the option policy applies.
+			if err := d.checkOptionPolicy(key); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			if _, err := parseFileMode(key, val); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.Umask = val
+		case "clean_mountpoint":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.CleanMountpoint = b
+		case "keep_mountpoint":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.KeepMountpoint = b
+		case "verify_writable":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.VerifyWritable = b
+		case "verify_sftp":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.VerifySFTP = b
+		case "detect_os":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.DetectOS = b
+		case "auto_compression":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.AutoCompression = b
+		case "mount_label":
+			v.MountLabel = sanitizeMountLabel(val)
+		case "labels":
+			labels, err := parseLabels(val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.Labels = labels
+		case "cache_dir":
+			// sshfs only caches in memory; there is nothing to put on disk.
+			return nil, logError("option cache_dir is not supported: sshfs keeps its cache in memory, tune it with cache_timeout or dir_cache instead")
+		case "fallback_hosts":
+			hosts, err := parseFallbackHosts(val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.FallbackHosts = hosts
+		case "probe":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.probe = b
+		case "min_free_bytes":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || n < 0 {
+				return nil, logError("invalid value %q for option %s, expected a non-negative number of bytes", val, key)
+			}
+			v.MinFreeBytes = n
+		case "min_free_percent":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 || n > 100 {
+				return nil, logError("invalid value %q for option %s, expected a percentage from 0 to 100", val, key)
+			}
+			v.MinFreePercent = n
+		case "setup_command":
+			v.SetupCommand = val
+		case "env", "secret_env":
+			// It reaches programs on the plugin host, such as a ProxyCommand,
+			// so the option policy applies.
+			if err := d.checkOptionPolicy(key); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			env, err := parseEnvOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			if key == "env" {
+				v.Env = env
+			} else {
+				v.SecretEnv = env
+			}
+		case "ssh_command":
+			// It runs on the plugin host, so the option policy still applies.
+			if err := d.checkOptionPolicy(key); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			if err := checkSSHCommand(val); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.SSHCommand = val
+		case "sync":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.Sync = b
+		case "no_cache":
+			b, err := parseBoolOption(key, val)
+			if err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			v.NoCache = b
+		case "entry_timeout", "attr_timeout", "dcache_timeout":
+			if err := parseCacheTimeout(key, val); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			switch key {
+			case "entry_timeout":
+				v.EntryTimeout = val
+			case "attr_timeout":
+				v.AttrTimeout = val
+			default:
+				v.DcacheTimeout = val
+			}
+		case "server_alive_interval", "server_alive_count_max":
+			if err := parseCountOption(key, val); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			if key == "server_alive_interval" {
+				v.ServerAliveInterval = val
+			} else {
+				v.ServerAliveCountMax = val
+			}
 		default:
+			if err := d.checkOptionPolicy(key); err != nil {
+				return nil, logError("%s", err.Error())
+			}
+			if strings.EqualFold(key, "compression") {
+				if err := checkCompressionOption(key, val); err != nil {
+					return nil, logError("%s", err.Error())
+				}
+			}
 			if val != "" {
 				v.Options = append(v.Options, key+"="+val)
 			} else {
@@ -100,48 +808,284 @@ func (d *sshfsDriver) Create(r *volume.CreateRequest) error {
 	}
 
 	if v.Sshcmd == "" {
-		return logError("'sshcmd' option required")
+		return nil, logError("'sshcmd' option required")
 	}
-	v.Mountpoint = filepath.Join(d.root, fmt.Sprintf("%x", md5.Sum([]byte(v.Sshcmd))))
-
-	d.volumes[r.Name] = v
-
-	d.saveState()
+	if err := d.checkRemoteRedirect(v); err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	if proxy != "" {
+		if hasOption(v, "ProxyCommand") || hasOption(v, "ProxyJump") {
+			return nil, logError("option %s cannot be combined with ProxyCommand or ProxyJump", proxyKey)
+		}
+		if _, err := d.lookPath(proxyHelper); err != nil {
+			return nil, logError("option %s requires the OpenBSD netcat (%s), which is not installed", proxyKey, proxyHelper)
+		}
+		v.Options = append(v.Options, "ProxyCommand="+proxy)
+	}
+	if bindAddress != "" {
+		if hasOption(v, "BindAddress") {
+			return nil, logError("option bind_address cannot be combined with BindAddress")
+		}
+		v.Options = append(v.Options, "BindAddress="+bindAddress)
+	}
+	if d.config.DisableSshpass && (v.Password != "" || v.PasswordFile != "") {
+		return nil, logError("password authentication is disabled; use a key or ssh agent")
+	}
+	if err := validateVolume(v); err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	if dest, _ := splitSshcmd(v.Sshcmd); d.config.DefaultUser != "" && !strings.Contains(dest, "@") {
+		v.Sshcmd = d.config.DefaultUser + "@" + v.Sshcmd
+	}
+	if v.Port == "" {
+		v.Port = d.config.DefaultPort
+	}
+	v.Sshcmd = canonicalSshcmd(v.Sshcmd)
+	if err := d.checkRemotePath(v); err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	if err := d.inheritCredentials(v); err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	v.Mountpoint = d.mountpointFor(v)
 
-	return nil
+	return v, nil
 }
 
 func (d *sshfsDriver) Remove(r *volume.RemoveRequest) error {
 	logrus.WithField("method", "remove").Debugf("%#v", r)
 
+	_, err := d.remove(r.Name)
+	return err
+}
+
+// removeResult reports what removing a volume freed on the plugin host:
+// the mountpoint directory, unless it was kept, and an estimate of the
+// bytes of the local files removed with it and with the volume's keys.
+type removeResult struct {
+	Mountpoint     string `json:"mountpoint,omitempty"`
+	ReclaimedBytes int64  `json:"reclaimed_bytes"`
+}
+
+// remove removes the named volume as Remove does and reports what it freed.
+// A mount of the volume still in progress is cancelled and waited for
+// first.
+func (d *sshfsDriver) remove(name string) (*removeResult, error) {
 	d.Lock()
 	defer d.Unlock()
 
-	v, ok := d.volumes[r.Name]
+	v, ok := d.volumes[name]
+	for ok && v.mounting != nil {
+		call := v.mounting
+		logrus.WithField("volume", name).Info("cancelling mount in progress for removal")
+		call.cancel()
+		d.Unlock()
+		<-call.done
+		d.Lock()
+		v, ok = d.volumes[name]
+	}
 	if !ok {
-		return logError("volume %s not found", r.Name)
+		return nil, logError("volume %s: %w", name, ErrVolumeNotFound)
 	}
 
 	if v.connections != 0 {
-		return logError("volume %s is currently used by a container", r.Name)
+		return nil, logError("volume %s is currently used by a container", name)
 	}
-	if err := os.RemoveAll(v.Mountpoint); err != nil {
-		return logError("%s", err.Error())
+	// Volumes of the same remote share a mountpoint; leave it to the last one.
+	keep := d.mountpointShared(name, v.Mountpoint)
+	// Without the mount table a live mount cannot be told apart from an
+	// idle mountpoint, which would be removed along with the remote files.
+	mounted, err := d.isMounted(v.Mountpoint)
+	if err != nil {
+		return nil, logError("failed to read mount table %s: %v", d.mountsPath, err)
+	}
+	if mounted {
+		switch d.config.RemovePolicy {
+		case "unmount-if-unreferenced":
+			if !keep {
+				if err := d.unmountVolume(v.Mountpoint); err != nil {
+					return nil, logError("%s", err.Error())
+				}
+			}
+		case "detach":
+			keep = true
+		default:
+			return nil, logError("volume %s is idle but its mountpoint %s is still mounted", name, v.Mountpoint)
+		}
+	}
+	result := &removeResult{}
+	if keep {
+		logrus.WithField("mountpoint", v.Mountpoint).Debug("mountpoint still referenced, keeping it")
+	} else if v.KeepMountpoint {
+		logrus.WithField("mountpoint", v.Mountpoint).Debug("keeping mountpoint as requested")
+	} else {
+		size := dirSize(v.Mountpoint)
+		if err := os.RemoveAll(v.Mountpoint); err != nil {
+			return nil, logError("%s", err.Error())
+		}
+		result.Mountpoint = v.Mountpoint
+		result.ReclaimedBytes += size
+	}
+	size, err := d.removeVolumeKeys(name, v)
+	if err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	result.ReclaimedBytes += size
+	delete(d.volumes, name)
+	if err := d.persist(); err != nil {
+		d.volumes[name] = v
+		return nil, logError("failed to save state: %v", err)
+	}
+	d.emit("remove", name, "")
+	return result, nil
+}
+
+// dirSize returns the total size of the regular files below dir, skipping
+// what cannot be read; a missing dir has none.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// setDisabled disables or re-enables the named volume. Disabling only stops
+// new mounts; containers that hold the volume keep it until they unmount.
+func (d *sshfsDriver) setDisabled(name string, disabled bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	v, ok := d.volumes[name]
+	if !ok {
+		return logError("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	if v.Disabled == disabled {
+		return nil
+	}
+	v.Disabled = disabled
+	if err := d.persist(); err != nil {
+		v.Disabled = !disabled
+		return logError("failed to save state: %v", err)
+	}
+	return nil
+}
+
+// propagatedRoot returns the directory whose mounts Docker propagates to
+// containers.
+func (d *sshfsDriver) propagatedRoot() string {
+	if d.config.PropagatedRoot != "" {
+		return d.config.PropagatedRoot
+	}
+	return d.root
+}
+
+// remoteIdentity identifies the remote a volume mounts by user, host, port
+// and path. Volumes with the same identity share a mountpoint. The port is
+// only added when set.
+func remoteIdentity(v *sshfsVolume) string {
+	if v.Port == "" {
+		return v.Sshcmd
+	}
+	return v.Sshcmd + " -p " + v.Port
+}
+
+// mountpointFor derives the volume's mountpoint from its remote identity
+// and the node ID, so that it is the same for every volume of that remote
+// on this node but differs between nodes sharing a state directory.
+func (d *sshfsDriver) mountpointFor(v *sshfsVolume) string {
+	identity := remoteIdentity(v)
+	if d.config.NodeID != "" {
+		identity = d.config.NodeID + " " + identity
+	}
+	return filepath.Join(d.root, fmt.Sprintf("%x", md5.Sum([]byte(identity))))
+}
+
+// migrateMountpoints derives the mountpoints of the loaded volumes again:
+// the state may have been written with another node ID, by an older version
+// that did not mix one in, or by another node sharing the state directory.
+// A volume still mounted at its old mountpoint keeps it, so that the mount
+// is used rather than shadowed, until the driver next starts with it
+// unmounted; an empty directory left at an old mountpoint is removed.
+func (d *sshfsDriver) migrateMountpoints() {
+	for name, v := range d.volumes {
+		old := v.Mountpoint
+		v.Mountpoint = d.mountpointFor(v)
+		if old == v.Mountpoint || filepath.Dir(old) != d.root {
+			continue
+		}
+		mounted, err := d.isMounted(old)
+		if err != nil {
+			logrus.WithField("mountsPath", d.mountsPath).Warnf("failed to read mount table: %v", err)
+		}
+		if err != nil || mounted {
+			logrus.WithFields(logrus.Fields{"volume": name, "mountpoint": old}).Warn("keeping the old mountpoint of a mounted volume")
+			v.Mountpoint = old
+			continue
+		}
+		os.Remove(old)
+	}
+}
+
+// mountpointCollision reports another volume that uses the same mountpoint
+// as v for a different remote, which mounting both would mix up. Since
+// mountpoints are derived again at startup, including the port and node
+// ID, it is a safeguard rather than an expected case. Volumes being created
+// count as well.
+func (d *sshfsDriver) mountpointCollision(name string, v *sshfsVolume) error {
+	for _, volumes := range []map[string]*sshfsVolume{d.volumes, d.creating} {
+		for n, other := range volumes {
+			if n != name && other.Mountpoint == v.Mountpoint && remoteIdentity(other) != remoteIdentity(v) {
+				return fmt.Errorf("mountpoint %s of volume %s is already used by volume %s for %s; re-create one of them", v.Mountpoint, name, n, remoteIdentity(other))
+			}
+		}
 	}
-	delete(d.volumes, r.Name)
-	d.saveState()
 	return nil
 }
 
+// mountpointHeld reports whether a volume other than name that uses
+// mountpoint is held by a container or being mounted, so the mount there
+// must stay.
+func (d *sshfsDriver) mountpointHeld(name, mountpoint string) bool {
+	for n, v := range d.volumes {
+		if n != name && v.Mountpoint == mountpoint && (v.connections > 0 || v.mounting != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// mountpointShared reports whether a volume other than name uses mountpoint.
+func (d *sshfsDriver) mountpointShared(name, mountpoint string) bool {
+	for n, v := range d.volumes {
+		if n != name && v.Mountpoint == mountpoint {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *sshfsDriver) Path(r *volume.PathRequest) (*volume.PathResponse, error) {
 	logrus.WithField("method", "path").Debugf("%#v", r)
 
+	degraded := d.checkMount(r.Name)
+
 	d.RLock()
 	defer d.RUnlock()
 
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return &volume.PathResponse{}, logError("volume %s not found", r.Name)
+		return &volume.PathResponse{}, logError("volume %s: %w", r.Name, ErrVolumeNotFound)
+	}
+
+	if degraded {
+		return &volume.PathResponse{}, logError("mount of volume %s is degraded: transport endpoint is not connected", r.Name)
 	}
 
 	return &volume.PathResponse{Mountpoint: v.Mountpoint}, nil
@@ -151,35 +1095,175 @@ func (d *sshfsDriver) Mount(r *volume.MountRequest) (*volume.MountResponse, erro
 	logrus.WithField("method", "mount").Debugf("%#v", r)
 
 	d.Lock()
-	defer d.Unlock()
+	for {
+		v, ok := d.volumes[r.Name]
+		if !ok {
+			d.Unlock()
+			return &volume.MountResponse{}, logError("volume %s: %w", r.Name, ErrVolumeNotFound)
+		}
 
-	v, ok := d.volumes[r.Name]
-	if !ok {
-		return &volume.MountResponse{}, logError("volume %s not found", r.Name)
-	}
+		if _, held := v.containers[r.ID]; d.draining && !held {
+			err := logError("driver is draining, new mounts of volume %s are refused", r.Name)
+			v.record("mount", r.ID, err)
+			d.Unlock()
+			return &volume.MountResponse{}, err
+		}
+		if v.Disabled {
+			err := logError("volume %s is disabled", r.Name)
+			v.record("mount", r.ID, err)
+			d.Unlock()
+			return &volume.MountResponse{}, err
+		}
+		if err := d.mountpointCollision(r.Name, v); err != nil {
+			v.record("mount", r.ID, err)
+			d.Unlock()
+			return &volume.MountResponse{}, logError("%s", err.Error())
+		}
 
-	if v.connections == 0 {
-		fi, err := os.Lstat(v.Mountpoint)
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(v.Mountpoint, 0o755); err != nil {
-				return &volume.MountResponse{}, logError("%s", err.Error())
+		if v.connections > 0 {
+			// Docker replays a Mount it already got an answer for, e.g.
+			// after a daemon restart; the container holds the volume once.
+			if _, ok := v.containers[r.ID]; ok {
+				d.Unlock()
+				return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
 			}
-		} else if err != nil {
-			return &volume.MountResponse{}, logError("%s", err.Error())
+			v.addContainer(r.ID)
+			v.record("mount", r.ID, nil)
+			d.emit("mount", r.Name, r.ID)
+			d.Unlock()
+			return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
 		}
 
-		if fi != nil && !fi.IsDir() {
-			return &volume.MountResponse{}, logError("%v already exist and it's not a directory", v.Mountpoint)
+		// Another Mount of this volume is running sshfs: wait for it and
+		// share its outcome rather than mounting a second time.
+		if call := v.mounting; call != nil {
+			d.Unlock()
+			<-call.done
+			if call.err != nil {
+				d.Lock()
+				v.record("mount", r.ID, call.err)
+				d.Unlock()
+				return &volume.MountResponse{}, call.err
+			}
+			d.Lock()
+			continue
 		}
 
-		if err := d.mountVolume(v); err != nil {
-			return &volume.MountResponse{}, logError("%s", err.Error())
+		call := newMountCall()
+		v.mounting = call
+		d.Unlock()
+
+		var host string
+		host, call.err = d.prepareAndMount(call.ctx, r.Name, v)
+		call.cancel()
+
+		d.Lock()
+		v.mounting = nil
+		v.record("mount", r.ID, call.err)
+		if call.err == nil {
+			v.addContainer(r.ID)
+			d.recordHost(v, host)
+			d.emit("mount", r.Name, r.ID)
 		}
+		d.Unlock()
+		close(call.done)
+
+		if call.err != nil {
+			return &volume.MountResponse{}, call.err
+		}
+		return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
 	}
+}
 
-	v.connections++
+// prepareAndMount creates the volume's mountpoint if needed and runs sshfs.
+// It is called without the driver lock held; v.mounting keeps other Mount
+// and Remove calls for the volume away in the meantime. Once ctx is done it
+// stops and unmounts whatever an sshfs already running mounted.
+func (d *sshfsDriver) prepareAndMount(ctx context.Context, name string, v *sshfsVolume) (string, error) {
+	// sshfs would succeed, but containers only see mounts below the
+	// propagated mount.
+	if !isWithin(d.propagatedRoot(), v.Mountpoint) {
+		return "", logError("mountpoint %s is not under the propagated mount %s", v.Mountpoint, d.propagatedRoot())
+	}
+	if err := checkMountpointPath(d.root, v.Mountpoint); err != nil {
+		return "", logError("%s", err.Error())
+	}
+
+	fi, err := os.Lstat(v.Mountpoint)
+	if os.IsNotExist(err) {
+		mode := d.config.MountpointMode
+		if v.MountpointMode != "" {
+			if mode, err = parseFileMode("mountpoint_mode", v.MountpointMode); err != nil {
+				return "", logError("%s", err.Error())
+			}
+		}
+		if err := os.MkdirAll(v.Mountpoint, mode); err != nil {
+			return "", logError("%s", err.Error())
+		}
+		// MkdirAll is subject to the umask.
+		if err := os.Chmod(v.Mountpoint, mode); err != nil {
+			return "", logError("%s", err.Error())
+		}
+		if v.MountpointUID != "" || v.MountpointGID != "" {
+			uid, gid := mountpointOwner(v)
+			if err := os.Chown(v.Mountpoint, uid, gid); err != nil {
+				return "", logError("failed to set the owner of mountpoint %s: %v", v.Mountpoint, err)
+			}
+		}
+	} else if err != nil {
+		return "", logError("%s", err.Error())
+	}
+
+	if fi != nil && !fi.IsDir() {
+		return "", logError("%v already exist and it's not a directory", v.Mountpoint)
+	}
+
+	// Without the mount table a live mount cannot be told apart from an
+	// empty directory, which prepareMountpoint may clean out.
+	mounted, err := d.isMounted(v.Mountpoint)
+	if err != nil {
+		return "", logError("failed to read mount table %s: %v", d.mountsPath, err)
+	}
+	if mounted {
+		logrus.WithField("mountpoint", v.Mountpoint).Debug("already mounted")
+		return hostLabel(v), nil
+	}
+	if err := prepareMountpoint(v); err != nil {
+		return "", logError("%s", err.Error())
+	}
 
-	return &volume.MountResponse{Mountpoint: v.Mountpoint}, nil
+	host, err := d.mountVolume(ctx, name, v)
+	if ctx.Err() != nil {
+		d.unmountCancelled(v.Mountpoint)
+		return "", logError("mount of volume %s was cancelled", name)
+	}
+	if err != nil {
+		return "", logError("%w", err)
+	}
+	if v.VerifyWritable && !hasOption(v, "ro") {
+		if err := checkWritable(v.Mountpoint); err != nil {
+			if uerr := d.unmountVolume(v.Mountpoint); uerr != nil {
+				logrus.WithField("mountpoint", v.Mountpoint).Warn(uerr)
+			}
+			return "", logError("volume %s is not writable: %v; create it with -o ro if it is meant to be read-only", name, err)
+		}
+	}
+	return host, nil
+}
+
+// unmountCancelled unmounts the mountpoint of a cancelled mount if an sshfs
+// that was already running mounted it after all.
+func (d *sshfsDriver) unmountCancelled(mountpoint string) {
+	mounted, err := d.isMounted(mountpoint)
+	if err != nil {
+		logrus.WithField("mountsPath", d.mountsPath).Warnf("failed to read mount table: %v", err)
+	}
+	if !mounted {
+		return
+	}
+	if err := d.unmountVolume(mountpoint); err != nil {
+		logrus.WithField("mountpoint", mountpoint).Warnf("failed to unmount cancelled mount: %v", err)
+	}
 }
 
 func (d *sshfsDriver) Unmount(r *volume.UnmountRequest) error {
@@ -189,17 +1273,34 @@ func (d *sshfsDriver) Unmount(r *volume.UnmountRequest) error {
 	defer d.Unlock()
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return logError("volume %s not found", r.Name)
+		if d.config.UnknownUnmountPolicy == "ignore" {
+			// Failing would block the container's teardown over a volume
+			// that is gone anyway.
+			logrus.WithFields(logrus.Fields{"volume": r.Name, "container": r.ID}).Warn("ignoring unmount of an unknown volume")
+			return nil
+		}
+		return logError("volume %s: %w", r.Name, ErrVolumeNotFound)
 	}
 
 	v.connections--
+	delete(v.containers, r.ID)
 
 	if v.connections <= 0 {
-		if err := d.unmountVolume(v.Mountpoint); err != nil {
+		// Volumes of the same remote share a mountpoint, and with it the
+		// mount; leave it to the last one released.
+		if d.mountpointHeld(r.Name, v.Mountpoint) {
+			logrus.WithField("mountpoint", v.Mountpoint).Debug("mountpoint still held by another volume, keeping the mount")
+		} else if err := d.unmountVolume(v.Mountpoint); err != nil {
+			v.record("unmount", r.ID, err)
 			return logError("%s", err.Error())
 		}
 		v.connections = 0
+		v.containers = nil
+		v.degraded = false
+		v.lowSpace = false
 	}
+	v.record("unmount", r.ID, nil)
+	d.emit("unmount", r.Name, r.ID)
 
 	return nil
 }
@@ -207,79 +1308,338 @@ func (d *sshfsDriver) Unmount(r *volume.UnmountRequest) error {
 func (d *sshfsDriver) Get(r *volume.GetRequest) (*volume.GetResponse, error) {
 	logrus.WithField("method", "get").Debugf("%#v", r)
 
-	d.Lock()
-	defer d.Unlock()
+	degraded := d.checkMount(r.Name)
+	lowSpace := !degraded && d.checkSpace(r.Name)
+
+	d.RLock()
+	defer d.RUnlock()
 
 	v, ok := d.volumes[r.Name]
 	if !ok {
-		return &volume.GetResponse{}, logError("volume %s not found", r.Name)
+		return &volume.GetResponse{}, logError("volume %s: %w", r.Name, ErrVolumeNotFound)
+	}
+
+	status := map[string]interface{}{"connections": v.connections}
+	if degraded {
+		status["degraded"] = true
+	}
+	if v.Sync {
+		status["sync"] = true
+	}
+	if v.NoCache {
+		status["no_cache"] = true
+	}
+	for _, option := range cacheTimeouts(v) {
+		key, val, _ := strings.Cut(option, "=")
+		status[key] = val
+	}
+	if v.connections > 0 && v.host != "" {
+		status["host"] = v.host
+	}
+	if len(v.containers) > 0 {
+		status["containers"] = v.containerIDs()
+	}
+	if v.Disabled {
+		status["disabled"] = true
+	}
+	if len(v.Labels) > 0 {
+		status["labels"] = v.Labels
+	}
+	if v.Umask != "" {
+		status["umask"] = v.Umask
+	}
+	if v.RemoteOS != "" {
+		status["remote_os"] = v.RemoteOS
+	}
+	if v.AutoCompression && v.Compression != "" {
+		status["compression"] = v.Compression
+		status["latency_ms"] = v.LatencyMs
+	}
+	for _, key := range []string{"allow_other", "allow_root"} {
+		if hasOption(v, key) {
+			status[key] = true
+		}
+	}
+	if v.MinFreeBytes > 0 || v.MinFreePercent > 0 {
+		status["low_space"] = lowSpace
+	}
+	if n := v.remountCount(); n > 0 {
+		status["remounts"] = n
+		status["last_remount"] = v.lastRemount.UTC().Format(time.RFC3339)
 	}
 
-	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.Mountpoint}}, nil
+	return &volume.GetResponse{Volume: &volume.Volume{Name: r.Name, Mountpoint: v.Mountpoint, Status: status}}, nil
 }
 
 func (d *sshfsDriver) List() (*volume.ListResponse, error) {
 	logrus.WithField("method", "list").Debugf("")
 
-	d.Lock()
-	defer d.Unlock()
+	d.RLock()
+	defer d.RUnlock()
 
-	var vols []*volume.Volume
+	vols := make([]*volume.Volume, 0, len(d.volumes))
 	for name, v := range d.volumes {
-		vols = append(vols, &volume.Volume{Name: name, Mountpoint: v.Mountpoint})
+		vol := &volume.Volume{Name: name, Mountpoint: v.Mountpoint}
+		if v.Disabled || len(v.Labels) > 0 {
+			vol.Status = map[string]interface{}{}
+		}
+		if v.Disabled {
+			vol.Status["disabled"] = true
+		}
+		if len(v.Labels) > 0 {
+			vol.Status["labels"] = v.Labels
+		}
+		vols = append(vols, vol)
 	}
+	sort.Slice(vols, func(i, j int) bool { return vols[i].Name < vols[j].Name })
 	return &volume.ListResponse{Volumes: vols}, nil
 }
 
 func (d *sshfsDriver) Capabilities() *volume.CapabilitiesResponse {
 	logrus.WithField("method", "capabilities").Debugf("")
 
-	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: "local"}}
+	return &volume.CapabilitiesResponse{Capabilities: volume.Capability{Scope: d.config.Scope}}
 }
 
-func (d *sshfsDriver) mountVolume(v *sshfsVolume) error {
-	cmd := exec.Command("sshfs", "-oStrictHostKeyChecking=no", v.Sshcmd, v.Mountpoint)
-	if v.Port != "" {
-		cmd.Args = append(cmd.Args, "-p", v.Port)
-	}
-	if v.Password != "" {
-		cmd.Args = append(cmd.Args, "-o", "workaround=rename", "-o", "password_stdin")
-		cmd.Stdin = strings.NewReader(v.Password)
+// mountVolume mounts the volume from its primary host or, failing that,
+// from the first of its fallback hosts that works, and returns the host
+// that was used. Each attempt takes a slot of its host under
+// MAX_HOST_MOUNTS, and a successful one holds it until the mountpoint is
+// unmounted. Once ctx is done, the running sshfs is killed and no further
+// host is tried.
+func (d *sshfsDriver) mountVolume(ctx context.Context, name string, v *sshfsVolume) (string, error) {
+	var err error
+	for _, target := range mountTargets(v) {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		var release func()
+		release, err = d.hostLimit.acquire(ctx, hostOf(target.Sshcmd), d.config.MaxHostMounts, d.config.HostLimitPolicy != "fail")
+		if err != nil {
+			continue
+		}
+		if err = d.mountHost(ctx, name, target); err != nil {
+			release()
+			continue
+		}
+		if ctx.Err() != nil {
+			release()
+			return "", ctx.Err()
+		}
+		d.hostLimit.hold(v.Mountpoint, release)
+		return hostLabel(target), nil
 	}
+	return "", err
+}
 
-	for _, option := range v.Options {
-		cmd.Args = append(cmd.Args, "-o", option)
+// recordHost notes the host a mount connected to, persisting it when it
+// changed. The caller must hold the driver lock.
+func (d *sshfsDriver) recordHost(v *sshfsVolume, host string) {
+	v.host = host
+	if host == "" || host == v.LastHost {
+		return
+	}
+	v.LastHost = host
+	if err := d.persist(); err != nil {
+		logrus.WithField("statePath", d.statePath).Warnf("failed to save last host: %v", err)
 	}
+}
 
+// mountHost mounts the volume from the host in its sshcmd. sshfs is killed
+// once ctx is done.
+func (d *sshfsDriver) mountHost(ctx context.Context, name string, v *sshfsVolume) error {
+	cmd, err := d.sshfsCommand(name, v)
+	if err != nil {
+		return err
+	}
 	logrus.Debug(cmd.Args)
-	output, err := cmd.CombinedOutput()
+	output, err := runContext(ctx, d.executor, cmd)
+	d.mountErrors.observe(hostLabel(v), d.now(), d.config.MountErrorWindow, err != nil)
 	if err != nil {
+		d.probes.invalidate(probeKey(v))
+		if typed := classifyMountError(output); typed != nil {
+			return logError("sshfs command execute failed: %w", typed)
+		}
 		return logError("sshfs command execute failed: %v (%s)", err, output)
 	}
 	return nil
 }
 
-func (d *sshfsDriver) unmountVolume(target string) error {
-	cmd := fmt.Sprintf("umount %s", target)
-	logrus.Debug(cmd)
-	return exec.Command("sh", "-c", cmd).Run()
+// sshfsCommand builds the sshfs invocation that mounts the volume from the
+// host in its sshcmd at its mountpoint.
+func (d *sshfsDriver) sshfsCommand(name string, v *sshfsVolume) (*exec.Cmd, error) {
+	cmd := exec.Command("sshfs", d.hostKeyOptions(v)...)
+	cmd.Args = append(cmd.Args, v.Sshcmd, v.Mountpoint)
+	if v.Port != "" {
+		cmd.Args = append(cmd.Args, "-p", v.Port)
+	}
+	if v.SSHConfig != "" {
+		sshConfig, err := d.expandPath(v.SSHConfig)
+		if err != nil {
+			return nil, logError("ssh_config: %v", err)
+		}
+		cmd.Args = append(cmd.Args, "-F", sshConfig)
+	}
+	credentials, err := d.credentialOptions(v)
+	if err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	for _, option := range credentials {
+		cmd.Args = append(cmd.Args, "-o", option)
+	}
+	password, err := d.volumePassword(v)
+	if err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	if password != "" {
+		cmd.Args = append(cmd.Args, "-o", "workaround=rename", "-o", "PreferredAuthentications=keyboard-interactive,password")
+		cmd.Env = d.askpassEnv(password)
+	}
+	if err := applyVolumeEnv(cmd, v); err != nil {
+		return nil, logError("%s", err.Error())
+	}
+
+	if v.Sync {
+		cmd.Args = append(cmd.Args, "-o", "sshfs_sync")
+	}
+	if v.NoCache {
+		for _, option := range noCacheOptions {
+			cmd.Args = append(cmd.Args, "-o", option)
+		}
+	}
+	for _, option := range cacheTimeouts(v) {
+		cmd.Args = append(cmd.Args, "-o", option)
+	}
+	if v.SSHCommand != "" {
+		cmd.Args = append(cmd.Args, "-o", "ssh_command="+escapeOptionValue(v.SSHCommand))
+	}
+	if v.Umask != "" {
+		cmd.Args = append(cmd.Args, "-o", "umask="+v.Umask)
+	}
+	label := v.MountLabel
+	if label == "" {
+		label = sanitizeMountLabel(name)
+	}
+	cmd.Args = append(cmd.Args, "-o", "fsname="+label, "-o", "subtype=sshfs")
+
+	for _, option := range keepaliveOptions(v, true) {
+		option, err := d.expandOption(option)
+		if err != nil {
+			return nil, logError("%s", err.Error())
+		}
+		cmd.Args = append(cmd.Args, "-o", option)
+	}
+	compression := d.config.Compression
+	if v.AutoCompression && v.Compression != "" {
+		compression = v.Compression
+	}
+	if compression != "" && !hasOption(v, "compression") {
+		cmd.Args = append(cmd.Args, "-o", "Compression="+compression)
+	}
+	if v.DetectOS {
+		for _, option := range remoteOSDefaults(v.RemoteOS) {
+			key, _, _ := strings.Cut(option, "=")
+			if !hasOption(v, key) {
+				cmd.Args = append(cmd.Args, "-o", option)
+			}
+		}
+	}
+
+	if err := checkArgv(cmd); err != nil {
+		return nil, logError("sshfs command of volume %s is too long: %v; move ssh options to an ssh_config file", name, err)
+	}
+
+	return d.inMountNamespace(cmd), nil
 }
 
 func logError(format string, args ...interface{}) error {
-	logrus.Errorf(format, args...)
-	return fmt.Errorf(format, args...)
+	return logErrorWith(nil, format, args...)
+}
+
+// logErrorWith is logError with context, such as the volume, the operation
+// or the host, attached to the log entry as fields. The returned error is
+// the same as logError's; the fields only reach the log.
+func logErrorWith(fields logrus.Fields, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	logrus.WithFields(fields).Error(err.Error())
+	return err
 }
 
 func main() {
+	// ssh of a password volume runs this binary as its SHELL, to start a
+	// ProxyCommand or similar, and as its SSH_ASKPASS helper to answer
+	// password and keyboard-interactive prompts.
+	if shell, ok := os.LookupEnv(askpassShellEnv); ok && len(os.Args) > 1 && os.Args[1] == "-c" {
+		argv, env := askpassShell(shell, os.Args[1:], os.Environ())
+		err := syscall.Exec(shell, argv, env)
+		fmt.Fprintf(os.Stderr, "failed to run %s: %v\n", shell, err)
+		os.Exit(127)
+	}
+	if password, ok := os.LookupEnv(askpassPasswordEnv); ok {
+		fmt.Println(password)
+		return
+	}
+
+	checkOnly := flag.Bool("check-config", false, "validate the configuration and exit")
+	flag.Parse()
+
 	debug := os.Getenv("DEBUG")
 	if ok, _ := strconv.ParseBool(debug); ok {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	d, err := newSshfsDriver("/mnt")
+	config, err := driverConfigFromEnv()
+	if *checkOnly {
+		problems := []error{err}
+		if err == nil {
+			problems = checkConfig(config, exec.LookPath)
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.LogFile != "" {
+		logFile, err := newRotatingFile(config.LogFile, config.LogMaxSize, config.LogMaxFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logFile.Close()
+		logrus.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	}
+
+	d, err := newSshfsDriverWithConfig("/mnt", config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := d.detectSshfsVersion(); err != nil {
+		logrus.Warn(err)
+	}
+	if err := d.useMountNamespace(config.MountNamespace); err != nil {
+		logrus.Warn(err)
+	}
+	if config.ReconcileInterval > 0 {
+		go d.reconcileLoop(config.ReconcileInterval)
+	}
+	if config.HealthCheckInterval > 0 {
+		go d.healthLoop(config.HealthCheckInterval)
+	}
+	if config.CheckpointInterval > 0 {
+		go d.checkpointLoop(config.CheckpointInterval)
+	}
+	if config.ControlSocket != "" {
+		go func() {
+			logrus.Error(serveControl(config.ControlSocket, d.controlHandler()))
+		}()
+	}
 	h := volume.NewHandler(d)
 	logrus.Infof("listening on %s", socketAddress)
 	logrus.Error(h.ServeUnix(socketAddress, 0))
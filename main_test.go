@@ -1,12 +1,22 @@
 package main
 
 import (
+	"crypto/md5"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 )
 
 // setupTestDriver creates a temporary directory and initializes a driver for testing
@@ -111,6 +121,71 @@ func TestNewSshfsDriver(t *testing.T) {
 	})
 }
 
+// TestStateDirectories tests creation and checking of the driver's directories
+func TestStateDirectories(t *testing.T) {
+	t.Run("missing directories are created", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "sshfs-test-*")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer cleanupTestDriver(tmpDir)
+
+		root := filepath.Join(tmpDir, "plugin")
+		driver, err := newSshfsDriver(root)
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
+		AssertFileExists(t, filepath.Join(root, "state"))
+		AssertFileExists(t, driver.root)
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		AssertFileExists(t, driver.statePath)
+	})
+
+	t.Run("uncreatable directory", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "sshfs-test-*")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer cleanupTestDriver(tmpDir)
+
+		// A file where the state directory belongs cannot be replaced.
+		if err := os.WriteFile(filepath.Join(tmpDir, "state"), nil, 0o644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+		_, err = newSshfsDriver(tmpDir)
+		if err == nil {
+			t.Fatal("Expected error when the state directory cannot be created")
+		}
+		AssertContains(t, err.Error(), filepath.Join(tmpDir, "state"), "startup error")
+	})
+
+	t.Run("read-only parent", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("permissions are not enforced for root")
+		}
+		tmpDir, err := os.MkdirTemp("", "sshfs-test-*")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer cleanupTestDriver(tmpDir)
+
+		if err := os.Chmod(tmpDir, 0o555); err != nil {
+			t.Fatalf("Failed to make directory read-only: %v", err)
+		}
+		defer os.Chmod(tmpDir, 0o755)
+
+		_, err = newSshfsDriver(tmpDir)
+		if err == nil {
+			t.Fatal("Expected error for a read-only parent")
+		}
+		AssertContains(t, err.Error(), "failed to create directory "+filepath.Join(tmpDir, "state"), "startup error")
+		AssertContains(t, err.Error(), "permission denied", "startup error")
+	})
+}
+
 // TestSaveState tests state persistence
 func TestSaveState(t *testing.T) {
 	driver, tmpDir := setupTestDriver(t)
@@ -126,7 +201,9 @@ func TestSaveState(t *testing.T) {
 	}
 
 	// Save state
-	driver.saveState()
+	if err := driver.saveState(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
 
 	// Read the state file
 	data, err := os.ReadFile(driver.statePath)
@@ -157,6 +234,44 @@ func TestSaveState(t *testing.T) {
 	}
 }
 
+// TestSaveStateRoundTrip tests that state which does not read back is never written
+func TestSaveStateRoundTrip(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path"}
+	if err := driver.saveState(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	good, err := os.ReadFile(driver.statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+
+	for name, marshal := range map[string]func(interface{}) ([]byte, error){
+		"invalid json":    func(interface{}) ([]byte, error) { return []byte(`{"test-volume":{"Sshcmd":`), nil },
+		"missing volumes": func(interface{}) ([]byte, error) { return []byte(`{"test-volume":null}`), nil },
+	} {
+		t.Run(name, func(t *testing.T) {
+			driver.marshalState = marshal
+			driver.volumes["other-volume"] = &sshfsVolume{Sshcmd: "user@host:/other"}
+			defer delete(driver.volumes, "other-volume")
+
+			err := driver.saveState()
+			if err == nil {
+				t.Fatal("Expected saveState to fail")
+			}
+			AssertContains(t, err.Error(), "refusing to write state", "error")
+
+			data, err := os.ReadFile(driver.statePath)
+			if err != nil {
+				t.Fatalf("Failed to read state file: %v", err)
+			}
+			AssertEqual(t, string(good), string(data), "state file")
+		})
+	}
+}
+
 // TestCreate tests volume creation
 func TestCreate(t *testing.T) {
 	t.Run("create volume with sshcmd", func(t *testing.T) {
@@ -199,6 +314,19 @@ func TestCreate(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid names are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, name := range []string{"", "..", "../x", "a", "-volume", "test volume", "a/b"} {
+			err := driver.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"sshcmd": "user@host:/path"}})
+			if err == nil {
+				t.Errorf("Expected volume name %q to be rejected", name)
+			}
+		}
+		AssertEqual(t, 0, len(driver.volumes), "volumes")
+	})
+
 	t.Run("create volume with options", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
@@ -261,6 +389,95 @@ func TestCreate(t *testing.T) {
 	})
 }
 
+// TestCreateExisting tests Create for a name that already exists
+func TestCreateExisting(t *testing.T) {
+	options := map[string]string{
+		"sshcmd":      "user@host:/path",
+		"password":    "secret",
+		"allow_other": "",
+		"compression": "yes",
+	}
+
+	t.Run("identical re-create is a no-op", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		original := driver.volumes["test-volume"]
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Expected identical re-create to succeed, got %v", err)
+		}
+
+		if driver.volumes["test-volume"] != original {
+			t.Error("Expected the existing volume to be kept")
+		}
+	})
+
+	t.Run("conflicting re-create fails without changes", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		before, err := os.ReadFile(driver.statePath)
+		if err != nil {
+			t.Fatalf("Failed to read state file: %v", err)
+		}
+
+		err = driver.Create(&volume.CreateRequest{
+			Name: "test-volume",
+			Options: map[string]string{
+				"sshcmd":   "user@host:/path",
+				"password": "other",
+			},
+		})
+		if !errors.Is(err, ErrVolumeConflict) {
+			t.Fatalf("Expected ErrVolumeConflict, got %v", err)
+		}
+
+		if driver.volumes["test-volume"].Password != "secret" {
+			t.Errorf("Expected password to be unchanged, got %s", driver.volumes["test-volume"].Password)
+		}
+
+		after, err := os.ReadFile(driver.statePath)
+		if err != nil {
+			t.Fatalf("Failed to read state file: %v", err)
+		}
+		if string(before) != string(after) {
+			t.Error("Expected saved state to be unchanged")
+		}
+	})
+
+	t.Run("re-create with copied identity file is a no-op", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		keyPath := filepath.Join(tmpDir, "id_rsa")
+		if err := os.WriteFile(keyPath, []byte("private key"), 0o600); err != nil {
+			t.Fatalf("Failed to write key: %v", err)
+		}
+		req := &volume.CreateRequest{
+			Name: "test-volume",
+			Options: map[string]string{
+				"sshcmd":             "user@host:/path",
+				"IdentityFile":       keyPath,
+				"copy_identity_file": "",
+			},
+		}
+
+		if err := driver.Create(req); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if err := driver.Create(req); err != nil {
+			t.Fatalf("Expected identical re-create to succeed, got %v", err)
+		}
+	})
+}
+
 // TestRemove tests volume removal
 func TestRemove(t *testing.T) {
 	t.Run("remove existing volume", func(t *testing.T) {
@@ -300,6 +517,38 @@ func TestRemove(t *testing.T) {
 		}
 	})
 
+	t.Run("key directories outside the volume are left alone", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := os.MkdirAll(driver.keysDir, 0o700); err != nil {
+			t.Fatalf("Failed to create keys dir: %v", err)
+		}
+		for _, name := range []string{"..", "../../volumes"} {
+			driver.volumes[name] = &sshfsVolume{
+				Sshcmd:         "user@host:/path",
+				Mountpoint:     filepath.Join(tmpDir, "volumes", "test"),
+				KeepMountpoint: true,
+			}
+			if err := driver.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+				t.Fatalf("Failed to remove volume %s: %v", name, err)
+			}
+			AssertFileExists(t, driver.keysDir)
+			AssertFileExists(t, filepath.Join(tmpDir, "volumes"))
+		}
+
+		driver.volumes[".."] = &sshfsVolume{
+			Sshcmd:           "user@host:/path",
+			Mountpoint:       filepath.Join(tmpDir, "volumes", "test"),
+			KeepMountpoint:   true,
+			CopyIdentityFile: true,
+		}
+		if err := driver.Remove(&volume.RemoveRequest{Name: ".."}); err == nil {
+			t.Fatal("Expected an error for a key directory outside the keys dir")
+		}
+		AssertFileExists(t, driver.keysDir)
+	})
+
 	t.Run("remove non-existent volume fails", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
@@ -337,216 +586,2105 @@ func TestRemove(t *testing.T) {
 			t.Error("Expected volume to still exist")
 		}
 	})
-}
 
-// TestPath tests getting volume path
-func TestPath(t *testing.T) {
-	t.Run("get path for existing volume", func(t *testing.T) {
+	t.Run("shared mountpoint is kept until the last volume", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
 
-		mountpoint := filepath.Join(tmpDir, "volumes", "test")
-		driver.volumes["test-volume"] = &sshfsVolume{
-			Sshcmd:     "user@host:/path",
-			Mountpoint: mountpoint,
+		for _, name := range []string{"first", "second"} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    name,
+				Options: map[string]string{"sshcmd": "user@host:/path"},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create volume %s: %v", name, err)
+			}
 		}
-
-		req := &volume.PathRequest{
-			Name: "test-volume",
+		mountpoint := driver.volumes["first"].Mountpoint
+		if driver.volumes["second"].Mountpoint != mountpoint {
+			t.Fatalf("Expected volumes to share mountpoint %s", mountpoint)
+		}
+		if err := os.MkdirAll(mountpoint, 0o755); err != nil {
+			t.Fatalf("Failed to create mountpoint: %v", err)
 		}
 
-		resp, err := driver.Path(req)
-		if err != nil {
-			t.Fatalf("Failed to get path: %v", err)
+		if err := driver.Remove(&volume.RemoveRequest{Name: "first"}); err != nil {
+			t.Fatalf("Failed to remove first volume: %v", err)
+		}
+		if _, ok := driver.volumes["first"]; ok {
+			t.Error("Expected first volume to be removed")
+		}
+		if _, err := os.Stat(mountpoint); err != nil {
+			t.Errorf("Expected shared mountpoint to remain, got %v", err)
 		}
 
-		if resp.Mountpoint != mountpoint {
-			t.Errorf("Expected mountpoint to be %s, got %s", mountpoint, resp.Mountpoint)
+		if err := driver.Remove(&volume.RemoveRequest{Name: "second"}); err != nil {
+			t.Fatalf("Failed to remove second volume: %v", err)
+		}
+		if _, err := os.Stat(mountpoint); !os.IsNotExist(err) {
+			t.Error("Expected mountpoint to be removed with the last volume")
 		}
 	})
 
-	t.Run("get path for non-existent volume fails", func(t *testing.T) {
+	t.Run("reports what was reclaimed", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
 
-		req := &volume.PathRequest{
-			Name: "non-existent",
+		key := filepath.Join(tmpDir, "id_test")
+		if err := os.WriteFile(key, make([]byte, 400), 0o600); err != nil {
+			t.Fatalf("Failed to write key: %v", err)
+		}
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "IdentityFile": key, "copy_identity_file": "true"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		// Files written to the mountpoint while nothing was mounted on it.
+		mountpoint := driver.volumes["test-volume"].Mountpoint
+		if err := os.MkdirAll(filepath.Join(mountpoint, "tmp"), 0o755); err != nil {
+			t.Fatalf("Failed to create mountpoint: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(mountpoint, "tmp", "leftover"), make([]byte, 100), 0o644); err != nil {
+			t.Fatalf("Failed to write leftover file: %v", err)
 		}
 
-		_, err := driver.Path(req)
-		if err == nil {
-			t.Fatal("Expected error when getting path for non-existent volume")
+		result, err := driver.remove("test-volume")
+		if err != nil {
+			t.Fatalf("Failed to remove volume: %v", err)
 		}
+		AssertEqual(t, mountpoint, result.Mountpoint, "removed mountpoint")
+		AssertEqual(t, int64(500), result.ReclaimedBytes, "reclaimed bytes")
+		AssertDirNotExists(t, mountpoint)
+		AssertDirNotExists(t, filepath.Join(driver.keysDir, "test-volume"))
 	})
+
+	for _, tt := range []struct {
+		keep     string
+		wantKept bool
+	}{
+		{"false", false},
+		{"true", true},
+	} {
+		t.Run("keep_mountpoint="+tt.keep, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse(nil, nil)
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", "keep_mountpoint": tt.keep},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			mountpoint := driver.volumes["test-volume"].Mountpoint
+			if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+			if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to unmount volume: %v", err)
+			}
+
+			if err := driver.Remove(&volume.RemoveRequest{Name: "test-volume"}); err != nil {
+				t.Fatalf("Failed to remove volume: %v", err)
+			}
+			if _, ok := driver.volumes["test-volume"]; ok {
+				t.Error("Expected volume to be removed")
+			}
+			if tt.wantKept {
+				AssertDirExists(t, mountpoint)
+			} else {
+				AssertDirNotExists(t, mountpoint)
+			}
+		})
+	}
 }
 
-// TestGet tests getting volume info
-func TestGet(t *testing.T) {
-	t.Run("get existing volume", func(t *testing.T) {
+// TestMountpointIdentity tests that only volumes of the same remote share a mountpoint
+func TestMountpointIdentity(t *testing.T) {
+	create := func(t *testing.T, driver *sshfsDriver, name string, options map[string]string) error {
+		t.Helper()
+		return driver.Create(&volume.CreateRequest{Name: name, Options: options})
+	}
+
+	t.Run("different users, ports or paths get distinct mountpoints", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
 
-		mountpoint := filepath.Join(tmpDir, "volumes", "test")
-		driver.volumes["test-volume"] = &sshfsVolume{
-			Sshcmd:     "user@host:/path",
-			Mountpoint: mountpoint,
+		volumes := map[string]map[string]string{
+			"alice":      {"sshcmd": "alice@host:/data"},
+			"bob":        {"sshcmd": "bob@host:/data"},
+			"alice-2222": {"sshcmd": "alice@host:/data", "port": "2222"},
+			"alice-logs": {"sshcmd": "alice@host:/logs"},
 		}
-
-		req := &volume.GetRequest{
-			Name: "test-volume",
+		mountpoints := map[string]string{}
+		for name, options := range volumes {
+			if err := create(t, driver, name, options); err != nil {
+				t.Fatalf("Failed to create volume %s: %v", name, err)
+			}
+			mountpoint := driver.volumes[name].Mountpoint
+			if other, ok := mountpoints[mountpoint]; ok {
+				t.Errorf("Expected volumes %s and %s to have distinct mountpoints", name, other)
+			}
+			mountpoints[mountpoint] = name
 		}
+	})
 
-		resp, err := driver.Get(req)
-		if err != nil {
-			t.Fatalf("Failed to get volume: %v", err)
-		}
+	t.Run("same remote shares the mountpoint", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
 
-		if resp.Volume.Name != "test-volume" {
-			t.Errorf("Expected volume name to be test-volume, got %s", resp.Volume.Name)
+		if err := create(t, driver, "first", map[string]string{"sshcmd": "alice@host:/data", "port": "2222"}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
 		}
-
-		if resp.Volume.Mountpoint != mountpoint {
-			t.Errorf("Expected mountpoint to be %s, got %s", mountpoint, resp.Volume.Mountpoint)
+		if err := create(t, driver, "second", map[string]string{"sshcmd": "alice@host:/data/", "port": "2222", "password": "secret"}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
 		}
+		AssertEqual(t, driver.volumes["first"].Mountpoint, driver.volumes["second"].Mountpoint, "shared mountpoint")
 	})
 
-	t.Run("get non-existent volume fails", func(t *testing.T) {
+	t.Run("residual collision is reported", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
 
-		req := &volume.GetRequest{
-			Name: "non-existent",
+		if err := create(t, driver, "new", map[string]string{"sshcmd": "alice@host:/data"}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
 		}
+		// A volume whose mountpoint was not derived from its remote.
+		driver.volumes["old"] = &sshfsVolume{Sshcmd: "alice@host:/data", Port: "2222", Mountpoint: driver.volumes["new"].Mountpoint}
 
-		_, err := driver.Get(req)
+		_, err := driver.Mount(&volume.MountRequest{Name: "new", ID: "c1"})
 		if err == nil {
-			t.Fatal("Expected error when getting non-existent volume")
+			t.Fatal("Expected mount of a colliding mountpoint to fail")
 		}
+		AssertContains(t, err.Error(), "already used by volume old", "mount error")
+
+		err = create(t, driver, "newer", map[string]string{"sshcmd": "alice@host:/data"})
+		if err == nil {
+			t.Fatal("Expected create of a colliding mountpoint to fail")
+		}
+		AssertContains(t, err.Error(), "already used by volume old", "create error")
 	})
-}
 
-// TestList tests listing volumes
-func TestList(t *testing.T) {
-	t.Run("list empty volumes", func(t *testing.T) {
-		driver, tmpDir := setupTestDriver(t)
-		defer cleanupTestDriver(tmpDir)
+	t.Run("node ID namespaces mountpoints", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		options := map[string]string{"sshcmd": "alice@host:/data", "port": "2222"}
+		mountpoints := map[string]string{}
+		for _, node := range []string{"node-a", "node-b"} {
+			config := defaultDriverConfig()
+			config.NodeID = node
+			driver, err := newSshfsDriverWithConfig(tmpDir, config)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			if err := create(t, driver, "vol-"+node, options); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			mountpoints[node] = driver.volumes["vol-"+node].Mountpoint
 
-		resp, err := driver.List()
-		if err != nil {
-			t.Fatalf("Failed to list volumes: %v", err)
+			// Every volume in the shared state gets this node's mountpoint.
+			for name, v := range driver.volumes {
+				AssertEqual(t, mountpoints[node], v.Mountpoint, "mountpoint of "+name+" on "+node)
+			}
+		}
+		if mountpoints["node-a"] == mountpoints["node-b"] {
+			t.Errorf("Expected nodes to get distinct mountpoints, both got %s", mountpoints["node-a"])
 		}
 
-		if len(resp.Volumes) != 0 {
-			t.Errorf("Expected 0 volumes, got %d", len(resp.Volumes))
+		config := defaultDriverConfig()
+		config.NodeID = "node-a"
+		restarted, err := newSshfsDriverWithConfig(tmpDir, config)
+		if err != nil {
+			t.Fatalf("Failed to restart driver: %v", err)
 		}
+		AssertEqual(t, mountpoints["node-a"], restarted.volumes["vol-node-b"].Mountpoint, "mountpoint after restart")
 	})
 
-	t.Run("list multiple volumes", func(t *testing.T) {
+	t.Run("old mountpoints are migrated", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
 
-		driver.volumes["volume1"] = &sshfsVolume{
-			Sshcmd:     "user@host1:/path1",
-			Mountpoint: filepath.Join(tmpDir, "volumes", "vol1"),
+		// Mountpoints as derived before the node ID was mixed in.
+		old := map[string]string{}
+		for _, name := range []string{"idle", "mounted"} {
+			v := &sshfsVolume{Sshcmd: "alice@host:/" + name}
+			old[name] = filepath.Join(driver.root, fmt.Sprintf("%x", md5.Sum([]byte(remoteIdentity(v)))))
+			if err := os.MkdirAll(old[name], 0o755); err != nil {
+				t.Fatalf("Failed to create mountpoint: %v", err)
+			}
+			v.Mountpoint = old[name]
+			driver.volumes[name] = v
 		}
-
-		driver.volumes["volume2"] = &sshfsVolume{
-			Sshcmd:     "user@host2:/path2",
-			Mountpoint: filepath.Join(tmpDir, "volumes", "vol2"),
+		driver.mountsPath = filepath.Join(tmpDir, "mounts")
+		table := "alice@host:/mounted " + old["mounted"] + " fuse.sshfs rw 0 0\n"
+		if err := os.WriteFile(driver.mountsPath, []byte(table), 0o644); err != nil {
+			t.Fatalf("Failed to write mount table: %v", err)
 		}
 
-		resp, err := driver.List()
-		if err != nil {
-			t.Fatalf("Failed to list volumes: %v", err)
-		}
+		driver.migrateMountpoints()
+		AssertEqual(t, driver.mountpointFor(driver.volumes["idle"]), driver.volumes["idle"].Mountpoint, "mountpoint of an idle volume")
+		AssertDirNotExists(t, old["idle"])
+		AssertEqual(t, old["mounted"], driver.volumes["mounted"].Mountpoint, "mountpoint of a mounted volume")
+		AssertDirExists(t, old["mounted"])
+	})
+}
 
-		if len(resp.Volumes) != 2 {
-			t.Errorf("Expected 2 volumes, got %d", len(resp.Volumes))
-		}
+// TestRemovePolicy tests Remove of an idle volume whose mountpoint is still mounted
+func TestRemovePolicy(t *testing.T) {
+	setup := func(t *testing.T, policy string, shared bool) (*sshfsDriver, string, *TestCommandExecutor, string) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		driver.config.RemovePolicy = policy
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
 
-		// Check if both volumes are in the list
-		volumeNames := make(map[string]bool)
-		for _, vol := range resp.Volumes {
-			volumeNames[vol.Name] = true
+		names := []string{"first"}
+		if shared {
+			names = append(names, "second")
 		}
-
-		if !volumeNames["volume1"] {
-			t.Error("Expected volume1 in list")
+		for _, name := range names {
+			if err := driver.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+				t.Fatalf("Failed to create volume %s: %v", name, err)
+			}
 		}
-
-		if !volumeNames["volume2"] {
-			t.Error("Expected volume2 in list")
+		mountpoint := driver.volumes["first"].Mountpoint
+		if shared {
+			driver.volumes["second"].connections = 1
 		}
-	})
-}
-
+		if err := os.MkdirAll(mountpoint, 0o755); err != nil {
+			t.Fatalf("Failed to create mountpoint: %v", err)
+		}
+		driver.mountsPath = filepath.Join(tmpDir, "mounts")
+		table := "user@host:/path " + mountpoint + " fuse.sshfs rw 0 0\n"
+		if err := os.WriteFile(driver.mountsPath, []byte(table), 0o644); err != nil {
+			t.Fatalf("Failed to write mount table: %v", err)
+		}
+		return driver, tmpDir, executor, mountpoint
+	}
+
+	t.Run("strict refuses a shared mounted mountpoint", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, "strict", true)
+		defer cleanupTestDriver(tmpDir)
+
+		err := driver.Remove(&volume.RemoveRequest{Name: "first"})
+		if err == nil {
+			t.Fatal("Expected Remove to be refused")
+		}
+		AssertContains(t, err.Error(), "still mounted", "remove error")
+		if _, ok := driver.volumes["first"]; !ok {
+			t.Error("Expected volume to still exist")
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		AssertDirExists(t, mountpoint)
+	})
+
+	t.Run("unmount-if-unreferenced keeps a shared mount", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, "unmount-if-unreferenced", true)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.Remove(&volume.RemoveRequest{Name: "first"}); err != nil {
+			t.Fatalf("Failed to remove volume: %v", err)
+		}
+		if _, ok := driver.volumes["first"]; ok {
+			t.Error("Expected volume to be removed")
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		AssertDirExists(t, mountpoint)
+	})
+
+	t.Run("unmount-if-unreferenced unmounts an unshared mount", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, "unmount-if-unreferenced", false)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+
+		if err := driver.Remove(&volume.RemoveRequest{Name: "first"}); err != nil {
+			t.Fatalf("Failed to remove volume: %v", err)
+		}
+		executor.AssertCommandContains(t, mountpoint)
+		AssertDirNotExists(t, mountpoint)
+	})
+
+	t.Run("detach leaves the mount alone", func(t *testing.T) {
+		for _, shared := range []bool{true, false} {
+			driver, tmpDir, executor, mountpoint := setup(t, "detach", shared)
+
+			if err := driver.Remove(&volume.RemoveRequest{Name: "first"}); err != nil {
+				t.Fatalf("Failed to remove volume: %v", err)
+			}
+			if _, ok := driver.volumes["first"]; ok {
+				t.Error("Expected volume to be removed")
+			}
+			AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+			AssertDirExists(t, mountpoint)
+			cleanupTestDriver(tmpDir)
+		}
+	})
+
+	t.Run("unreadable mount table refuses removal", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, "unmount-if-unreferenced", false)
+		defer cleanupTestDriver(tmpDir)
+		if err := os.WriteFile(filepath.Join(mountpoint, "remote-file"), []byte("data"), 0o644); err != nil {
+			t.Fatalf("Failed to write remote file: %v", err)
+		}
+		os.Remove(driver.mountsPath)
+
+		err := driver.Remove(&volume.RemoveRequest{Name: "first"})
+		AssertError(t, err, "remove")
+		AssertContains(t, err.Error(), "failed to read mount table", "remove error")
+		if _, ok := driver.volumes["first"]; !ok {
+			t.Error("Expected volume to still exist")
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		AssertFileExists(t, filepath.Join(mountpoint, "remote-file"))
+	})
+}
+
+// TestPath tests getting volume path
+func TestPath(t *testing.T) {
+	t.Run("get path for existing volume", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		mountpoint := filepath.Join(tmpDir, "volumes", "test")
+		driver.volumes["test-volume"] = &sshfsVolume{
+			Sshcmd:     "user@host:/path",
+			Mountpoint: mountpoint,
+		}
+
+		req := &volume.PathRequest{
+			Name: "test-volume",
+		}
+
+		resp, err := driver.Path(req)
+		if err != nil {
+			t.Fatalf("Failed to get path: %v", err)
+		}
+
+		if resp.Mountpoint != mountpoint {
+			t.Errorf("Expected mountpoint to be %s, got %s", mountpoint, resp.Mountpoint)
+		}
+	})
+
+	t.Run("get path for non-existent volume fails", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		req := &volume.PathRequest{
+			Name: "non-existent",
+		}
+
+		_, err := driver.Path(req)
+		if err == nil {
+			t.Fatal("Expected error when getting path for non-existent volume")
+		}
+	})
+}
+
+// TestGet tests getting volume info
+func TestGet(t *testing.T) {
+	t.Run("get existing volume", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		mountpoint := filepath.Join(tmpDir, "volumes", "test")
+		driver.volumes["test-volume"] = &sshfsVolume{
+			Sshcmd:     "user@host:/path",
+			Mountpoint: mountpoint,
+		}
+
+		req := &volume.GetRequest{
+			Name: "test-volume",
+		}
+
+		resp, err := driver.Get(req)
+		if err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+
+		if resp.Volume.Name != "test-volume" {
+			t.Errorf("Expected volume name to be test-volume, got %s", resp.Volume.Name)
+		}
+
+		if resp.Volume.Mountpoint != mountpoint {
+			t.Errorf("Expected mountpoint to be %s, got %s", mountpoint, resp.Volume.Mountpoint)
+		}
+	})
+
+	t.Run("get non-existent volume fails", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		req := &volume.GetRequest{
+			Name: "non-existent",
+		}
+
+		_, err := driver.Get(req)
+		if err == nil {
+			t.Fatal("Expected error when getting non-existent volume")
+		}
+	})
+}
+
+// TestList tests listing volumes
+func TestList(t *testing.T) {
+	t.Run("list empty volumes", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		resp, err := driver.List()
+		if err != nil {
+			t.Fatalf("Failed to list volumes: %v", err)
+		}
+
+		if len(resp.Volumes) != 0 {
+			t.Errorf("Expected 0 volumes, got %d", len(resp.Volumes))
+		}
+	})
+
+	t.Run("list multiple volumes", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		driver.volumes["volume1"] = &sshfsVolume{
+			Sshcmd:     "user@host1:/path1",
+			Mountpoint: filepath.Join(tmpDir, "volumes", "vol1"),
+		}
+
+		driver.volumes["volume2"] = &sshfsVolume{
+			Sshcmd:     "user@host2:/path2",
+			Mountpoint: filepath.Join(tmpDir, "volumes", "vol2"),
+		}
+
+		resp, err := driver.List()
+		if err != nil {
+			t.Fatalf("Failed to list volumes: %v", err)
+		}
+
+		if len(resp.Volumes) != 2 {
+			t.Errorf("Expected 2 volumes, got %d", len(resp.Volumes))
+		}
+
+		// Check if both volumes are in the list
+		volumeNames := make(map[string]bool)
+		for _, vol := range resp.Volumes {
+			volumeNames[vol.Name] = true
+		}
+
+		if !volumeNames["volume1"] {
+			t.Error("Expected volume1 in list")
+		}
+
+		if !volumeNames["volume2"] {
+			t.Error("Expected volume2 in list")
+		}
+	})
+
+	t.Run("list is sorted by name", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, name := range []string{"delta", "alpha", "charlie", "bravo", "echo"} {
+			driver.volumes[name] = &sshfsVolume{Sshcmd: "user@host:/" + name}
+		}
+
+		for i := 0; i < 5; i++ {
+			resp, err := driver.List()
+			if err != nil {
+				t.Fatalf("Failed to list volumes: %v", err)
+			}
+			names := make([]string, 0, len(resp.Volumes))
+			for _, vol := range resp.Volumes {
+				names = append(names, vol.Name)
+			}
+			AssertEqual(t, "alpha,bravo,charlie,delta,echo", strings.Join(names, ","), "volume order")
+		}
+	})
+}
+
+// TestConcurrentListWithWrites tests that List stays consistent while volumes are created and removed
+func TestConcurrentListWithWrites(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				name := fmt.Sprintf("volume-%d-%d", i, j)
+				driver.Create(&volume.CreateRequest{
+					Name:    name,
+					Options: map[string]string{"sshcmd": fmt.Sprintf("user@host:/path/%d/%d", i, j)},
+				})
+				driver.Remove(&volume.RemoveRequest{Name: name})
+			}
+		}(i)
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				resp, err := driver.List()
+				if err != nil {
+					t.Errorf("Failed to list volumes: %v", err)
+					return
+				}
+
+				seen := make(map[string]bool)
+				for _, vol := range resp.Volumes {
+					if vol.Name == "" || vol.Mountpoint == "" {
+						t.Errorf("Expected complete volume entries, got %#v", vol)
+					}
+					if seen[vol.Name] {
+						t.Errorf("Expected %s to be listed once", vol.Name)
+					}
+					seen[vol.Name] = true
+				}
+
+				driver.Get(&volume.GetRequest{Name: "volume-0-0"})
+				driver.Path(&volume.PathRequest{Name: "volume-0-0"})
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	resp, err := driver.List()
+	if err != nil {
+		t.Fatalf("Failed to list volumes: %v", err)
+	}
+	if len(resp.Volumes) != 0 {
+		t.Errorf("Expected every volume to be removed, got %d", len(resp.Volumes))
+	}
+}
+
+// TestConcurrentMountSingleFlight tests that simultaneous mounts of one volume run sshfs once
+func TestConcurrentMountSingleFlight(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	driver.volumes["test-volume"] = &sshfsVolume{
+		Sshcmd:     "user@host:/path",
+		Mountpoint: filepath.Join(tmpDir, "volumes", "test"),
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.OnRun = func(cmd *exec.Cmd) {
+		close(started)
+		<-release
+	}
+	driver.executor = executor
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = driver.Mount(&volume.MountRequest{
+				Name: "test-volume",
+				ID:   fmt.Sprintf("container-%d", i),
+			})
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+
+	// Give the second mount time to find the first one in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Mount %d failed: %v", i, err)
+		}
+	}
+
+	if executor.GetCommandCount() != 1 {
+		t.Errorf("Expected sshfs to run once, got %d commands", executor.GetCommandCount())
+	}
+
+	if driver.volumes["test-volume"].connections != 2 {
+		t.Errorf("Expected 2 connections, got %d", driver.volumes["test-volume"].connections)
+	}
+}
+
+// TestConcurrentMountSharesFailure tests that a waiting mount gets the in-flight mount's error
+func TestConcurrentMountSharesFailure(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	driver.volumes["test-volume"] = &sshfsVolume{
+		Sshcmd:     "user@host:/path",
+		Mountpoint: filepath.Join(tmpDir, "volumes", "test"),
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+	executor.OnRun = func(cmd *exec.Cmd) {
+		close(started)
+		<-release
+	}
+	driver.executor = executor
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = driver.Mount(&volume.MountRequest{Name: "test-volume", ID: fmt.Sprintf("container-%d", i)})
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("Expected mount %d to fail", i)
+		}
+	}
+
+	if executor.GetCommandCount() != 1 {
+		t.Errorf("Expected sshfs to run once, got %d commands", executor.GetCommandCount())
+	}
+
+	if driver.volumes["test-volume"].connections != 0 {
+		t.Errorf("Expected 0 connections, got %d", driver.volumes["test-volume"].connections)
+	}
+}
+
+// TestRemoveCancelsMount tests that Remove cancels a mount in progress
+// instead of waiting for it or failing
+func TestRemoveCancelsMount(t *testing.T) {
+	remove := func(t *testing.T, driver *sshfsDriver, name string) {
+		t.Helper()
+		done := make(chan error)
+		go func() { done <- driver.Remove(&volume.RemoveRequest{Name: name}) }()
+		select {
+		case err := <-done:
+			AssertNoError(t, err, "remove")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Remove did not return")
+		}
+		if _, ok := driver.volumes[name]; ok {
+			t.Errorf("Expected volume %s to be removed", name)
+		}
+	}
+
+	t.Run("mount waiting for a host slot", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.MaxHostMounts = 1
+		for _, name := range []string{"holder", "waiter"} {
+			err := driver.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"sshcmd": "user@host:/" + name}})
+			if err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+		}
+
+		started, release := make(chan struct{}), make(chan struct{})
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.OnRun = func(cmd *exec.Cmd) {
+			close(started)
+			<-release
+		}
+		driver.executor = executor
+
+		held := make(chan error)
+		go func() {
+			_, err := driver.Mount(&volume.MountRequest{Name: "holder", ID: "c1"})
+			held <- err
+		}()
+		<-started
+		mounted := make(chan error)
+		go func() {
+			_, err := driver.Mount(&volume.MountRequest{Name: "waiter", ID: "c2"})
+			mounted <- err
+		}()
+		for {
+			driver.RLock()
+			waiting := driver.volumes["waiter"].mounting != nil
+			driver.RUnlock()
+			if waiting {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		remove(t, driver, "waiter")
+		err := <-mounted
+		AssertError(t, err, "cancelled mount")
+		AssertContains(t, err.Error(), "mount of volume waiter was cancelled", "mount error")
+
+		close(release)
+		AssertNoError(t, <-held, "mount holding the slot")
+		AssertEqual(t, 1, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("late mount is undone and fallback hosts skipped", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.mountsPath = filepath.Join(tmpDir, "mounts")
+		if err := os.WriteFile(driver.mountsPath, nil, 0o644); err != nil {
+			t.Fatalf("Failed to write mount table: %v", err)
+		}
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{
+			"sshcmd":         "user@primary:/path",
+			"fallback_hosts": "backup",
+		}})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		mountpoint := driver.volumes["test-volume"].Mountpoint
+
+		// Stand in for an sshfs that only completes its mount after the
+		// mount was cancelled.
+		started := make(chan struct{})
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		executor.OnRun = func(cmd *exec.Cmd) {
+			if cmd.Args[0] != "sshfs" {
+				os.WriteFile(driver.mountsPath, nil, 0o644)
+				return
+			}
+			driver.RLock()
+			call := driver.volumes["test-volume"].mounting
+			driver.RUnlock()
+			close(started)
+			<-call.ctx.Done()
+			os.WriteFile(driver.mountsPath, []byte("user@primary:/path "+mountpoint+" fuse.sshfs rw 0 0\n"), 0o644)
+		}
+		driver.executor = executor
+
+		mounted := make(chan error)
+		go func() {
+			_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+			mounted <- err
+		}()
+		<-started
+
+		remove(t, driver, "test-volume")
+		err = <-mounted
+		AssertError(t, err, "cancelled mount")
+		AssertContains(t, err.Error(), "was cancelled", "mount error")
+
+		commands := executor.GetCommands()
+		AssertEqual(t, 2, len(commands), "commands run")
+		AssertEqual(t, "user@primary:/path", commands[0][2], "mounted host")
+		AssertEqual(t, mountpoint, commands[1][len(commands[1])-1], "unmount target")
+		AssertDirNotExists(t, mountpoint)
+	})
+
+	t.Run("running sshfs is killed", func(t *testing.T) {
+		sleep, err := exec.LookPath("sleep")
+		if err != nil {
+			t.Skip("sleep not available")
+		}
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+
+		// Stand in for an sshfs that hangs: run sleep in its place, in
+		// the command bound to the mount's context.
+		started := make(chan struct{})
+		driver.executor = retryExecutor{next: executorFunc(func(cmd *exec.Cmd) ([]byte, error) {
+			if cmd.Args[0] != "sshfs" {
+				return nil, nil
+			}
+			cmd.Path, cmd.Args, cmd.Err = sleep, []string{"sleep", "60"}, nil
+			close(started)
+			return cmd.CombinedOutput()
+		})}
+
+		mounted := make(chan error)
+		go func() {
+			_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+			mounted <- err
+		}()
+		<-started
+
+		remove(t, driver, "test-volume")
+		select {
+		case err := <-mounted:
+			AssertError(t, err, "cancelled mount")
+			AssertContains(t, err.Error(), "was cancelled", "mount error")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Mount did not return once cancelled")
+		}
+	})
+}
+
+// TestRepeatedMount tests that a Mount replayed for the same container is a no-op
+func TestRepeatedMount(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	driver.volumes["test-volume"] = &sshfsVolume{
+		Sshcmd:     "user@host:/path",
+		Mountpoint: filepath.Join(tmpDir, "volumes", "test"),
+	}
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	var mountpoints []string
+	for i := 0; i < 2; i++ {
+		resp, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"})
+		if err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		mountpoints = append(mountpoints, resp.Mountpoint)
+	}
+	AssertEqual(t, mountpoints[0], mountpoints[1], "mountpoint")
+	AssertEqual(t, 1, executor.GetCommandCount(), "sshfs runs")
+	AssertEqual(t, 1, driver.volumes["test-volume"].connections, "connections")
+
+	// One Unmount releases the volume.
+	if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "container-1"}); err != nil {
+		t.Fatalf("Failed to unmount volume: %v", err)
+	}
+	AssertEqual(t, 0, driver.volumes["test-volume"].connections, "connections after unmount")
+	AssertEqual(t, 2, executor.GetCommandCount(), "commands after unmount")
+}
+
 // TestCapabilities tests driver capabilities
 func TestCapabilities(t *testing.T) {
 	driver, tmpDir := setupTestDriver(t)
 	defer cleanupTestDriver(tmpDir)
 
-	resp := driver.Capabilities()
-	if resp.Capabilities.Scope != "local" {
-		t.Errorf("Expected scope to be local, got %s", resp.Capabilities.Scope)
+	resp := driver.Capabilities()
+	if resp.Capabilities.Scope != "local" {
+		t.Errorf("Expected scope to be local, got %s", resp.Capabilities.Scope)
+	}
+}
+
+// TestMountpoint tests mountpoint generation
+func TestMountpointGeneration(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	// Create two volumes with different sshcmd values
+	req1 := &volume.CreateRequest{
+		Name: "volume1",
+		Options: map[string]string{
+			"sshcmd": "user@host1:/path1",
+		},
+	}
+
+	req2 := &volume.CreateRequest{
+		Name: "volume2",
+		Options: map[string]string{
+			"sshcmd": "user@host2:/path2",
+		},
+	}
+
+	driver.Create(req1)
+	driver.Create(req2)
+
+	vol1 := driver.volumes["volume1"]
+	vol2 := driver.volumes["volume2"]
+
+	// Mountpoints should be different
+	if vol1.Mountpoint == vol2.Mountpoint {
+		t.Error("Expected different mountpoints for different sshcmd values")
+	}
+
+	// Create a volume with the same sshcmd as volume1
+	req3 := &volume.CreateRequest{
+		Name: "volume3",
+		Options: map[string]string{
+			"sshcmd": "user@host1:/path1",
+		},
+	}
+
+	driver.Create(req3)
+	vol3 := driver.volumes["volume3"]
+
+	// Mountpoint should be the same as volume1
+	if vol1.Mountpoint != vol3.Mountpoint {
+		t.Error("Expected same mountpoint for same sshcmd value")
+	}
+}
+
+// TestCanonicalRemotePath tests that equivalent remote paths share a mountpoint
+func TestCanonicalRemotePath(t *testing.T) {
+	t.Run("equivalent paths produce the same mountpoint", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		sshcmds := []string{"user@host:/data", "user@host:/data/", "user@host:/data//", "user@host:/srv/../data/."}
+		for i, sshcmd := range sshcmds {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    fmt.Sprintf("volume-%d", i),
+				Options: map[string]string{"sshcmd": sshcmd},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create volume for %s: %v", sshcmd, err)
+			}
+		}
+
+		for i := range sshcmds {
+			vol := driver.volumes[fmt.Sprintf("volume-%d", i)]
+			if vol.Sshcmd != "user@host:/data" {
+				t.Errorf("Expected sshcmd user@host:/data, got %s", vol.Sshcmd)
+			}
+			if vol.Mountpoint != driver.volumes["volume-0"].Mountpoint {
+				t.Errorf("Expected %s to share the mountpoint of %s", sshcmds[i], sshcmds[0])
+			}
+		}
+	})
+
+	t.Run("root and home paths are kept", func(t *testing.T) {
+		tests := map[string]string{
+			"user@host:/":        "user@host:/",
+			"user@host://":       "user@host:/",
+			"user@host:":         "user@host:",
+			"user@host:data/":    "user@host:data",
+			"user@[::1]:/data/":  "user@[::1]:/data",
+			"host:/data/a:b/../": "host:/data",
+		}
+		for sshcmd, expected := range tests {
+			if got := canonicalSshcmd(sshcmd); got != expected {
+				t.Errorf("canonicalSshcmd(%q) = %q, expected %q", sshcmd, got, expected)
+			}
+		}
+	})
+}
+
+// TestLogError tests the logError function
+func TestLogError(t *testing.T) {
+	err := logError("test error: %s", "message")
+	if err == nil {
+		t.Fatal("Expected error to be returned")
+	}
+
+	if err.Error() != "test error: message" {
+		t.Errorf("Expected error message to be 'test error: message', got '%s'", err.Error())
+	}
+
+	t.Run("with fields", func(t *testing.T) {
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		err := logErrorWith(logrus.Fields{"volume": "data", "op": "mount"}, "volume %s: %w", "data", ErrVolumeNotFound)
+		AssertEqual(t, "volume data: volume not found", err.Error(), "error message")
+		AssertEqual(t, true, errors.Is(err, ErrVolumeNotFound), "wrapped error")
+
+		entry := hook.LastEntry()
+		if entry == nil {
+			t.Fatal("Expected a log entry")
+		}
+		AssertEqual(t, logrus.ErrorLevel, entry.Level, "log level")
+		AssertEqual(t, err.Error(), entry.Message, "logged message")
+		AssertEqual(t, "data", entry.Data["volume"], "volume field")
+		AssertEqual(t, "mount", entry.Data["op"], "op field")
+
+		legacy := logError("volume %s: %w", "data", ErrVolumeNotFound)
+		AssertEqual(t, err.Error(), legacy.Error(), "legacy error message")
+		AssertEqual(t, 0, len(hook.LastEntry().Data), "legacy fields")
+	})
+}
+
+// TestCopyIdentityFile tests copying an identity file into the managed key directory
+func TestCopyIdentityFile(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	driver.executor = executor
+
+	keyPath := filepath.Join(tmpDir, "id_rsa")
+	if err := os.WriteFile(keyPath, []byte("private key"), 0o644); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	err := driver.Create(&volume.CreateRequest{
+		Name: "test-volume",
+		Options: map[string]string{
+			"sshcmd":             "user@host:/path",
+			"IdentityFile":       keyPath,
+			"copy_identity_file": "true",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+
+	managed := filepath.Join(driver.keysDir, "test-volume", "id_rsa")
+	info, err := os.Stat(managed)
+	if err != nil {
+		t.Fatalf("Expected managed key to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected managed key mode 0600, got %o", info.Mode().Perm())
+	}
+
+	vol := driver.volumes["test-volume"]
+	if len(vol.Options) != 1 || vol.Options[0] != "IdentityFile="+managed {
+		t.Errorf("Expected only the managed path to be stored, got %v", vol.Options)
+	}
+
+	// The original may disappear once the volume is created
+	os.Remove(keyPath)
+
+	executor.AddMockResponse(nil, nil)
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	executor.AssertCommandContains(t, "IdentityFile="+managed)
+
+	driver.volumes["test-volume"].connections = 0
+	if err := driver.Remove(&volume.RemoveRequest{Name: "test-volume"}); err != nil {
+		t.Fatalf("Failed to remove volume: %v", err)
+	}
+	AssertDirNotExists(t, filepath.Join(driver.keysDir, "test-volume"))
+}
+
+// TestNoPersistPassword tests keeping a password out of the saved state
+func TestNoPersistPassword(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	driver.executor = executor
+
+	err := driver.Create(&volume.CreateRequest{
+		Name: "test-volume",
+		Options: map[string]string{
+			"sshcmd":              "user@host:/path",
+			"password":            "secret",
+			"no_persist_password": "",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+
+	data, err := os.ReadFile(driver.statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+	AssertNotContains(t, string(data), "secret", "saved state")
+
+	// The in-memory volume still mounts with the password
+	executor.AddMockResponse(nil, nil)
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	AssertContains(t, strings.Join(executor.LastCmd().Env, " "), askpassPasswordEnv+"=secret", "askpass environment")
+
+	t.Run("mount after restart requires the password", func(t *testing.T) {
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
+		restarted.executor = NewTestCommandExecutor()
+
+		_, err = restarted.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"})
+		if err == nil {
+			t.Fatal("Expected error when mounting without the password")
+		}
+		AssertContains(t, err.Error(), "password_file", "error message")
+	})
+
+	t.Run("password_file supplies the password after restart", func(t *testing.T) {
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
+		restartedExecutor := NewTestCommandExecutor()
+		restartedExecutor.AddMockResponse(nil, nil)
+		restarted.executor = restartedExecutor
+
+		passwordFile := filepath.Join(tmpDir, "password")
+		if err := os.WriteFile(passwordFile, []byte("secret\n"), 0o600); err != nil {
+			t.Fatalf("Failed to write password file: %v", err)
+		}
+		restarted.volumes["test-volume"].PasswordFile = passwordFile
+
+		if _, err := restarted.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+
+		env := restartedExecutor.LastCmd().Env
+		AssertContains(t, strings.Join(env, " "), askpassPasswordEnv+"=secret ", "askpass environment")
+	})
+
+	t.Run("re-create after restart supplies the password", func(t *testing.T) {
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
+		restartedExecutor := NewTestCommandExecutor()
+		restartedExecutor.AddMockResponse(nil, nil)
+		restarted.executor = restartedExecutor
+
+		options := map[string]string{"sshcmd": "user@host:/path", "password": "secret", "no_persist_password": ""}
+		if err := restarted.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to re-create volume: %v", err)
+		}
+		// Once supplied, the password is part of the definition again.
+		options["password"] = "other"
+		err = restarted.Create(&volume.CreateRequest{Name: "test-volume", Options: options})
+		AssertEqual(t, true, errors.Is(err, ErrVolumeConflict), "re-create with another password conflicts")
+		if _, err := restarted.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		AssertContains(t, strings.Join(restartedExecutor.LastCmd().Env, " "), askpassPasswordEnv+"=secret", "askpass environment")
+
+		data, err := os.ReadFile(driver.statePath)
+		AssertNoError(t, err, "read state")
+		AssertNotContains(t, string(data), "secret", "saved state")
+	})
+}
+
+// TestMountpointMode tests the permissions of mountpoints created by Mount
+func TestMountpointMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		option   string
+		driver   os.FileMode
+		expected os.FileMode
+	}{
+		{"driver default", "", 0o755, 0o755},
+		{"driver setting", "", 0o750, 0o750},
+		{"volume option", "0770", 0o755, 0o770},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.config.MountpointMode = tt.driver
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			options := map[string]string{"sshcmd": "user@host:/path"}
+			if tt.option != "" {
+				options["mountpoint_mode"] = tt.option
+			}
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+
+			resp, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"})
+			if err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+
+			info, err := os.Stat(resp.Mountpoint)
+			if err != nil {
+				t.Fatalf("Expected mountpoint to exist: %v", err)
+			}
+			if info.Mode().Perm() != tt.expected {
+				t.Errorf("Expected mode %o, got %o", tt.expected, info.Mode().Perm())
+			}
+		})
+	}
+
+	t.Run("invalid mode is rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, mode := range []string{"0999", "rwxr-xr-x", "17777"} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", "mountpoint_mode": mode},
+			})
+			if err == nil {
+				t.Errorf("Expected error for mountpoint_mode %s", mode)
+			}
+		}
+	})
+}
+
+// TestMountpointOwner tests the mountpoint_uid and mountpoint_gid options
+func TestMountpointOwner(t *testing.T) {
+	t.Run("mountpoint is owned as configured", func(t *testing.T) {
+		if os.Geteuid() != 0 {
+			t.Skip("changing the owner requires root")
+		}
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "mountpoint_uid": "1234", "mountpoint_gid": "5678"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		resp, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"})
+		if err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+
+		info, err := os.Stat(resp.Mountpoint)
+		if err != nil {
+			t.Fatalf("Expected mountpoint to exist: %v", err)
+		}
+		st := info.Sys().(*syscall.Stat_t)
+		AssertEqual(t, uint32(1234), st.Uid, "mountpoint uid")
+		AssertEqual(t, uint32(5678), st.Gid, "mountpoint gid")
+	})
+
+	t.Run("non-numeric IDs are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, option := range []string{"mountpoint_uid", "mountpoint_gid"} {
+			for _, val := range []string{"", "www-data", "-1", "12a"} {
+				err := driver.Create(&volume.CreateRequest{
+					Name:    "test-volume",
+					Options: map[string]string{"sshcmd": "user@host:/path", option: val},
+				})
+				if err == nil {
+					t.Errorf("Expected error for %s %q", option, val)
+					continue
+				}
+				AssertContains(t, err.Error(), "expected a numeric ID", "error")
+			}
+		}
+	})
+}
+
+// TestPasswordAskpass tests that password mounts answer prompts through the askpass helper
+func TestPasswordAskpass(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.askpass = "/docker-volume-sshfs"
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	driver.volumes["test-volume"] = &sshfsVolume{
+		Sshcmd:     "user@host:/path",
+		Password:   "secret",
+		Mountpoint: filepath.Join(tmpDir, "volumes", "test"),
+	}
+
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "container-1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+
+	executor.AssertCommandContains(t, "-o PreferredAuthentications=keyboard-interactive,password")
+
+	env := executor.LastCmd().Env
+	for _, expected := range []string{
+		"SSH_ASKPASS=/docker-volume-sshfs",
+		"SSH_ASKPASS_REQUIRE=force",
+		askpassPasswordEnv + "=secret",
+		"SHELL=/docker-volume-sshfs",
+	} {
+		found := false
+		for _, e := range env {
+			if e == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %s in the sshfs environment", expected)
+		}
+	}
+}
+
+// TestAskpassShell tests that commands ssh runs through SHELL, such as a
+// ProxyCommand, get the real shell without the password
+func TestAskpassShell(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	driver.askpass = "/docker-volume-sshfs"
+
+	environ := append([]string{"PATH=/usr/bin", "TOKEN=abc"}, driver.askpassEnv("secret")...)
+	argv, env := askpassShell("/bin/bash", []string{"-c", "exec nc proxy 1080"}, environ)
+
+	AssertEqual(t, "/bin/bash -c exec nc proxy 1080", strings.Join(argv, " "), "shell command")
+	joined := strings.Join(env, "\n")
+	for _, name := range []string{askpassPasswordEnv, askpassShellEnv, "SSH_ASKPASS"} {
+		AssertNotContains(t, joined, name+"=", "shell environment")
+	}
+	AssertNotContains(t, joined, "secret", "shell environment")
+	AssertContains(t, joined, "TOKEN=abc", "shell environment")
+	AssertEqual(t, "SHELL=/bin/bash", env[len(env)-1], "shell variable")
+	AssertEqual(t, 1, strings.Count(joined, "SHELL="), "shell variables")
+}
+
+// TestPropagatedRoot tests that mounts outside the propagated mount are refused
+func TestPropagatedRoot(t *testing.T) {
+	t.Run("mountpoint outside the propagated root", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.PropagatedRoot = filepath.Join(tmpDir, "propagated")
+
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		if err == nil {
+			t.Fatal("Expected error for a mountpoint outside the propagated root")
+		}
+		AssertContains(t, err.Error(), "is not under the propagated mount", "mount error")
+		if executor.GetCommandCount() != 0 {
+			t.Errorf("Expected sshfs not to run, got %v", executor.GetCommands())
+		}
+		if driver.volumes["test-volume"].connections != 0 {
+			t.Errorf("Expected 0 connections, got %d", driver.volumes["test-volume"].connections)
+		}
+	})
+
+	t.Run("driver root outside the propagated root", func(t *testing.T) {
+		tmpDir, err := os.MkdirTemp("", "sshfs-test-*")
+		if err != nil {
+			t.Fatalf("Failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		config := defaultDriverConfig()
+		config.PropagatedRoot = filepath.Join(tmpDir, "propagated")
+		if _, err := newSshfsDriverWithConfig(tmpDir, config); err == nil {
+			t.Error("Expected error when the volumes root is outside the propagated root")
+		}
+
+		config.PropagatedRoot = filepath.Join(tmpDir, "volumes")
+		if _, err := newSshfsDriverWithConfig(tmpDir, config); err != nil {
+			t.Errorf("Expected no error for a matching propagated root, got %v", err)
+		}
+	})
+}
+
+// TestServerAliveOptions tests the server_alive_interval and server_alive_count_max options
+func TestServerAliveOptions(t *testing.T) {
+	t.Run("values flow into the sshfs command", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name: "test-volume",
+			Options: map[string]string{
+				"sshcmd":                 "user@host:/path",
+				"server_alive_interval":  "15",
+				"server_alive_count_max": "0",
+				"serveraliveinterval":    "60",
+				"reconnect":              "",
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+
+		args := strings.Join(executor.LastCmd().Args, " ")
+		AssertContains(t, args, "-o ServerAliveInterval=15", "sshfs args")
+		AssertContains(t, args, "-o ServerAliveCountMax=0", "sshfs args")
+		AssertNotContains(t, args, "serveraliveinterval=60", "sshfs args")
+		if n := strings.Count(args, "reconnect"); n != 1 {
+			t.Errorf("Expected reconnect once, got %d in %s", n, args)
+		}
+	})
+
+	t.Run("non-numeric values are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, opts := range []map[string]string{
+			{"server_alive_interval": "fast"},
+			{"server_alive_interval": "-1"},
+			{"server_alive_count_max": "3x"},
+		} {
+			opts["sshcmd"] = "user@host:/path"
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: opts}); err == nil {
+				t.Errorf("Expected error for options %v", opts)
+			}
+		}
+		if len(driver.volumes) != 0 {
+			t.Errorf("Expected no volumes, got %d", len(driver.volumes))
+		}
+	})
+}
+
+// TestArgvLimit tests that oversized option sets fail before sshfs runs
+func TestArgvLimit(t *testing.T) {
+	for name, options := range map[string]map[string]string{
+		"single huge option": {"ProxyCommand": strings.Repeat("x", maxArgLen)},
+		"many large options": func() map[string]string {
+			opts := map[string]string{}
+			for i := 0; i < 20; i++ {
+				opts[fmt.Sprintf("opt%d", i)] = strings.Repeat("x", 64<<10)
+			}
+			return opts
+		}(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+
+			executor := NewTestCommandExecutor()
+			driver.executor = executor
+
+			options["sshcmd"] = "user@host:/path"
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+			if err == nil {
+				t.Fatal("Expected mount with oversized options to fail")
+			}
+			AssertContains(t, err.Error(), "too long", "mount error")
+			AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		})
+	}
+}
+
+// TestSyncOption tests the sync option
+func TestSyncOption(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	for name, opts := range map[string]map[string]string{
+		"sync-volume":  {"sshcmd": "user@host:/sync", "sync": ""},
+		"async-volume": {"sshcmd": "user@host:/async"},
+	} {
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: opts}); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: name, ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount %s: %v", name, err)
+		}
+
+		args := strings.Join(executor.LastCmd().Args, " ")
+		resp, err := driver.Get(&volume.GetRequest{Name: name})
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", name, err)
+		}
+		if name == "sync-volume" {
+			AssertContains(t, args, "-o sshfs_sync", "sshfs args")
+			AssertEqual(t, true, resp.Volume.Status["sync"], "sync status")
+		} else {
+			AssertNotContains(t, args, "sshfs_sync", "sshfs args")
+			if _, ok := resp.Volume.Status["sync"]; ok {
+				t.Errorf("Expected no sync status for %s", name)
+			}
+		}
+	}
+
+	data, err := os.ReadFile(driver.statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
 	}
+	AssertContains(t, string(data), `"Sync":true`, "state file")
 }
 
-// TestMountpoint tests mountpoint generation
-func TestMountpointGeneration(t *testing.T) {
+// TestNoCacheOption tests that no_cache expands to options disabling every cache
+func TestNoCacheOption(t *testing.T) {
 	driver, tmpDir := setupTestDriver(t)
 	defer cleanupTestDriver(tmpDir)
 
-	// Create two volumes with different sshcmd values
-	req1 := &volume.CreateRequest{
-		Name: "volume1",
-		Options: map[string]string{
-			"sshcmd": "user@host1:/path1",
-		},
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "test-volume",
+		Options: map[string]string{"sshcmd": "user@host:/path", "no_cache": "true"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
 	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	executor.AssertCommandContains(t, "-o cache=no -o attr_timeout=0 -o entry_timeout=0")
 
-	req2 := &volume.CreateRequest{
-		Name: "volume2",
-		Options: map[string]string{
-			"sshcmd": "user@host2:/path2",
-		},
+	resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+	if err != nil {
+		t.Fatalf("Failed to get volume: %v", err)
 	}
+	AssertEqual(t, true, resp.Volume.Status["no_cache"], "no_cache status")
+}
 
-	driver.Create(req1)
-	driver.Create(req2)
+// TestCacheTimeoutOptions tests validation of the cache timeout options
+func TestCacheTimeoutOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  map[string]string
+		wantArgs string
+		wantErr  string
+	}{
+		{"entry timeout", map[string]string{"entry_timeout": "30"}, "-o entry_timeout=30", ""},
+		{"fractional attr timeout", map[string]string{"attr_timeout": "0.5"}, "-o attr_timeout=0.5", ""},
+		{"dcache timeout", map[string]string{"dcache_timeout": "600"}, "-o dcache_timeout=600", ""},
+		{"all three", map[string]string{"entry_timeout": "0", "attr_timeout": "1", "dcache_timeout": "86400"}, "-o entry_timeout=0 -o attr_timeout=1 -o dcache_timeout=86400", ""},
+		{"negative", map[string]string{"entry_timeout": "-1"}, "", `invalid value "-1" for option entry_timeout`},
+		{"above a day", map[string]string{"attr_timeout": "86401"}, "", `invalid value "86401" for option attr_timeout, expected seconds from 0 to 86400`},
+		{"not a number", map[string]string{"attr_timeout": "NaN"}, "", `invalid value "NaN" for option attr_timeout`},
+		{"fractional dcache timeout", map[string]string{"dcache_timeout": "1.5"}, "", `invalid value "1.5" for option dcache_timeout, expected whole seconds`},
+		{"with no_cache", map[string]string{"no_cache": "true", "dcache_timeout": "60"}, "", "no_cache cannot be combined with options that tune the cache"},
+		{"with no_cache off", map[string]string{"no_cache": "false", "entry_timeout": "60"}, "-o entry_timeout=60", ""},
+	}
 
-	vol1 := driver.volumes["volume1"]
-	vol2 := driver.volumes["volume2"]
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			tt.options["sshcmd"] = "user@host:/path"
+			err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: tt.options})
+			if tt.wantErr != "" {
+				AssertError(t, err, "create")
+				AssertContains(t, err.Error(), tt.wantErr, "create error")
+				return
+			}
+			AssertNoError(t, err, "create")
+			if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+			executor.AssertCommandContains(t, tt.wantArgs)
 
-	// Mountpoints should be different
-	if vol1.Mountpoint == vol2.Mountpoint {
-		t.Error("Expected different mountpoints for different sshcmd values")
+			restarted, err := newSshfsDriver(tmpDir)
+			if err != nil {
+				t.Fatalf("Failed to restart driver: %v", err)
+			}
+			resp, err := restarted.Get(&volume.GetRequest{Name: "test-volume"})
+			AssertNoError(t, err, "get")
+			for key, val := range tt.options {
+				if strings.HasSuffix(key, "_timeout") {
+					AssertEqual(t, val, resp.Volume.Status[key], key+" status")
+				}
+			}
+		})
 	}
+}
 
-	// Create a volume with the same sshcmd as volume1
-	req3 := &volume.CreateRequest{
-		Name: "volume3",
-		Options: map[string]string{
-			"sshcmd": "user@host1:/path1",
+// TestDefaultUserAndPort tests the driver's default ssh user and port
+func TestDefaultUserAndPort(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      map[string]string
+		expectedCmd  string
+		expectedPort string
+	}{
+		{"defaults applied", map[string]string{"sshcmd": "host:/path"}, "admin@host:/path", "2222"},
+		{"explicit user wins", map[string]string{"sshcmd": "user@host:/path"}, "user@host:/path", "2222"},
+		{"explicit port wins", map[string]string{"sshcmd": "host:/path", "port": "22"}, "admin@host:/path", "22"},
+		{"ipv6 host", map[string]string{"sshcmd": "[::1]:/path"}, "admin@[::1]:/path", "2222"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "sshfs-test-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer cleanupTestDriver(tmpDir)
+			if err := os.MkdirAll(filepath.Join(tmpDir, "state"), 0o755); err != nil {
+				t.Fatalf("Failed to create state dir: %v", err)
+			}
+
+			config := defaultDriverConfig()
+			config.DefaultUser = "admin"
+			config.DefaultPort = "2222"
+			driver, err := newSshfsDriverWithConfig(tmpDir, config)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: tt.options}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			vol := driver.volumes["test-volume"]
+			AssertEqual(t, tt.expectedCmd, vol.Sshcmd, "sshcmd")
+			AssertEqual(t, tt.expectedPort, vol.Port, "port")
+		})
+	}
+
+	t.Run("no defaults configured", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		AssertEqual(t, "host:/path", driver.volumes["test-volume"].Sshcmd, "sshcmd")
+		AssertEqual(t, "", driver.volumes["test-volume"].Port, "port")
+	})
+}
+
+// TestSaveStateBackup tests the atomic state write and its .bak copy
+func TestSaveStateBackup(t *testing.T) {
+	create := func(driver *sshfsDriver, name string) error {
+		return driver.Create(&volume.CreateRequest{
+			Name:    name,
+			Options: map[string]string{"sshcmd": "user@host:/" + name},
+		})
+	}
+
+	t.Run("previous state is kept as backup", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, name := range []string{"first", "second"} {
+			if err := create(driver, name); err != nil {
+				t.Fatalf("Failed to create volume %s: %v", name, err)
+			}
+		}
+
+		data, err := os.ReadFile(driver.statePath + ".bak")
+		if err != nil {
+			t.Fatalf("Failed to read backup: %v", err)
+		}
+		AssertContains(t, string(data), "first", "backup")
+		AssertNotContains(t, string(data), "second", "backup")
+
+		entries, err := os.ReadDir(filepath.Dir(driver.statePath))
+		if err != nil {
+			t.Fatalf("Failed to read state dir: %v", err)
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".") {
+				t.Errorf("Expected no temporary files, found %s", e.Name())
+			}
+		}
+	})
+
+	t.Run("failing backup logs a warning", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		if err := create(driver, "first"); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		// A directory in place of the backup makes its write fail.
+		if err := os.MkdirAll(filepath.Join(driver.statePath+".bak", "blocker"), 0o755); err != nil {
+			t.Fatalf("Failed to create blocker: %v", err)
+		}
+
+		if err := create(driver, "second"); err != nil {
+			t.Fatalf("Expected Create to succeed despite the failed backup, got %v", err)
+		}
+		entry := hook.LastEntry()
+		if entry == nil || entry.Level != logrus.WarnLevel {
+			t.Fatalf("Expected a warning, got %v", entry)
+		}
+		AssertContains(t, entry.Message, "failed to back up state", "warning")
+
+		data, err := os.ReadFile(driver.statePath)
+		if err != nil {
+			t.Fatalf("Failed to read state: %v", err)
+		}
+		AssertContains(t, string(data), "second", "state")
+	})
+
+	t.Run("failing state write fails Create", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.statePath = filepath.Join(tmpDir, "missing", "sshfs-state.json")
+
+		if err := create(driver, "first"); err == nil {
+			t.Fatal("Expected Create to fail when the state cannot be written")
+		}
+		if _, ok := driver.volumes["first"]; ok {
+			t.Error("Expected volume not to be kept after a failed save")
+		}
+	})
+}
+
+// TestDisableSshpass tests the key-only mode of the driver
+func TestDisableSshpass(t *testing.T) {
+	t.Run("password volumes are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.DisableSshpass = true
+
+		for _, option := range []string{"password", "password_file"} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", option: "secret"},
+			})
+			if err == nil {
+				t.Errorf("Expected %s to be rejected", option)
+			} else {
+				AssertContains(t, err.Error(), "password authentication is disabled", "create error")
+			}
+		}
+		AssertEqual(t, 0, len(driver.volumes), "volumes")
+	})
+
+	t.Run("key volumes build sshpass-free commands", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.DisableSshpass = true
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "IdentityFile": "/keys/id_ed25519"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.TestConnection("test-volume"); err != nil {
+			t.Fatalf("Failed to test connection: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+
+		for _, cmd := range executor.GetCommands() {
+			AssertNotContains(t, strings.Join(cmd, " "), "sshpass", "command")
+		}
+		AssertEqual(t, "ssh", executor.GetCommands()[0][0], "probe command")
+		AssertNotContains(t, strings.Join(executor.LastCmd().Env, " "), askpassPasswordEnv, "mount env")
+	})
+
+	t.Run("persisted password volumes fail to mount", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "password": "secret"},
+		}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		driver.config.DisableSshpass = true
+		driver.executor = NewTestCommandExecutor()
+
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err == nil {
+			t.Error("Expected mount of a password volume to fail")
+		}
+	})
+}
+
+// TestMountLabel tests the fsname set through mount_label
+func TestMountLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		label    string
+		expected string
+	}{
+		{"custom label", "backups/eu-1", "fsname=backups/eu-1"},
+		{"unsafe characters", "team a,ro\tx", "fsname=team_a_ro_x"},
+		{"volume name fallback", "", "fsname=test-volume"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			options := map[string]string{"sshcmd": "user@host:/path"}
+			if tt.label != "" {
+				options["mount_label"] = tt.label
+			}
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+
+			args := executor.LastCmd().Args
+			AssertContains(t, strings.Join(args, " "), "-o "+tt.expected+" -o subtype=sshfs", "sshfs args")
+		})
+	}
+}
+
+// TestSetupCommand tests the setup_command option
+func TestSetupCommand(t *testing.T) {
+	options := map[string]string{"sshcmd": "user@host:/data/app", "setup_command": "mkdir -p /data/app"}
+
+	t.Run("runs once", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		for i := 0; i < 2; i++ {
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+		}
+		AssertEqual(t, 1, executor.GetCommandCount(), "commands run")
+		executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host mkdir -p /data/app")
+
+		data, err := os.ReadFile(driver.statePath)
+		if err != nil {
+			t.Fatalf("Failed to read state: %v", err)
+		}
+		AssertContains(t, string(data), `"SetupDone":true`, "state file")
+	})
+
+	t.Run("failure is surfaced", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("mkdir: cannot create directory '/data': Permission denied\n"), fmt.Errorf("exit status 1"))
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options})
+		if err == nil {
+			t.Fatal("Expected error when the setup command fails")
+		}
+		AssertContains(t, err.Error(), "cannot create directory", "create error")
+		if _, ok := driver.volumes["test-volume"]; ok {
+			t.Error("Expected volume not to be created")
+		}
+	})
+}
+
+// TestVolumeNotFound tests that requests for a missing volume return ErrVolumeNotFound
+func TestVolumeNotFound(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	// By default Unmount ignores unknown volumes.
+	driver.config.UnknownUnmountPolicy = "error"
+
+	calls := map[string]func() error{
+		"Get": func() error {
+			_, err := driver.Get(&volume.GetRequest{Name: "missing"})
+			return err
+		},
+		"Path": func() error {
+			_, err := driver.Path(&volume.PathRequest{Name: "missing"})
+			return err
+		},
+		"Remove": func() error {
+			return driver.Remove(&volume.RemoveRequest{Name: "missing"})
+		},
+		"Mount": func() error {
+			_, err := driver.Mount(&volume.MountRequest{Name: "missing", ID: "c1"})
+			return err
+		},
+		"Unmount": func() error {
+			return driver.Unmount(&volume.UnmountRequest{Name: "missing", ID: "c1"})
+		},
+		"Benchmark": func() error {
+			_, err := driver.Benchmark("missing")
+			return err
+		},
+		"TestConnection": func() error {
+			_, err := driver.TestConnection("missing")
+			return err
 		},
 	}
 
-	driver.Create(req3)
-	vol3 := driver.volumes["volume3"]
+	for method, call := range calls {
+		err := call()
+		if !errors.Is(err, ErrVolumeNotFound) {
+			t.Errorf("Expected %s to return ErrVolumeNotFound, got %v", method, err)
+			continue
+		}
+		AssertContains(t, err.Error(), "missing", method+" error")
+	}
+}
 
-	// Mountpoint should be the same as volume1
-	if vol1.Mountpoint != vol3.Mountpoint {
-		t.Error("Expected same mountpoint for same sshcmd value")
+// TestMixedAccessConsumers tests that containers mounting a volume read-only
+// and read-write share one sshfs mount, as Docker applies the access mode to
+// its own bind mount
+func TestMixedAccessConsumers(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+
+	var mountpoints []string
+	for _, id := range []string{"ro-consumer", "rw-consumer"} {
+		resp, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: id})
+		if err != nil {
+			t.Fatalf("Failed to mount for %s: %v", id, err)
+		}
+		mountpoints = append(mountpoints, resp.Mountpoint)
+	}
+	AssertEqual(t, mountpoints[0], mountpoints[1], "mountpoint")
+	AssertEqual(t, 1, executor.GetCommandCount(), "sshfs mounts")
+	AssertNotContains(t, strings.Join(executor.LastCmd().Args, " "), "-o ro", "sshfs args")
+
+	if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "ro-consumer"}); err != nil {
+		t.Fatalf("Failed to unmount: %v", err)
+	}
+	AssertEqual(t, 1, executor.GetCommandCount(), "commands after the first unmount")
+	if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "rw-consumer"}); err != nil {
+		t.Fatalf("Failed to unmount: %v", err)
 	}
+	AssertEqual(t, 2, executor.GetCommandCount(), "commands after the last unmount")
 }
 
-// TestLogError tests the logError function
-func TestLogError(t *testing.T) {
-	err := logError("test error: %s", "message")
+// TestCacheDirRejected tests that the unsupported cache_dir option is rejected with guidance
+func TestCacheDirRejected(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "test-volume",
+		Options: map[string]string{"sshcmd": "user@host:/path", "cache_dir": "/var/cache/sshfs"},
+	})
 	if err == nil {
-		t.Fatal("Expected error to be returned")
+		t.Fatal("Expected cache_dir to be rejected")
 	}
+	AssertContains(t, err.Error(), "cache_timeout", "create error")
+	if _, ok := driver.volumes["test-volume"]; ok {
+		t.Error("Expected volume not to be created")
+	}
+}
 
-	if err.Error() != "test error: message" {
-		t.Errorf("Expected error message to be 'test error: message', got '%s'", err.Error())
+// TestStateLoadRetry tests retries of transient state read errors and recovery of a corrupt state
+func TestStateLoadRetry(t *testing.T) {
+	setup := func(t *testing.T, failures int, errno syscall.Errno) (*sshfsDriver, string, *int, *[]time.Duration) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path"}
+		if err := driver.saveState(); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+		driver.volumes = map[string]*sshfsVolume{}
+		driver.config.StateLoadBackoff = 10 * time.Millisecond
+
+		reads := 0
+		driver.readFile = func(name string) ([]byte, error) {
+			reads++
+			if reads <= failures {
+				return nil, &os.PathError{Op: "read", Path: name, Err: errno}
+			}
+			return os.ReadFile(name)
+		}
+		var sleeps []time.Duration
+		driver.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+		return driver, tmpDir, &reads, &sleeps
 	}
+
+	t.Run("transient error is retried", func(t *testing.T) {
+		driver, tmpDir, reads, sleeps := setup(t, 2, syscall.ESTALE)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.loadState(); err != nil {
+			t.Fatalf("Expected state load to succeed after retries, got %v", err)
+		}
+		AssertEqual(t, 3, *reads, "reads")
+		AssertEqual(t, "[10ms 20ms]", fmt.Sprint(*sleeps), "backoff")
+		if _, ok := driver.volumes["test-volume"]; !ok {
+			t.Error("Expected volume to be loaded")
+		}
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		driver, tmpDir, reads, _ := setup(t, 100, syscall.EAGAIN)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.loadState(); err == nil {
+			t.Fatal("Expected state load to fail")
+		}
+		AssertEqual(t, driver.config.StateLoadRetries+1, *reads, "reads")
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		driver, tmpDir, reads, _ := setup(t, 1, syscall.EACCES)
+		defer cleanupTestDriver(tmpDir)
+
+		if err := driver.loadState(); err == nil {
+			t.Fatal("Expected state load to fail")
+		}
+		AssertEqual(t, 1, *reads, "reads")
+	})
+
+	t.Run("corrupt state is recovered from the backup", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path"}
+		if err := driver.saveState(); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+		// The next save backs up the state above.
+		if err := driver.saveState(); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+		if err := os.WriteFile(driver.statePath, []byte("{not json"), 0o644); err != nil {
+			t.Fatalf("Failed to corrupt state: %v", err)
+		}
+
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Expected recovery from the backup, got %v", err)
+		}
+		AssertEqual(t, "user@host:/path", restarted.volumes["test-volume"].Sshcmd, "recovered volume")
+
+		if err := os.Remove(driver.statePath + ".bak"); err != nil {
+			t.Fatalf("Failed to remove backup: %v", err)
+		}
+		if _, err := newSshfsDriver(tmpDir); err == nil {
+			t.Error("Expected a corrupt state without backup to fail")
+		}
+	})
 }
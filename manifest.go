@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// loadManifest makes sure the volumes declared in the manifest at path
+// exist, without mounting them. The manifest maps volume names to the
+// options they would be created with. A declared volume that already
+// exists with other options is kept as is, or replaced when the manifest
+// policy is "update"; volumes the manifest does not mention are left alone.
+func (d *sshfsDriver) loadManifest(path string) error {
+	manifest, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, name := range manifestNames(manifest) {
+		v, err := d.parseManifestVolume(name, manifest[name])
+		if err != nil {
+			return err
+		}
+
+		log := logrus.WithField("volume", name)
+		if existing, ok := d.volumes[name]; ok {
+			if sameDefinition(existing, v) {
+				continue
+			}
+			if d.config.ManifestPolicy != "update" {
+				log.Warn("volume differs from the manifest, keeping it")
+				continue
+			}
+			log.Info("updating volume from the manifest")
+		} else {
+			log.Info("creating volume from the manifest")
+		}
+
+		if v.CopyIdentityFile {
+			if err := d.copyIdentityFile(name, v); err != nil {
+				return fmt.Errorf("volume %s in manifest: %v", name, err)
+			}
+		}
+		d.volumes[name] = v
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return d.persist()
+}
+
+// readManifest reads the volumes manifest at path.
+func readManifest(path string) (map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volumes manifest: %v", err)
+	}
+	var manifest map[string]map[string]string
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid volumes manifest %s: %v", path, err)
+	}
+	return manifest, nil
+}
+
+// manifestNames returns the volume names of a manifest, sorted.
+func manifestNames(manifest map[string]map[string]string) []string {
+	names := make([]string, 0, len(manifest))
+	for name := range manifest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseManifestVolume builds the volume a manifest declares.
+func (d *sshfsDriver) parseManifestVolume(name string, options map[string]string) (*sshfsVolume, error) {
+	if err := validateVolumeName(name); err != nil {
+		return nil, fmt.Errorf("volume %s in manifest: %v", name, err)
+	}
+	v, err := d.parseVolume(options)
+	if err != nil {
+		return nil, fmt.Errorf("volume %s in manifest: %v", name, err)
+	}
+	// These need the remote, which the driver does not reach at startup.
+	for _, option := range []struct {
+		key string
+		set bool
+	}{
+		{"setup_command", v.SetupCommand != ""},
+		{"probe", v.probe},
+		{"verify_sftp", v.VerifySFTP},
+		{"detect_os", v.DetectOS},
+		{"auto_compression", v.AutoCompression},
+	} {
+		if option.set {
+			return nil, fmt.Errorf("volume %s in manifest: %s is only supported by docker volume create", name, option.key)
+		}
+	}
+	if err := d.mountpointCollision(name, v); err != nil {
+		return nil, fmt.Errorf("volume %s in manifest: %v", name, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestVolumesManifest tests reconciling declared volumes at startup
+func TestVolumesManifest(t *testing.T) {
+	const manifest = `{
+		"first": {"sshcmd": "user@host:/first"},
+		"second": {"sshcmd": "user@host:/second", "port": "2222"}
+	}`
+
+	setup := func(t *testing.T, policy string) (string, driverConfig) {
+		t.Helper()
+		tmpDir := t.TempDir()
+		path := filepath.Join(tmpDir, "manifest.json")
+		if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		config := defaultDriverConfig()
+		config.VolumesManifest = path
+		config.ManifestPolicy = policy
+		return tmpDir, config
+	}
+
+	t.Run("creates declared volumes from an empty state", func(t *testing.T) {
+		tmpDir, config := setup(t, "keep")
+
+		driver, err := newSshfsDriverWithConfig(tmpDir, config)
+		if err != nil {
+			t.Fatalf("Failed to create driver: %v", err)
+		}
+		AssertEqual(t, 2, len(driver.volumes), "volumes")
+		AssertEqual(t, "user@host:/first", driver.volumes["first"].Sshcmd, "first sshcmd")
+		AssertEqual(t, "2222", driver.volumes["second"].Port, "second port")
+		AssertEqual(t, 0, driver.volumes["first"].connections, "first connections")
+
+		restarted, err := newSshfsDriverWithConfig(tmpDir, config)
+		if err != nil {
+			t.Fatalf("Failed to restart driver: %v", err)
+		}
+		AssertEqual(t, 2, len(restarted.volumes), "volumes after restart")
+	})
+
+	for _, tt := range []struct {
+		policy   string
+		wantPort string
+	}{
+		{"keep", "22"},
+		{"update", "2222"},
+	} {
+		t.Run("reconciles partial state with policy "+tt.policy, func(t *testing.T) {
+			tmpDir, config := setup(t, tt.policy)
+			config.VolumesManifest = ""
+			driver, err := newSshfsDriverWithConfig(tmpDir, config)
+			if err != nil {
+				t.Fatalf("Failed to create driver: %v", err)
+			}
+			for name, v := range map[string]*sshfsVolume{
+				"second": {Sshcmd: "user@host:/second", Port: "22"},
+				"other":  {Sshcmd: "user@host:/other"},
+			} {
+				driver.volumes[name] = v
+			}
+			if err := driver.saveState(); err != nil {
+				t.Fatalf("Failed to save state: %v", err)
+			}
+
+			config.VolumesManifest = filepath.Join(tmpDir, "manifest.json")
+			driver, err = newSshfsDriverWithConfig(tmpDir, config)
+			if err != nil {
+				t.Fatalf("Failed to restart driver: %v", err)
+			}
+			AssertEqual(t, 3, len(driver.volumes), "volumes")
+			AssertEqual(t, "user@host:/first", driver.volumes["first"].Sshcmd, "created volume")
+			AssertEqual(t, tt.wantPort, driver.volumes["second"].Port, "conflicting volume port")
+			AssertEqual(t, "user@host:/other", driver.volumes["other"].Sshcmd, "undeclared volume")
+		})
+	}
+
+	t.Run("invalid volume keeps the driver from starting", func(t *testing.T) {
+		tmpDir, config := setup(t, "keep")
+		if err := os.WriteFile(config.VolumesManifest, []byte(`{"bad": {"port": "22"}}`), 0o644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+
+		_, err := newSshfsDriverWithConfig(tmpDir, config)
+		if err == nil {
+			t.Fatal("Expected error for an invalid manifest")
+		}
+		AssertContains(t, err.Error(), "volume bad in manifest", "driver error")
+	})
+	t.Run("options that need the remote are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, key := range []string{"setup_command", "probe", "verify_sftp", "detect_os", "auto_compression"} {
+			val := "true"
+			if key == "setup_command" {
+				val = "mkdir -p /data"
+			}
+			_, err := driver.parseManifestVolume("vol", map[string]string{"sshcmd": "user@host:/data", key: val})
+			AssertError(t, err, key)
+			AssertContains(t, err.Error(), key+" is only supported by docker volume create", key+" error")
+		}
+	})
+
+	t.Run("invalid names are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, name := range []string{"../x", "..", "a b"} {
+			_, err := driver.parseManifestVolume(name, map[string]string{"sshcmd": "user@host:/data"})
+			AssertError(t, err, name)
+			AssertContains(t, err.Error(), "invalid volume name", name+" error")
+		}
+	})
+
+	t.Run("mountpoint collision is rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.volumes["other"] = &sshfsVolume{
+			Sshcmd:     "user@host:/other",
+			Mountpoint: driver.mountpointFor(&sshfsVolume{Sshcmd: "user@host:/first"}),
+		}
+
+		_, err := driver.parseManifestVolume("first", map[string]string{"sshcmd": "user@host:/first"})
+		AssertError(t, err, "colliding volume")
+		AssertContains(t, err.Error(), "already used by volume other", "collision error")
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricLabel escapes a Prometheus label value.
+var metricLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// errorRateBuckets is how many slices the error-rate window is split into,
+// which bounds the memory kept per host.
+const errorRateBuckets = 12
+
+// rateBucket counts the mount attempts in one slice of the window.
+type rateBucket struct {
+	start           time.Time
+	total, failures int
+}
+
+// errorRates keeps the outcomes of sshfs mount attempts per host over a
+// sliding window.
+type errorRates struct {
+	mu    sync.Mutex
+	hosts map[string]*[errorRateBuckets]rateBucket
+}
+
+// observe records the outcome of a mount attempt against host.
+func (r *errorRates) observe(host string, now time.Time, window time.Duration, failed bool) {
+	width := window / errorRateBuckets
+	start := now.Truncate(width)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hosts == nil {
+		r.hosts = map[string]*[errorRateBuckets]rateBucket{}
+	}
+	buckets, ok := r.hosts[host]
+	if !ok {
+		buckets = &[errorRateBuckets]rateBucket{}
+		r.hosts[host] = buckets
+	}
+	b := &buckets[start.UnixNano()/int64(width)%errorRateBuckets]
+	if !b.start.Equal(start) {
+		*b = rateBucket{start: start}
+	}
+	b.total++
+	if failed {
+		b.failures++
+	}
+}
+
+// rates returns the share of failed attempts within the window ending at
+// now, per host and over all hosts. Hosts without attempts in the window
+// are left out and forgotten; overall is -1 when there were none at all.
+func (r *errorRates) rates(now time.Time, window time.Duration) (hosts map[string]float64, overall float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hosts = map[string]float64{}
+	sumTotal, sumFailures := 0, 0
+	for host, buckets := range r.hosts {
+		total, failures := 0, 0
+		for _, b := range buckets {
+			if b.total > 0 && now.Sub(b.start) < window {
+				total += b.total
+				failures += b.failures
+			}
+		}
+		if total == 0 {
+			delete(r.hosts, host)
+			continue
+		}
+		hosts[host] = float64(failures) / float64(total)
+		sumTotal += total
+		sumFailures += failures
+	}
+	if sumTotal == 0 {
+		return hosts, -1
+	}
+	return hosts, float64(sumFailures) / float64(sumTotal)
+}
+
+// writeMetrics writes the driver's metrics in the Prometheus text format.
+func (d *sshfsDriver) writeMetrics(w io.Writer) {
+	d.RLock()
+	defer d.RUnlock()
+
+	names := make([]string, 0, len(d.volumes))
+	for name := range d.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP sshfs_remounts_total Remounts of degraded mounts, by the host whose connection died.")
+	fmt.Fprintln(w, "# TYPE sshfs_remounts_total counter")
+	for _, name := range names {
+		v := d.volumes[name]
+		hosts := make([]string, 0, len(v.remounts))
+		for host := range v.remounts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			fmt.Fprintf(w, "sshfs_remounts_total{volume=\"%s\",host=\"%s\"} %d\n",
+				metricLabel.Replace(name), metricLabel.Replace(host), v.remounts[host])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP sshfs_low_space Whether the remote of a volume with a free-space threshold was below it when last checked.")
+	fmt.Fprintln(w, "# TYPE sshfs_low_space gauge")
+	for _, name := range names {
+		v := d.volumes[name]
+		if v.MinFreeBytes == 0 && v.MinFreePercent == 0 {
+			continue
+		}
+		low := 0
+		if v.lowSpace {
+			low = 1
+		}
+		fmt.Fprintf(w, "sshfs_low_space{volume=\"%s\"} %d\n", metricLabel.Replace(name), low)
+	}
+
+	rates, overall := d.mountErrors.rates(d.now(), d.config.MountErrorWindow)
+	hosts := make([]string, 0, len(rates))
+	for host := range rates {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	fmt.Fprintf(w, "# HELP sshfs_mount_error_rate Share of failed sshfs mount attempts over the last %v, by host.\n", d.config.MountErrorWindow)
+	fmt.Fprintln(w, "# TYPE sshfs_mount_error_rate gauge")
+	for _, host := range hosts {
+		fmt.Fprintf(w, "sshfs_mount_error_rate{host=\"%s\"} %g\n", metricLabel.Replace(host), rates[host])
+	}
+	fmt.Fprintf(w, "# HELP sshfs_mount_error_rate_overall Share of failed sshfs mount attempts over the last %v.\n", d.config.MountErrorWindow)
+	fmt.Fprintln(w, "# TYPE sshfs_mount_error_rate_overall gauge")
+	if overall >= 0 {
+		fmt.Fprintf(w, "sshfs_mount_error_rate_overall %g\n", overall)
+	}
+}
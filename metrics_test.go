@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestErrorRates tests the sliding-window mount error rates
+func TestErrorRates(t *testing.T) {
+	const window = time.Minute
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var rates errorRates
+
+	// One attempt every 10s for a minute: first host fails every third,
+	// second host never.
+	for i := 0; i < 6; i++ {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		rates.observe("first", now, window, i%3 == 0)
+		rates.observe("second", now, window, false)
+	}
+
+	hosts, overall := rates.rates(start.Add(55*time.Second), window)
+	AssertEqual(t, 2.0/6, hosts["first"], "first host rate")
+	AssertEqual(t, 0.0, hosts["second"], "second host rate")
+	AssertEqual(t, 2.0/12, overall, "overall rate")
+
+	// Half a window later the first half of the attempts, with one of the
+	// failures, has slid out.
+	hosts, overall = rates.rates(start.Add(85*time.Second), window)
+	AssertEqual(t, 1.0/3, hosts["first"], "first host rate later")
+	AssertEqual(t, 1.0/6, overall, "overall rate later")
+
+	// A failure long after replaces the stale bucket it lands in.
+	rates.observe("second", start.Add(10*time.Minute), window, true)
+	hosts, overall = rates.rates(start.Add(10*time.Minute), window)
+	AssertEqual(t, 1.0, hosts["second"], "second host rate after failure")
+	if _, ok := hosts["first"]; ok {
+		t.Error("Expected the idle host to be left out")
+	}
+	AssertEqual(t, 1.0, overall, "overall rate after failure")
+
+	_, overall = rates.rates(start.Add(time.Hour), window)
+	AssertEqual(t, -1.0, overall, "overall rate without attempts")
+	AssertEqual(t, 0, len(rates.hosts), "hosts kept without attempts")
+}
+
+// TestMountErrorRateMetrics tests that mount outcomes reach the metrics
+func TestMountErrorRateMetrics(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	driver.now = func() time.Time { return clock }
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse([]byte("connection refused"), fmt.Errorf("exit status 1"))
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err == nil {
+		t.Fatal("Expected the first mount to fail")
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	AssertContains(t, rec.Body.String(), "# TYPE sshfs_mount_error_rate gauge", "metrics")
+	AssertContains(t, rec.Body.String(), `sshfs_mount_error_rate{host="user@host"} 0.5`, "metrics")
+	AssertContains(t, rec.Body.String(), "sshfs_mount_error_rate_overall 0.5", "metrics")
+
+	// The window follows the driver's clock.
+	clock = clock.Add(driver.config.MountErrorWindow + time.Second)
+	rec = httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	AssertNotContains(t, rec.Body.String(), "sshfs_mount_error_rate_overall 0.5", "metrics after the window")
+}
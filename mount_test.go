@@ -166,6 +166,7 @@ func TestMountUnmount(t *testing.T) {
 	t.Run("unmount non-existent volume fails", func(t *testing.T) {
 		driver, tmpDir := setupTestDriver(t)
 		defer cleanupTestDriver(tmpDir)
+		driver.config.UnknownUnmountPolicy = "error"
 
 		req := &volume.UnmountRequest{
 			Name: "non-existent",
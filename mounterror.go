@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fuseOptionDenied matches fusermount refusing allow_other or allow_root
+// because the host's /etc/fuse.conf does not permit them.
+var fuseOptionDenied = regexp.MustCompile(`option (allow_other|allow_root) only allowed if 'user_allow_other' is set`)
+
+// authDenied matches ssh rejecting the volume's credentials, which it
+// reports with the authentication methods the server offered.
+var authDenied = regexp.MustCompile(`Permission denied \([^)]*\)`)
+
+// remoteDenied matches sshfs, logged in, failing to open the remote path:
+// it reports the host and path followed by the error.
+var remoteDenied = regexp.MustCompile(`(?m)^(.+): Permission denied\r?$`)
+
+// AllowOtherError is returned when a mount fails because the host does not
+// let FUSE mounts be opened to other users.
+type AllowOtherError struct {
+	Option string
+	Output string
+}
+
+func (e *AllowOtherError) Error() string {
+	return fmt.Sprintf("the host does not allow FUSE mounts with %s: add a line user_allow_other to /etc/fuse.conf on the Docker host, or create the volume without %s (%s)",
+		e.Option, e.Option, e.Output)
+}
+
+// AuthError is returned when a mount fails because the server rejected the
+// volume's credentials.
+type AuthError struct {
+	Output string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("the server rejected the credentials: check the user, key or password of the volume (%s)", e.Output)
+}
+
+// RemotePermissionError is returned when a mount fails because the remote
+// user, though logged in, may not access the remote path.
+type RemotePermissionError struct {
+	Path   string
+	Output string
+}
+
+func (e *RemotePermissionError) Error() string {
+	return fmt.Sprintf("logged in, but the remote user may not access %s: check its permissions on the server (%s)", e.Path, e.Output)
+}
+
+// classifyMountError returns a typed error for sshfs failures that have a
+// known remedy, or nil.
+func classifyMountError(output []byte) error {
+	if m := fuseOptionDenied.FindSubmatch(output); m != nil {
+		return &AllowOtherError{Option: string(m[1]), Output: strings.TrimSpace(string(output))}
+	}
+	if authDenied.Match(output) {
+		return &AuthError{Output: strings.TrimSpace(string(output))}
+	}
+	if m := remoteDenied.FindSubmatch(output); m != nil {
+		return &RemotePermissionError{Path: string(m[1]), Output: strings.TrimSpace(string(output))}
+	}
+	return nil
+}
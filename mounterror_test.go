@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestClassifyMountError tests translating known sshfs failures
+func TestClassifyMountError(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		wantOption string
+	}{
+		{"fusermount allow_other", "fusermount: option allow_other only allowed if 'user_allow_other' is set in /etc/fuse.conf", "allow_other"},
+		{"fusermount3 allow_root", "fusermount3: option allow_root only allowed if 'user_allow_other' is set in /etc/fuse.conf\n", "allow_root"},
+		{"unrelated failure", "read: Connection reset by peer", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyMountError([]byte(tt.output))
+			if tt.wantOption == "" {
+				if err != nil {
+					t.Fatalf("Expected no typed error, got %v", err)
+				}
+				return
+			}
+			var allowErr *AllowOtherError
+			if !errors.As(err, &allowErr) {
+				t.Fatalf("Expected an AllowOtherError, got %v", err)
+			}
+			AssertEqual(t, tt.wantOption, allowErr.Option, "option")
+			AssertContains(t, err.Error(), "add a line user_allow_other to /etc/fuse.conf", "message")
+		})
+	}
+
+	t.Run("auth and remote permission", func(t *testing.T) {
+		authOutputs := []string{
+			"user@host: Permission denied (publickey).\nread: Connection reset by peer\n",
+			"user@host: Permission denied (publickey,keyboard-interactive,password).\r\nremote host has disconnected\n",
+		}
+		for _, output := range authOutputs {
+			err := classifyMountError([]byte(output))
+			var authErr *AuthError
+			if !errors.As(err, &authErr) {
+				t.Fatalf("Expected an AuthError for %q, got %v", output, err)
+			}
+			AssertContains(t, err.Error(), "the server rejected the credentials", "message")
+		}
+
+		remoteOutputs := map[string]string{
+			"host:/srv/private: Permission denied\n":         "host:/srv/private",
+			"[::1]:/data: Permission denied\r\n":             "[::1]:/data",
+			"host:/home/app/with space: Permission denied\n": "host:/home/app/with space",
+		}
+		for output, path := range remoteOutputs {
+			err := classifyMountError([]byte(output))
+			var permErr *RemotePermissionError
+			if !errors.As(err, &permErr) {
+				t.Fatalf("Expected a RemotePermissionError for %q, got %v", output, err)
+			}
+			AssertEqual(t, path, permErr.Path, "path")
+			AssertContains(t, err.Error(), "logged in, but the remote user may not access "+path, "message")
+			var authErr *AuthError
+			AssertEqual(t, false, errors.As(err, &authErr), "classified as auth failure")
+		}
+	})
+
+	t.Run("returned by Mount", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte(tests[0].output), fmt.Errorf("exit status 1"))
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "allow_other": ""},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		_, err = driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		var allowErr *AllowOtherError
+		if !errors.As(err, &allowErr) {
+			t.Fatalf("Expected an AllowOtherError, got %v", err)
+		}
+		AssertEqual(t, "allow_other", allowErr.Option, "option")
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"syscall"
+)
+
+// nsfsMagic is the filesystem type of namespace files, such as
+// /proc/<pid>/ns/mnt or a bind mount of one.
+const nsfsMagic = 0x6e736673
+
+// procNamespace matches the mount namespace file of a process.
+var procNamespace = regexp.MustCompile(`^/proc/([0-9]+)/ns/mnt$`)
+
+// useMountNamespace makes mounts and unmounts run in the mount namespace
+// at path, through nsenter, as rootless Docker needs when its daemon runs
+// in a namespace of its own. When path cannot be used the driver keeps
+// mounting in its own namespace and the error says why.
+func (d *sshfsDriver) useMountNamespace(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := checkMountNamespace(path); err != nil {
+		return fmt.Errorf("mount namespace %s is unavailable, mounting in the plugin's own namespace: %v", path, err)
+	}
+	if _, err := d.lookPath("nsenter"); err != nil {
+		return fmt.Errorf("mount namespace %s is unavailable, mounting in the plugin's own namespace: nsenter is not installed", path)
+	}
+	d.mountNamespace = path
+	// Mounts in the namespace only show in its own mount table.
+	if m := procNamespace.FindStringSubmatch(path); m != nil {
+		d.mountsPath = filepath.Join("/proc", m[1], "mounts")
+	}
+	return nil
+}
+
+// checkMountNamespace checks that path is a namespace file.
+func checkMountNamespace(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("not an absolute path")
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return err
+	}
+	if fs.Type != nsfsMagic {
+		return fmt.Errorf("not a namespace file")
+	}
+	return nil
+}
+
+// inMountNamespace returns cmd wrapped in nsenter when the driver mounts in
+// another mount namespace, and cmd itself otherwise. The wrapped command is
+// looked up in that namespace's filesystem.
+func (d *sshfsDriver) inMountNamespace(cmd *exec.Cmd) *exec.Cmd {
+	if d.mountNamespace == "" {
+		return cmd
+	}
+	wrapped := exec.Command("nsenter", append([]string{"--mount=" + d.mountNamespace, "--"}, cmd.Args...)...)
+	wrapped.Env = cmd.Env
+	wrapped.Dir = cmd.Dir
+	wrapped.Stdin = cmd.Stdin
+	return wrapped
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestMountNamespace tests that mounts and unmounts enter the configured
+// mount namespace, and that an unusable one is refused
+func TestMountNamespace(t *testing.T) {
+	withNsenter := func(name string) (string, error) { return "/usr/bin/" + name, nil }
+
+	t.Run("mount and unmount enter the namespace", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.lookPath = withNsenter
+
+		ns := fmt.Sprintf("/proc/%d/ns/mnt", os.Getpid())
+		if err := driver.useMountNamespace(ns); err != nil {
+			t.Fatalf("Failed to use mount namespace: %v", err)
+		}
+		AssertEqual(t, fmt.Sprintf("/proc/%d/mounts", os.Getpid()), driver.mountsPath, "mount table")
+		AssertEqual(t, true, driver.features().MountNamespace, "mount_namespace feature")
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to unmount volume: %v", err)
+		}
+
+		mountpoint := driver.volumes["test-volume"].Mountpoint
+		commands := executor.GetCommands()
+		AssertEqual(t, 2, len(commands), "commands run")
+		AssertContains(t, strings.Join(commands[0], " "), "nsenter --mount="+ns+" -- sshfs -oStrictHostKeyChecking=no user@host:/path "+mountpoint, "mount command")
+		AssertEqual(t, "nsenter --mount="+ns+" -- "+driver.unmountTool, strings.Join(commands[1][:4], " "), "unmount command")
+		AssertEqual(t, mountpoint, commands[1][len(commands[1])-1], "unmount target")
+	})
+
+	notNamespace := func(t *testing.T, tmpDir string) string {
+		path := filepath.Join(tmpDir, "mnt")
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		return path
+	}
+	for _, tt := range []struct {
+		name     string
+		path     func(t *testing.T, tmpDir string) string
+		lookPath func(string) (string, error)
+		wantErr  string
+	}{
+		{"relative path", func(*testing.T, string) string { return "proc/1/ns/mnt" }, withNsenter, "not an absolute path"},
+		{"missing file", func(_ *testing.T, tmpDir string) string { return filepath.Join(tmpDir, "missing") }, withNsenter, "no such file"},
+		{"not a namespace", notNamespace, withNsenter, "not a namespace file"},
+		{"nsenter missing", func(*testing.T, string) string { return "/proc/self/ns/mnt" }, func(string) (string, error) { return "", exec.ErrNotFound }, "nsenter is not installed"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.lookPath = tt.lookPath
+			mountsPath := driver.mountsPath
+
+			err := driver.useMountNamespace(tt.path(t, tmpDir))
+			AssertError(t, err, "use mount namespace")
+			AssertContains(t, err.Error(), "mounting in the plugin's own namespace", "error")
+			AssertContains(t, err.Error(), tt.wantErr, "error")
+			AssertEqual(t, "", driver.mountNamespace, "mount namespace")
+			AssertEqual(t, mountsPath, driver.mountsPath, "mount table")
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+			AssertEqual(t, "sshfs", executor.LastCmd().Args[0], "mount command")
+		})
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pathOptions are the ssh options whose values are local file paths.
+var pathOptions = []string{
+	"IdentityFile",
+	"CertificateFile",
+	"UserKnownHostsFile",
+	"GlobalKnownHostsFile",
+}
+
+func isPathOption(key string) bool {
+	return containsFold(pathOptions, key)
+}
+
+// expandOption expands the value of a "key=value" option when key names a
+// file-path option, and returns every other option unchanged.
+func (d *sshfsDriver) expandOption(option string) (string, error) {
+	key, val, ok := strings.Cut(option, "=")
+	if !ok || !isPathOption(key) {
+		return option, nil
+	}
+	p, err := d.expandPath(val)
+	if err != nil {
+		return "", err
+	}
+	return key + "=" + p, nil
+}
+
+// expandPath resolves a leading "~" to the configured home directory and
+// $VAR references to their environment values. When a path base is
+// configured the result must not escape it; files the driver manages
+// itself are always allowed.
+func (d *sshfsDriver) expandPath(p string) (string, error) {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		p = d.config.HomeDir + p[1:]
+	}
+	p = os.ExpandEnv(p)
+
+	if d.config.PathBase == "" || isWithin(d.keysDir, p) || p == d.knownHostsPath {
+		return p, nil
+	}
+	if !filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %s must be absolute", p)
+	}
+	p = filepath.Clean(p)
+	if !isWithin(d.config.PathBase, p) {
+		return "", fmt.Errorf("path %s is outside of %s", p, d.config.PathBase)
+	}
+	return p, nil
+}
+
+// isWithin reports whether p is base itself or lies below it.
+func isWithin(base, p string) bool {
+	rel, err := filepath.Rel(filepath.Clean(base), filepath.Clean(p))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// checkOptionPolicy rejects a pass-through option key that the configured
+// deny-list names or the allow-list, when set, does not.
+func (d *sshfsDriver) checkOptionPolicy(key string) error {
+	if containsFold(d.config.DeniedOptions, key) {
+		return fmt.Errorf("option %s is not allowed", key)
+	}
+	if len(d.config.AllowedOptions) > 0 && !containsFold(d.config.AllowedOptions, key) {
+		return fmt.Errorf("option %s is not allowed", key)
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseList parses a comma-separated setting, dropping empty entries.
+func parseList(val string) []string {
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// noCacheOptions turn off the sshfs cache of attributes and directory
+// listings as well as the kernel's attribute and lookup caches.
+var noCacheOptions = []string{"cache=no", "attr_timeout=0", "entry_timeout=0"}
+
+// cacheOptions are the sshfs and FUSE options that tune caching, which
+// no_cache would override.
+var cacheOptions = []string{
+	"cache", "dir_cache", "cache_timeout", "dcache_timeout",
+	"cache_stat_timeout", "cache_dir_timeout", "cache_link_timeout",
+	"dcache_stat_timeout", "dcache_dir_timeout", "dcache_link_timeout",
+	"cache_max_size", "dcache_max_size", "cache_clean_interval", "dcache_clean_interval",
+	"cache_min_clean_interval", "dcache_min_clean_interval",
+	"attr_timeout", "entry_timeout", "negative_timeout", "kernel_cache", "auto_cache",
+}
+
+// maxCacheTimeout bounds the cache timeout options, in seconds. Longer
+// timeouts would hide changes on the server for more than a day.
+const maxCacheTimeout = 86400
+
+// parseCacheTimeout validates entry_timeout and attr_timeout, which FUSE
+// takes in fractional seconds, and dcache_timeout, which sshfs takes in
+// whole seconds.
+func parseCacheTimeout(key, val string) error {
+	if key == "dcache_timeout" {
+		if n, err := strconv.Atoi(val); err != nil || n < 0 || n > maxCacheTimeout {
+			return fmt.Errorf("invalid value %q for option %s, expected whole seconds from 0 to %d", val, key, maxCacheTimeout)
+		}
+		return nil
+	}
+	if f, err := strconv.ParseFloat(val, 64); err != nil || !(f >= 0 && f <= maxCacheTimeout) {
+		return fmt.Errorf("invalid value %q for option %s, expected seconds from 0 to %d", val, key, maxCacheTimeout)
+	}
+	return nil
+}
+
+// cacheTimeouts returns the volume's cache timeout options as sshfs takes
+// them.
+func cacheTimeouts(v *sshfsVolume) []string {
+	var options []string
+	for _, t := range []struct{ key, val string }{
+		{"entry_timeout", v.EntryTimeout},
+		{"attr_timeout", v.AttrTimeout},
+		{"dcache_timeout", v.DcacheTimeout},
+	} {
+		if t.val != "" {
+			options = append(options, t.key+"="+t.val)
+		}
+	}
+	return options
+}
+
+// parseBoolOption parses a flag-style option, where an empty value means
+// the flag is set.
+func parseBoolOption(key, val string) (bool, error) {
+	if val == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for option %s", val, key)
+	}
+	return b, nil
+}
+
+// parseCountOption validates an option that takes a non-negative integer.
+func parseCountOption(key, val string) error {
+	if n, err := strconv.Atoi(val); err != nil || n < 0 {
+		return fmt.Errorf("invalid value %q for option %s, expected a non-negative integer", val, key)
+	}
+	return nil
+}
+
+// checkCompressionOption validates a compression option. ssh only accepts
+// yes and no: compression levels went away with protocol 1.
+func checkCompressionOption(key, val string) error {
+	if val != "yes" && val != "no" {
+		return fmt.Errorf("invalid value %q for option %s, expected yes or no", val, key)
+	}
+	return nil
+}
+
+// parseFileMode parses an octal permission mode such as "0775".
+func parseFileMode(key, val string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid octal mode %q for %s", val, key)
+	}
+	return os.FileMode(mode), nil
+}
+
+// parseIDOption parses a numeric user or group ID option.
+func parseIDOption(key, val string) (int, error) {
+	id, err := strconv.Atoi(val)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid value %q for option %s, expected a numeric ID", val, key)
+	}
+	return id, nil
+}
+
+// mountpointOwner returns the owner to give the volume's mountpoint
+// directory, -1 where it keeps the driver's.
+func mountpointOwner(v *sshfsVolume) (uid, gid int) {
+	uid, gid = -1, -1
+	if v.MountpointUID != "" {
+		uid, _ = strconv.Atoi(v.MountpointUID)
+	}
+	if v.MountpointGID != "" {
+		gid, _ = strconv.Atoi(v.MountpointGID)
+	}
+	return uid, gid
+}
+
+// checkChown reports whether the driver lacks the permission to give files
+// to uid and gid, -1 leaving either unchanged. Only root may give files
+// away; other users may only pick a group they belong to.
+func checkChown(uid, gid int) error {
+	euid := os.Geteuid()
+	if euid == 0 {
+		return nil
+	}
+	if uid != -1 && uid != euid {
+		return fmt.Errorf("the driver runs as user %d and cannot give the mountpoint to user %d", euid, uid)
+	}
+	if gid == -1 || gid == os.Getegid() {
+		return nil
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if g == gid {
+			return nil
+		}
+	}
+	return fmt.Errorf("the driver runs as user %d and cannot give the mountpoint to group %d", euid, gid)
+}
+
+// keepaliveOptions returns the volume's options with its ServerAlive
+// settings merged in, replacing the same settings given as plain options.
+// When keepalives are tuned for a mount, reconnect is added as well, so a
+// connection they find dead is re-established instead of failing the mount.
+func keepaliveOptions(v *sshfsVolume, reconnect bool) []string {
+	if v.ServerAliveInterval == "" && v.ServerAliveCountMax == "" {
+		return v.Options
+	}
+	settings := map[string]string{
+		"ServerAliveInterval": v.ServerAliveInterval,
+		"ServerAliveCountMax": v.ServerAliveCountMax,
+	}
+
+	options := make([]string, 0, len(v.Options)+3)
+	hasReconnect := false
+	for _, option := range v.Options {
+		key, _, _ := strings.Cut(option, "=")
+		if setting, ok := keepaliveSetting(settings, key); ok && setting != "" {
+			continue
+		}
+		if key == "reconnect" {
+			hasReconnect = true
+		}
+		options = append(options, option)
+	}
+	for _, key := range []string{"ServerAliveInterval", "ServerAliveCountMax"} {
+		if settings[key] != "" {
+			options = append(options, key+"="+settings[key])
+		}
+	}
+	if reconnect && !hasReconnect {
+		options = append(options, "reconnect")
+	}
+	return options
+}
+
+func keepaliveSetting(settings map[string]string, key string) (string, bool) {
+	for k, setting := range settings {
+		if strings.EqualFold(k, key) {
+			return setting, true
+		}
+	}
+	return "", false
+}
+
+// sanitizeMountLabel makes a label safe to use as the fsname of a mount:
+// characters that would break the mount options or the mount table, such as
+// commas and whitespace, are replaced by underscores.
+func sanitizeMountLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("._-:@/", r):
+			return r
+		}
+		return '_'
+	}, label)
+}
+
+// checkSSHCommand validates an ssh_command option. sshfs splits it into
+// words itself, honouring quotes, but a line break could not be passed on.
+func checkSSHCommand(val string) error {
+	if strings.TrimSpace(val) == "" {
+		return fmt.Errorf("option ssh_command must not be empty")
+	}
+	if strings.ContainsAny(val, "\x00\r\n") {
+		return fmt.Errorf("option ssh_command must be a single line")
+	}
+	return nil
+}
+
+// escapeOptionValue escapes the commas and backslashes of an -o value, so
+// that sshfs takes it as one value instead of splitting it into further
+// mount options.
+func escapeOptionValue(val string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace(val)
+}
+
+// volumeKeysDir returns the directory copy_identity_file copies the
+// volume's key into, refusing a name that would resolve to keysDir itself
+// or outside it.
+func (d *sshfsDriver) volumeKeysDir(name string) (string, error) {
+	dir := filepath.Join(d.keysDir, filepath.Base(name))
+	if filepath.Dir(dir) != filepath.Clean(d.keysDir) {
+		return "", fmt.Errorf("volume name %q cannot hold a key directory", name)
+	}
+	return dir, nil
+}
+
+// removeVolumeKeys removes the key directory of a volume that set
+// copy_identity_file and returns the bytes reclaimed.
+func (d *sshfsDriver) removeVolumeKeys(name string, v *sshfsVolume) (int64, error) {
+	if !v.CopyIdentityFile {
+		return 0, nil
+	}
+	dir, err := d.volumeKeysDir(name)
+	if err != nil {
+		return 0, err
+	}
+	size := dirSize(dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// copyIdentityFile copies the volume's IdentityFile into the driver's key
+// directory and points the option at the copy, so mounts no longer depend
+// on the original host path.
+func (d *sshfsDriver) copyIdentityFile(name string, v *sshfsVolume) error {
+	for i, option := range v.Options {
+		key, val, _ := strings.Cut(option, "=")
+		if !strings.EqualFold(key, "IdentityFile") {
+			continue
+		}
+
+		src, err := d.expandPath(val)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return fmt.Errorf("failed to read identity file: %v", err)
+		}
+
+		dir, err := d.volumeKeysDir(name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.Base(src))
+		if err := os.WriteFile(dst, data, 0o600); err != nil {
+			return err
+		}
+		// WriteFile keeps the mode of an existing file, so enforce it.
+		if err := os.Chmod(dst, 0o600); err != nil {
+			return err
+		}
+
+		v.Options[i] = key + "=" + dst
+		v.IdentitySource = val
+		return nil
+	}
+	return fmt.Errorf("copy_identity_file requires an IdentityFile option")
+}
+
+// sameDefinition reports whether two volumes were created from the same
+// options. Mountpoint and runtime state are ignored, and a copied identity
+// file is compared by the path it was copied from.
+func sameDefinition(a, b *sshfsVolume) bool {
+	return reflect.DeepEqual(definition(a), definition(b))
+}
+
+// restoreSecrets fills in the secrets the state file does not keep, the
+// password of a no_persist_password volume and the values of secret_env,
+// from another definition of the same volume that still holds them.
+// Secrets v already holds are left alone.
+func restoreSecrets(v, from *sshfsVolume) {
+	if v.NoPersistPassword && v.Password == "" {
+		v.Password = from.Password
+	}
+	if len(v.SecretEnv) == 0 {
+		return
+	}
+	values := map[string]string{}
+	for _, pair := range from.SecretEnv {
+		if name, _, ok := strings.Cut(pair, "="); ok {
+			values[name] = pair
+		}
+	}
+	restored := make([]string, len(v.SecretEnv))
+	for i, pair := range v.SecretEnv {
+		if full, ok := values[pair]; ok {
+			pair = full
+		}
+		restored[i] = pair
+	}
+	v.SecretEnv = restored
+}
+
+func definition(v *sshfsVolume) sshfsVolume {
+	def := sshfsVolume{
+		Password:            v.Password,
+		PasswordFile:        v.PasswordFile,
+		NoPersistPassword:   v.NoPersistPassword,
+		Sshcmd:              v.Sshcmd,
+		Port:                v.Port,
+		SSHConfig:           v.SSHConfig,
+		CopyIdentityFile:    v.CopyIdentityFile,
+		MountpointMode:      v.MountpointMode,
+		MountpointUID:       v.MountpointUID,
+		MountpointGID:       v.MountpointGID,
+		Umask:               v.Umask,
+		ServerAliveInterval: v.ServerAliveInterval,
+		ServerAliveCountMax: v.ServerAliveCountMax,
+		Sync:                v.Sync,
+		NoCache:             v.NoCache,
+		EntryTimeout:        v.EntryTimeout,
+		AttrTimeout:         v.AttrTimeout,
+		DcacheTimeout:       v.DcacheTimeout,
+		CleanMountpoint:     v.CleanMountpoint,
+		KeepMountpoint:      v.KeepMountpoint,
+		VerifyWritable:      v.VerifyWritable,
+		VerifySFTP:          v.VerifySFTP,
+		DetectOS:            v.DetectOS,
+		AutoCompression:     v.AutoCompression,
+		MountLabel:          v.MountLabel,
+		SetupCommand:        v.SetupCommand,
+		SSHCommand:          v.SSHCommand,
+		Env:                 sortedCopy(v.Env),
+		SecretEnv:           sortedCopy(v.SecretEnv),
+		MinFreeBytes:        v.MinFreeBytes,
+		MinFreePercent:      v.MinFreePercent,
+		FallbackHosts:       v.FallbackHosts,
+		Labels:              v.Labels,
+		HostCredentials:     v.HostCredentials,
+		Options:             append([]string(nil), v.Options...),
+	}
+	for i, option := range def.Options {
+		key, _, _ := strings.Cut(option, "=")
+		if v.IdentitySource != "" && strings.EqualFold(key, "IdentityFile") {
+			def.Options[i] = key + "=" + v.IdentitySource
+		}
+	}
+	sort.Strings(def.Options)
+	return def
+}
+
+func sortedCopy(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	list = append([]string(nil), list...)
+	sort.Strings(list)
+	return list
+}
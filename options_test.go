@@ -0,0 +1,440 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestExpandPath tests tilde and environment expansion of file-path options
+func TestExpandPath(t *testing.T) {
+	t.Run("tilde expands to configured home", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.HomeDir = "/home/sshfs"
+
+		option, err := driver.expandOption("IdentityFile=~/.ssh/id_rsa")
+		if err != nil {
+			t.Fatalf("Failed to expand option: %v", err)
+		}
+
+		if option != "IdentityFile=/home/sshfs/.ssh/id_rsa" {
+			t.Errorf("Expected IdentityFile=/home/sshfs/.ssh/id_rsa, got %s", option)
+		}
+	})
+
+	t.Run("environment variable is expanded", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		t.Setenv("SSH_KEY_DIR", "/keys")
+
+		option, err := driver.expandOption("UserKnownHostsFile=$SSH_KEY_DIR/known_hosts")
+		if err != nil {
+			t.Fatalf("Failed to expand option: %v", err)
+		}
+
+		if option != "UserKnownHostsFile=/keys/known_hosts" {
+			t.Errorf("Expected UserKnownHostsFile=/keys/known_hosts, got %s", option)
+		}
+	})
+
+	t.Run("non-path options are left alone", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		t.Setenv("SSH_KEY_DIR", "/keys")
+
+		option, err := driver.expandOption("Ciphers=$SSH_KEY_DIR")
+		if err != nil {
+			t.Fatalf("Failed to expand option: %v", err)
+		}
+
+		if option != "Ciphers=$SSH_KEY_DIR" {
+			t.Errorf("Expected option to be unchanged, got %s", option)
+		}
+	})
+
+	t.Run("path inside base is allowed", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.HomeDir = filepath.Join(tmpDir, "home")
+		driver.config.PathBase = tmpDir
+
+		p, err := driver.expandPath("~/.ssh/id_rsa")
+		if err != nil {
+			t.Fatalf("Failed to expand path: %v", err)
+		}
+
+		if p != filepath.Join(tmpDir, "home", ".ssh", "id_rsa") {
+			t.Errorf("Expected path inside base, got %s", p)
+		}
+	})
+
+	t.Run("path escaping base is rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.HomeDir = filepath.Join(tmpDir, "home")
+		driver.config.PathBase = filepath.Join(tmpDir, "home")
+
+		if _, err := driver.expandOption("IdentityFile=~/../../etc/shadow"); err == nil {
+			t.Fatal("Expected error when path escapes the base directory")
+		}
+	})
+}
+
+// TestOptionPolicy tests the allow- and deny-lists of option keys
+func TestOptionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		option  string
+		wantErr bool
+	}{
+		{"no policy", nil, nil, "allow_other", false},
+		{"allowed option", []string{"reconnect", "IdentityFile"}, nil, "identityfile", false},
+		{"option outside the allow-list", []string{"reconnect"}, nil, "allow_other", true},
+		{"denied option", nil, []string{"ProxyCommand"}, "ProxyCommand", true},
+		{"deny wins over allow", []string{"allow_other"}, []string{"allow_other"}, "allow_other", true},
+		{"reserved key bypasses the lists", []string{"reconnect"}, []string{"port"}, "port", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.config.AllowedOptions = tt.allowed
+			driver.config.DeniedOptions = tt.denied
+
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", tt.option: "2222"},
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected option %s to be rejected", tt.option)
+				}
+				AssertContains(t, err.Error(), "option "+tt.option+" is not allowed", "create error")
+			} else if err != nil {
+				t.Fatalf("Expected option %s to be accepted, got %v", tt.option, err)
+			}
+		})
+	}
+}
+
+// TestAllowedRemotePaths tests the per-host remote path allow-list
+func TestAllowedRemotePaths(t *testing.T) {
+	policy, err := parseRemotePaths("/srv/shared, nas1:/data, User@[::1]:/exports")
+	AssertNoError(t, err, "parse")
+
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr string
+	}{
+		{"path under a global prefix", map[string]string{"sshcmd": "user@host:/srv/shared/app"}, ""},
+		{"path under a host prefix", map[string]string{"sshcmd": "user@NAS1:/data/app/"}, ""},
+		{"ipv6 host", map[string]string{"sshcmd": "[::1]:/exports"}, ""},
+		{"prefix itself", map[string]string{"sshcmd": "nas1:/data"}, ""},
+		{"path outside the prefixes", map[string]string{"sshcmd": "nas1:/etc"}, "remote path /etc is not allowed on host nas1, only paths below /data, /srv/shared"},
+		{"prefix of another directory name", map[string]string{"sshcmd": "nas1:/database"}, "not allowed"},
+		{"escape with dot-dot", map[string]string{"sshcmd": "nas1:/data/../etc"}, "not allowed"},
+		{"prefix of another host", map[string]string{"sshcmd": "host:/data"}, "not allowed"},
+		{"home-relative path", map[string]string{"sshcmd": "nas1:"}, "must be absolute"},
+		{"fallback host is checked too", map[string]string{"sshcmd": "nas1:/data", "fallback_hosts": "nas2"}, "remote path /data is not allowed on host nas2"},
+		{"HostName redirect", map[string]string{"sshcmd": "nas1:/data", "HostName": "evil"}, "option HostName is not allowed"},
+		{"ProxyCommand redirect", map[string]string{"sshcmd": "nas1:/data", "proxycommand": "ssh evil -W evil:22"}, "option ProxyCommand is not allowed"},
+		{"ProxyJump redirect", map[string]string{"sshcmd": "nas1:/data", "ProxyJump": "evil"}, "option ProxyJump is not allowed"},
+		{"ssh_config alias", map[string]string{"sshcmd": "nas1:/data", "ssh_config": "/etc/ssh/ssh_config"}, "option ssh_config is not allowed"},
+		{"ssh_command", map[string]string{"sshcmd": "nas1:/data", "ssh_command": "ssh -oHostName=evil"}, "option ssh_command is not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.config.AllowedRemotePaths = policy
+
+			err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: tt.options})
+			if tt.wantErr == "" {
+				AssertNoError(t, err, "create")
+				return
+			}
+			AssertError(t, err, "create")
+			AssertContains(t, err.Error(), tt.wantErr, "create error")
+			AssertFileNotExists(t, driver.statePath)
+		})
+	}
+
+	t.Run("proxy options of the driver are allowed", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.AllowedRemotePaths = policy
+		driver.lookPath = func(name string) (string, error) { return "/usr/bin/" + name, nil }
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "nas1:/data", "socks_proxy": "socks5://proxy:1080"}})
+		AssertNoError(t, err, "create")
+	})
+
+	t.Run("no policy allows every path", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "host:/etc"}})
+		AssertNoError(t, err, "create")
+	})
+
+	for _, val := range []string{"data", "nas1:data", ":/data"} {
+		if _, err := parseRemotePaths(val); err == nil {
+			t.Errorf("Expected %q to be rejected", val)
+		}
+	}
+}
+
+// TestCompressionOption tests per-volume compression and the driver default
+func TestCompressionOption(t *testing.T) {
+	mountArgs := func(t *testing.T, driver *sshfsDriver, options map[string]string) string {
+		t.Helper()
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		options["sshcmd"] = "user@host:/path"
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		return strings.Join(executor.LastCmd().Args, " ")
+	}
+
+	t.Run("explicit enable and disable", func(t *testing.T) {
+		for _, val := range []string{"yes", "no"} {
+			driver, tmpDir := setupTestDriver(t)
+			args := mountArgs(t, driver, map[string]string{"compression": val})
+			cleanupTestDriver(tmpDir)
+
+			AssertContains(t, args, "-o compression="+val, "sshfs args")
+			AssertEqual(t, 1, strings.Count(strings.ToLower(args), "compression="), "compression options")
+		}
+	})
+
+	t.Run("default applies to volumes without a choice", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.Compression = "yes"
+
+		args := mountArgs(t, driver, map[string]string{})
+		AssertContains(t, args, "-o Compression=yes", "sshfs args")
+	})
+
+	t.Run("volume overrides the default", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.Compression = "yes"
+
+		args := mountArgs(t, driver, map[string]string{"Compression": "no"})
+		AssertContains(t, args, "-o Compression=no", "sshfs args")
+		AssertNotContains(t, args, "Compression=yes", "sshfs args")
+	})
+
+	t.Run("no default leaves compression to ssh", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		args := mountArgs(t, driver, map[string]string{})
+		AssertNotContains(t, strings.ToLower(args), "compression", "sshfs args")
+	})
+
+	t.Run("other values are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, val := range []string{"6", "true", ""} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", "compression": val},
+			})
+			if err == nil {
+				t.Fatalf("Expected compression=%q to be rejected", val)
+			}
+			AssertContains(t, err.Error(), "expected yes or no", "create error")
+		}
+	})
+}
+
+// TestSSHCommandOption tests the ssh_command override
+func TestSSHCommandOption(t *testing.T) {
+	t.Run("multi-word command is a single option value", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name: "test-volume",
+			Options: map[string]string{
+				"sshcmd":      "user@host:/path",
+				"ssh_command": `/usr/local/bin/ssh-wrapper --profile "prod eu" -o Ciphers=aes128-ctr,aes256-ctr`,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+
+		args := executor.LastCmd().Args
+		want := `ssh_command=/usr/local/bin/ssh-wrapper --profile "prod eu" -o Ciphers=aes128-ctr\,aes256-ctr`
+		found := false
+		for i, arg := range args {
+			if arg == want {
+				found = i > 0 && args[i-1] == "-o"
+			}
+		}
+		if !found {
+			t.Errorf("Expected -o %q in %q", want, args)
+		}
+	})
+
+	t.Run("commas cannot inject mount options", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "ssh_command": `ssh\,allow_other,allow_other`},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		executor.AssertCommandContains(t, `-o ssh_command=ssh\\\,allow_other\,allow_other`)
+	})
+
+	t.Run("invalid and denied values are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, val := range []string{"", "ssh\n-o allow_other"} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", "ssh_command": val},
+			})
+			if err == nil {
+				t.Errorf("Expected ssh_command %q to be rejected", val)
+			}
+		}
+
+		driver.config.DeniedOptions = []string{"ssh_command"}
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "ssh_command": "ssh"},
+		})
+		if err == nil {
+			t.Fatal("Expected a denied ssh_command to be rejected")
+		}
+		AssertContains(t, err.Error(), "not allowed", "create error")
+	})
+}
+
+// TestAllowRootOption tests that allow_root reaches sshfs and the status
+func TestAllowRootOption(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	err := driver.Create(&volume.CreateRequest{
+		Name:    "test-volume",
+		Options: map[string]string{"sshcmd": "user@host:/path", "allow_root": ""},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	executor.AssertCommandContains(t, "-o allow_root")
+
+	resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+	if err != nil {
+		t.Fatalf("Failed to get volume: %v", err)
+	}
+	AssertEqual(t, true, resp.Volume.Status["allow_root"], "allow_root status")
+	if _, ok := resp.Volume.Status["allow_other"]; ok {
+		t.Error("Expected no allow_other status")
+	}
+
+	restarted, err := newSshfsDriver(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart driver: %v", err)
+	}
+	AssertEqual(t, true, hasOption(restarted.volumes["test-volume"], "allow_root"), "persisted allow_root")
+}
+
+// TestUmaskOption tests that umask is validated and passed to sshfs
+func TestUmaskOption(t *testing.T) {
+	t.Run("octal umask reaches the command and the status", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "umask": "027"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		executor.AssertCommandContains(t, "-o umask=027")
+
+		resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+		if err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+		AssertEqual(t, "027", resp.Volume.Status["umask"], "umask status")
+
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to restart driver: %v", err)
+		}
+		AssertEqual(t, "027", restarted.volumes["test-volume"].Umask, "persisted umask")
+	})
+
+	t.Run("invalid umask is rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, val := range []string{"", "089", "0o22", "1777", "-22"} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    "test-volume",
+				Options: map[string]string{"sshcmd": "user@host:/path", "umask": val},
+			})
+			if err == nil {
+				t.Errorf("Expected umask %q to be rejected", val)
+				continue
+			}
+			AssertContains(t, err.Error(), "invalid octal mode", "error")
+		}
+		AssertEqual(t, 0, len(driver.volumes), "volumes")
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stateUnwritable reports whether a failed state write means the state
+// directory cannot be written at all, as opposed to a problem with the
+// state itself.
+func stateUnwritable(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EROFS, syscall.ENOSPC, syscall.EDQUOT, syscall.EACCES, syscall.EPERM} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// persist saves the state like saveState, except when the state directory
+// is not writable: the driver then keeps going on its in-memory state, so
+// that mounts and other operations do not fail over it, and retries the
+// write every StateRetryInterval until it succeeds. The caller must hold the
+// driver lock.
+func (d *sshfsDriver) persist() error {
+	err := d.saveState()
+	if err == nil || !stateUnwritable(err) {
+		return err
+	}
+	if !d.stateDegraded {
+		d.stateDegraded = true
+		logrus.WithField("statePath", d.statePath).Errorf("STATE NOT SAVED: the state directory is not writable (%v); changes are kept in memory only and will be lost on restart until it is, retrying every %v", err, d.config.StateRetryInterval)
+	}
+	if !d.stateRetrying {
+		d.stateRetrying = true
+		go d.stateRetryLoop()
+	}
+	return nil
+}
+
+// stateRetryLoop writes the state every StateRetryInterval until a write
+// succeeds.
+func (d *sshfsDriver) stateRetryLoop() {
+	for {
+		d.sleep(d.config.StateRetryInterval)
+
+		d.Lock()
+		var err error
+		if d.stateDegraded {
+			err = d.saveState()
+		}
+		if err == nil {
+			d.stateRetrying = false
+			d.Unlock()
+			return
+		}
+		d.Unlock()
+		logrus.WithField("statePath", d.statePath).Debugf("state directory is still not writable: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestReadOnlyStateDirectory tests that the driver keeps working in memory
+// while the state directory is not writable and flushes the state once it is
+func TestReadOnlyStateDirectory(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	readOnly := true
+	driver.writeFile = func(path string, data []byte, perm os.FileMode) error {
+		if readOnly {
+			return &os.PathError{Op: "open", Path: path, Err: syscall.EROFS}
+		}
+		return writeFileAtomic(path, data, perm)
+	}
+	// The fake sleep holds the retry loop until the test resumes it.
+	sleeps := make(chan time.Duration)
+	resume := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	driver.sleep = func(d time.Duration) {
+		select {
+		case sleeps <- d:
+		case <-stop:
+			runtime.Goexit()
+		}
+		select {
+		case <-resume:
+		case <-stop:
+			runtime.Goexit()
+		}
+	}
+
+	if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	AssertEqual(t, true, driver.status().StateDegraded, "degraded state")
+	AssertFileNotExists(t, driver.statePath)
+
+	// The first retry still fails.
+	AssertEqual(t, driver.config.StateRetryInterval, <-sleeps, "retry interval")
+	resume <- struct{}{}
+	<-sleeps
+	AssertEqual(t, true, driver.status().StateDegraded, "degraded state")
+	AssertFileNotExists(t, driver.statePath)
+
+	// The next one flushes the state and ends the retries.
+	readOnly = false
+	resume <- struct{}{}
+	deadline := time.After(5 * time.Second)
+	for driver.status().StateDegraded {
+		select {
+		case <-sleeps:
+			t.Fatal("Expected the retries to end once the state was written")
+		case <-deadline:
+			t.Fatal("Expected the state to be written")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	restarted, err := newSshfsDriver(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to restart driver: %v", err)
+	}
+	if _, ok := restarted.volumes["test-volume"]; !ok {
+		t.Error("Expected the volume in the flushed state")
+	}
+
+	t.Run("other errors still fail", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.writeFile = func(path string, data []byte, perm os.FileMode) error {
+			return &os.PathError{Op: "write", Path: path, Err: syscall.EIO}
+		}
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}})
+		AssertError(t, err, "create")
+		AssertContains(t, err.Error(), "failed to save state", "create error")
+		AssertEqual(t, false, driver.status().StateDegraded, "degraded state")
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// isMounted reports whether target appears as a mountpoint in the mount
+// table.
+func (d *sshfsDriver) isMounted(target string) (bool, error) {
+	f, err := os.Open(d.mountsPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	target = filepath.Clean(target)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && unescapeMountField(fields[1]) == target {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes, such as \040 for a space,
+// that the kernel uses in mount table fields.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// checkMountpointPath rejects a mountpoint that a symlink in its path, the
+// mountpoint itself included, redirects outside of root, which would make
+// sshfs mount somewhere the driver does not manage.
+func checkMountpointPath(root, mountpoint string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, mountpoint)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	p := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return fmt.Errorf("mountpoint %s goes through broken symlink %s", mountpoint, p)
+		}
+		if !isWithin(realRoot, target) {
+			return fmt.Errorf("mountpoint %s goes through symlink %s to %s, outside of %s", mountpoint, p, target, root)
+		}
+	}
+	return nil
+}
+
+// prepareMountpoint makes sure nothing is hidden by mounting over the
+// volume's mountpoint. Leftovers of an earlier failed mount are an error
+// unless the volume asks for them to be cleaned.
+func prepareMountpoint(v *sshfsVolume) error {
+	entries, err := os.ReadDir(v.Mountpoint)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if !v.CleanMountpoint {
+		return fmt.Errorf("mountpoint %s is not empty; remove its contents or create the volume with -o clean_mountpoint=true", v.Mountpoint)
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(v.Mountpoint, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWritable creates and deletes a file in a mounted volume, to find a
+// read-only remote before an application's first write does.
+func checkWritable(mountpoint string) error {
+	f, err := os.CreateTemp(mountpoint, ".sshfs-write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
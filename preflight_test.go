@@ -0,0 +1,287 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestMountPreflight tests the mountpoint checks done before sshfs runs
+func TestMountPreflight(t *testing.T) {
+	setup := func(t *testing.T, options map[string]string) (*sshfsDriver, string, *TestCommandExecutor, string) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		driver.mountsPath = filepath.Join(tmpDir, "mounts")
+		if err := os.WriteFile(driver.mountsPath, []byte("proc /proc proc rw 0 0\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write mount table: %v", err)
+		}
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		options["sshcmd"] = "user@host:/path"
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		mountpoint := driver.volumes["test-volume"].Mountpoint
+		if err := os.MkdirAll(mountpoint, 0o755); err != nil {
+			t.Fatalf("Failed to create mountpoint: %v", err)
+		}
+		return driver, tmpDir, executor, mountpoint
+	}
+	mount := func(driver *sshfsDriver) error {
+		_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		return err
+	}
+
+	t.Run("already mounted path is a no-op", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, map[string]string{})
+		defer cleanupTestDriver(tmpDir)
+		table := "user@host:/path " + mountpoint + " fuse.sshfs rw,nosuid,nodev 0 0\n"
+		if err := os.WriteFile(driver.mountsPath, []byte(table), 0o644); err != nil {
+			t.Fatalf("Failed to write mount table: %v", err)
+		}
+
+		if err := mount(driver); err != nil {
+			t.Fatalf("Expected mount of a live mountpoint to succeed, got %v", err)
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		AssertEqual(t, 1, driver.volumes["test-volume"].connections, "connections")
+	})
+
+	t.Run("empty directory proceeds", func(t *testing.T) {
+		driver, tmpDir, executor, _ := setup(t, map[string]string{})
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+
+		if err := mount(driver); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		executor.AssertCommandContains(t, "sshfs")
+	})
+
+	t.Run("non-empty directory is guarded", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, map[string]string{})
+		defer cleanupTestDriver(tmpDir)
+		if err := os.WriteFile(filepath.Join(mountpoint, "leftover"), []byte("data"), 0o644); err != nil {
+			t.Fatalf("Failed to write leftover: %v", err)
+		}
+
+		err := mount(driver)
+		if err == nil {
+			t.Fatal("Expected error for a non-empty mountpoint")
+		}
+		AssertContains(t, err.Error(), "clean_mountpoint", "mount error")
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		AssertFileExists(t, filepath.Join(mountpoint, "leftover"))
+	})
+
+	t.Run("clean_mountpoint removes leftovers", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, map[string]string{"clean_mountpoint": "true"})
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+		if err := os.MkdirAll(filepath.Join(mountpoint, "dir"), 0o755); err != nil {
+			t.Fatalf("Failed to create leftover: %v", err)
+		}
+
+		if err := mount(driver); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		AssertDirNotExists(t, filepath.Join(mountpoint, "dir"))
+		executor.AssertCommandContains(t, "sshfs")
+	})
+
+	t.Run("unreadable mount table fails before cleaning", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, map[string]string{"clean_mountpoint": "true"})
+		defer cleanupTestDriver(tmpDir)
+		if err := os.WriteFile(filepath.Join(mountpoint, "remote-file"), []byte("data"), 0o644); err != nil {
+			t.Fatalf("Failed to write remote file: %v", err)
+		}
+		os.Remove(driver.mountsPath)
+
+		err := mount(driver)
+		AssertError(t, err, "mount")
+		AssertContains(t, err.Error(), "failed to read mount table", "mount error")
+		AssertFileExists(t, filepath.Join(mountpoint, "remote-file"))
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("shared mountpoint stays mounted while held", func(t *testing.T) {
+		driver, tmpDir, executor, mountpoint := setup(t, map[string]string{})
+		defer cleanupTestDriver(tmpDir)
+		if err := driver.Create(&volume.CreateRequest{Name: "other-volume", Options: map[string]string{"sshcmd": "user@host:/path/"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		AssertEqual(t, mountpoint, driver.volumes["other-volume"].Mountpoint, "shared mountpoint")
+		executor.OnRun = func(cmd *exec.Cmd) {
+			table := ""
+			if cmd.Args[0] == "sshfs" {
+				table = "user@host:/path " + mountpoint + " fuse.sshfs rw 0 0\n"
+			}
+			os.WriteFile(driver.mountsPath, []byte(table), 0o644)
+		}
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+
+		for _, name := range []string{"test-volume", "other-volume"} {
+			if _, err := driver.Mount(&volume.MountRequest{Name: name, ID: "c-" + name}); err != nil {
+				t.Fatalf("Failed to mount %s: %v", name, err)
+			}
+		}
+		AssertEqual(t, 1, executor.GetCommandCount(), "sshfs runs once")
+
+		AssertNoError(t, driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c-test-volume"}), "unmount first volume")
+		AssertEqual(t, 1, executor.GetCommandCount(), "no unmount while the other volume holds the mount")
+		AssertNoError(t, driver.Unmount(&volume.UnmountRequest{Name: "other-volume", ID: "c-other-volume"}), "unmount second volume")
+		AssertEqual(t, 2, executor.GetCommandCount(), "unmounted by the last volume")
+		AssertEqual(t, mountpoint, executor.GetCommands()[1][len(executor.GetCommands()[1])-1], "unmount target")
+	})
+}
+
+// TestVerifyWritable tests the write check of verify_writable after mounting
+func TestVerifyWritable(t *testing.T) {
+	setup := func(t *testing.T, options map[string]string, writable bool) (*sshfsDriver, string, *TestCommandExecutor) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		options["sshcmd"] = "user@host:/path"
+		options["verify_writable"] = "true"
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if !writable {
+			// Stand in for a read-only remote: once "mounted", nothing can
+			// be created below the mountpoint.
+			mountpoint := driver.volumes["test-volume"].Mountpoint
+			executor.OnRun = func(cmd *exec.Cmd) {
+				if cmd.Args[0] == "sshfs" {
+					os.Remove(mountpoint)
+					os.WriteFile(mountpoint, nil, 0o644)
+				}
+			}
+		}
+		return driver, tmpDir, executor
+	}
+	mount := func(driver *sshfsDriver) error {
+		_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		return err
+	}
+
+	t.Run("writable remote mounts", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, map[string]string{}, true)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+
+		if err := mount(driver); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		entries, err := os.ReadDir(driver.volumes["test-volume"].Mountpoint)
+		if err != nil {
+			t.Fatalf("Failed to read mountpoint: %v", err)
+		}
+		AssertEqual(t, 0, len(entries), "files left in the mountpoint")
+		AssertEqual(t, 1, driver.volumes["test-volume"].connections, "connections")
+	})
+
+	t.Run("read-only remote fails the mount", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, map[string]string{}, false)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+
+		err := mount(driver)
+		if err == nil {
+			t.Fatal("Expected the mount to fail")
+		}
+		AssertContains(t, err.Error(), "volume test-volume is not writable", "mount error")
+		AssertEqual(t, 2, executor.GetCommandCount(), "sshfs and unmount")
+		AssertEqual(t, 0, driver.volumes["test-volume"].connections, "connections")
+	})
+
+	t.Run("ro volumes skip the check", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, map[string]string{"ro": ""}, false)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+
+		if err := mount(driver); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		AssertEqual(t, 1, executor.GetCommandCount(), "commands run")
+	})
+}
+
+// TestUnescapeMountField tests decoding of mount table escapes
+func TestUnescapeMountField(t *testing.T) {
+	AssertEqual(t, "/mnt/my volume", unescapeMountField(`/mnt/my\040volume`), "space")
+	AssertEqual(t, "/mnt/plain", unescapeMountField("/mnt/plain"), "plain")
+	AssertEqual(t, `/mnt/x\0`, unescapeMountField(`/mnt/x\0`), "truncated escape")
+}
+
+// TestMountpointSymlinks tests that Mount refuses symlinks leading out of the root
+func TestMountpointSymlinks(t *testing.T) {
+	setup := func(t *testing.T, mountpoint string) (*sshfsDriver, string, *TestCommandExecutor) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		driver.mountsPath = filepath.Join(tmpDir, "mounts")
+		if err := os.WriteFile(driver.mountsPath, []byte("proc /proc proc rw 0 0\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write mount table: %v", err)
+		}
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+		driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path", Mountpoint: filepath.Join(driver.root, mountpoint)}
+		return driver, tmpDir, executor
+	}
+	mount := func(driver *sshfsDriver) error {
+		_, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"})
+		return err
+	}
+
+	t.Run("symlinked component escaping the root is rejected", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, "sub/test")
+		defer cleanupTestDriver(tmpDir)
+		outside := filepath.Join(tmpDir, "outside")
+		if err := os.MkdirAll(filepath.Join(outside, "test"), 0o755); err != nil {
+			t.Fatalf("Failed to create outside dir: %v", err)
+		}
+		if err := os.Symlink(outside, filepath.Join(driver.root, "sub")); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+
+		err := mount(driver)
+		if err == nil {
+			t.Fatal("Expected error for a mountpoint through a symlink")
+		}
+		AssertContains(t, err.Error(), "outside of", "mount error")
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("symlinked mountpoint escaping the root is rejected", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, "test")
+		defer cleanupTestDriver(tmpDir)
+		if err := os.Symlink(os.TempDir(), filepath.Join(driver.root, "test")); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+
+		err := mount(driver)
+		if err == nil {
+			t.Fatal("Expected error for a symlinked mountpoint")
+		}
+		AssertContains(t, err.Error(), "symlink", "mount error")
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("normal path is allowed", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, "sub/test")
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+
+		if err := mount(driver); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		executor.AssertCommandContains(t, "sshfs")
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// probeCache remembers hosts that recently accepted an ssh connection, so
+// back-to-back checks do not each open a session. An entry only vouches for
+// the credentials that connected.
+type probeCache struct {
+	mu sync.Mutex
+	ok map[string]probeEntry
+}
+
+type probeEntry struct {
+	at          time.Time
+	credentials string
+	result      connectionResult
+}
+
+// connectionResult describes the outcome of TestConnection.
+type connectionResult struct {
+	Reachable      bool    `json:"reachable"`
+	AuthOK         bool    `json:"auth_ok"`
+	HostKeyTrusted bool    `json:"host_key_trusted"`
+	LatencyMs      float64 `json:"latency_ms"`
+	// SFTPOK is set when verify_sftp found the sftp subsystem working.
+	SFTPOK bool `json:"sftp_ok,omitempty"`
+	// ErrorClass is one of "unreachable", "timeout", "host_key", "auth",
+	// "sftp" or "unknown" when the connection failed.
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// ClockSkewSec is how far the remote clock is ahead of the local one,
+	// in seconds, when MaxClockSkew enables the check.
+	ClockSkewSec int64 `json:"clock_skew_sec,omitempty"`
+	Cached       bool  `json:"cached"`
+}
+
+// probeKey identifies the ssh endpoint of a volume.
+func probeKey(v *sshfsVolume) string {
+	dest, _ := splitSshcmd(v.Sshcmd)
+	port := v.Port
+	if port == "" {
+		port = "22"
+	}
+	return dest + ":" + port
+}
+
+// probeCredentials fingerprints what a volume authenticates with, so that
+// a volume with a wrong password or key is not vouched for by a probe of
+// another volume of the same host.
+func probeCredentials(v *sshfsVolume) string {
+	h := sha256.New()
+	for _, s := range []string{v.Password, v.PasswordFile, v.SSHConfig, strconv.FormatBool(v.HostCredentials)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	for _, option := range v.Options {
+		key, _, _ := strings.Cut(option, "=")
+		if strings.EqualFold(key, "IdentityFile") || strings.EqualFold(key, "CertificateFile") || strings.EqualFold(key, "IdentityAgent") {
+			h.Write([]byte(option))
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *probeCache) fresh(key, credentials string, now time.Time, ttl time.Duration) (connectionResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.ok[key]
+	return entry.result, ok && entry.credentials == credentials && now.Sub(entry.at) < ttl
+}
+
+func (c *probeCache) store(key, credentials string, now time.Time, result connectionResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ok == nil {
+		c.ok = map[string]probeEntry{}
+	}
+	c.ok[key] = probeEntry{at: now, credentials: credentials, result: result}
+}
+
+func (c *probeCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ok, key)
+}
+
+// connectionErrors classifies ssh failures by their message, most specific
+// first.
+var connectionErrors = []struct {
+	class    string
+	messages []string
+}{
+	{"host_key", []string{"Host key verification failed", "REMOTE HOST IDENTIFICATION HAS CHANGED"}},
+	{"auth", []string{"Permission denied", "Too many authentication failures", "Authentication failed"}},
+	{"unreachable", []string{"Connection refused", "No route to host", "Could not resolve hostname", "Network is unreachable", "Connection timed out"}},
+}
+
+// classifyConnection fills in a failed result from the ssh output. Failing
+// later in the handshake implies the earlier steps succeeded.
+func classifyConnection(result *connectionResult, output []byte) {
+	result.ErrorClass = "unknown"
+	for _, e := range connectionErrors {
+		for _, msg := range e.messages {
+			if strings.Contains(string(output), msg) {
+				result.ErrorClass = e.class
+			}
+		}
+		if result.ErrorClass != "unknown" {
+			break
+		}
+	}
+	switch result.ErrorClass {
+	case "auth":
+		result.HostKeyTrusted = true
+		result.Reachable = true
+	case "host_key":
+		result.Reachable = true
+	}
+}
+
+// TestConnection checks that the volume's host accepts an ssh session with
+// the volume's credentials and reports how far the connection got. A
+// success is cached per host and credentials for ProbeCacheTTL; a failed
+// mount of that host drops it again. A failed connection returns both the
+// result and an error. Operators reach it at POST /volumes/<name>/probe.
+func (d *sshfsDriver) TestConnection(name string) (*connectionResult, error) {
+	logrus.WithField("method", "test connection").Debug(name)
+
+	d.RLock()
+	v, ok := d.volumes[name]
+	if !ok {
+		d.RUnlock()
+		return nil, logError("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	vol := *v
+	d.RUnlock()
+
+	return d.testConnection(&vol)
+}
+
+// testConnection probes the volume's host, and its sftp subsystem for
+// volumes with verify_sftp; see TestConnection.
+func (d *sshfsDriver) testConnection(vol *sshfsVolume) (*connectionResult, error) {
+	result, err := d.probeConnection(vol)
+	if err != nil || !vol.VerifySFTP {
+		return result, err
+	}
+	return result, d.checkSFTP(vol, result)
+}
+
+// probeConnection opens an ssh session to the volume's host.
+func (d *sshfsDriver) probeConnection(vol *sshfsVolume) (*connectionResult, error) {
+	key, credentials := probeKey(vol), probeCredentials(vol)
+	if result, ok := d.probes.fresh(key, credentials, d.now(), d.config.ProbeCacheTTL); ok {
+		logrus.WithField("host", key).Debug("connection probe served from cache")
+		result.Cached = true
+		return &result, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.RemoteCommandTimeout)
+	defer cancel()
+	remoteCmd := "true"
+	if d.config.MaxClockSkew > 0 {
+		remoteCmd = "date +%s"
+	}
+	cmd, err := d.sshCommand(ctx, vol, remoteCmd)
+	if err != nil {
+		return nil, logError("%s", err.Error())
+	}
+	start := d.now()
+	output, err := d.executor.Run(cmd)
+	end := d.now()
+	result := connectionResult{LatencyMs: float64(end.Sub(start)) / float64(time.Millisecond)}
+	if err != nil {
+		d.probes.invalidate(key)
+		if ctx.Err() == context.DeadlineExceeded {
+			result.ErrorClass = "timeout"
+		} else {
+			classifyConnection(&result, output)
+		}
+		result.Error = strings.TrimSpace(fmt.Sprintf("%v %s", err, output))
+		return &result, logError("connection to %s failed: %v (%s)", key, err, output)
+	}
+	result.Reachable = true
+	result.AuthOK = true
+	result.HostKeyTrusted = true
+	if d.config.MaxClockSkew > 0 {
+		d.checkClockSkew(key, &result, output, start.Add(end.Sub(start)/2))
+	}
+	d.probes.store(key, credentials, d.now(), result)
+	return &result, nil
+}
+
+// checkClockSkew compares the remote time printed by date +%s with local,
+// the local time halfway through the session, and warns when they differ
+// by more than MaxClockSkew, which makes servers with strict time windows
+// reject authentication now and then.
+func (d *sshfsDriver) checkClockSkew(key string, result *connectionResult, output []byte, local time.Time) {
+	remote, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		logrus.WithField("host", key).Warnf("failed to read remote time from %q", output)
+		return
+	}
+	skew := time.Unix(remote, 0).Sub(local).Round(time.Second)
+	result.ClockSkewSec = int64(skew / time.Second)
+	if skew > d.config.MaxClockSkew || -skew > d.config.MaxClockSkew {
+		logrus.WithField("host", key).Warnf("remote clock is %v off the local clock, more than %v", skew, d.config.MaxClockSkew)
+	}
+}
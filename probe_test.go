@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// TestConnectionProbeCache tests that connection probes are cached per host
+func TestConnectionProbeCache(t *testing.T) {
+	setup := func(t *testing.T) (*sshfsDriver, string, *TestCommandExecutor, *time.Time) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		now := time.Unix(0, 0)
+		driver.now = func() time.Time { return now }
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+		for _, name := range []string{"first", "second"} {
+			err := driver.Create(&volume.CreateRequest{
+				Name:    name,
+				Options: map[string]string{"sshcmd": "user@host:/" + name},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create volume %s: %v", name, err)
+			}
+		}
+		return driver, tmpDir, executor, &now
+	}
+
+	t.Run("second probe within the TTL is cached", func(t *testing.T) {
+		driver, tmpDir, executor, now := setup(t)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+
+		if _, err := driver.TestConnection("first"); err != nil {
+			t.Fatalf("Failed to test connection: %v", err)
+		}
+		*now = now.Add(10 * time.Second)
+		if _, err := driver.TestConnection("second"); err != nil {
+			t.Fatalf("Failed to test connection: %v", err)
+		}
+		AssertEqual(t, 1, executor.GetCommandCount(), "ssh sessions within the TTL")
+
+		*now = now.Add(driver.config.ProbeCacheTTL)
+		if _, err := driver.TestConnection("first"); err != nil {
+			t.Fatalf("Failed to test connection: %v", err)
+		}
+		AssertEqual(t, 2, executor.GetCommandCount(), "ssh sessions after the TTL")
+	})
+
+	t.Run("mount failure invalidates the cache", func(t *testing.T) {
+		driver, tmpDir, executor, _ := setup(t)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse([]byte("connection reset"), fmt.Errorf("exit status 1"))
+		executor.AddMockResponse(nil, nil)
+
+		if _, err := driver.TestConnection("first"); err != nil {
+			t.Fatalf("Failed to test connection: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "second", ID: "c1"}); err == nil {
+			t.Fatal("Expected mount to fail")
+		}
+		if _, err := driver.TestConnection("first"); err != nil {
+			t.Fatalf("Failed to test connection: %v", err)
+		}
+		AssertEqual(t, 3, executor.GetCommandCount(), "ssh sessions")
+		executor.AssertCommandContains(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host true")
+	})
+
+	t.Run("failed probe is not cached", func(t *testing.T) {
+		driver, tmpDir, executor, _ := setup(t)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse([]byte("Permission denied"), fmt.Errorf("exit status 255"))
+		executor.AddMockResponse([]byte("Permission denied"), fmt.Errorf("exit status 255"))
+
+		for i := 0; i < 2; i++ {
+			if _, err := driver.TestConnection("first"); err == nil {
+				t.Fatal("Expected connection test to fail")
+			}
+		}
+		AssertEqual(t, 2, executor.GetCommandCount(), "ssh sessions")
+	})
+}
+
+// TestConnectionResult tests the structured result of TestConnection
+func TestConnectionResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		err      error
+		expected connectionResult
+	}{
+		{"success", "", nil, connectionResult{Reachable: true, AuthOK: true, HostKeyTrusted: true, LatencyMs: 250}},
+		{"unreachable", "ssh: connect to host host port 22: Connection refused", fmt.Errorf("exit status 255"),
+			connectionResult{ErrorClass: "unreachable", LatencyMs: 250}},
+		{"unresolvable", "ssh: Could not resolve hostname host: Name or service not known", fmt.Errorf("exit status 255"),
+			connectionResult{ErrorClass: "unreachable", LatencyMs: 250}},
+		{"host key", "Host key verification failed.", fmt.Errorf("exit status 255"),
+			connectionResult{Reachable: true, ErrorClass: "host_key", LatencyMs: 250}},
+		{"auth", "user@host: Permission denied (publickey,password).", fmt.Errorf("exit status 255"),
+			connectionResult{Reachable: true, HostKeyTrusted: true, ErrorClass: "auth", LatencyMs: 250}},
+		{"unknown", "something odd", fmt.Errorf("exit status 1"),
+			connectionResult{ErrorClass: "unknown", LatencyMs: 250}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.now = fakeClock(0, 0, 250*time.Millisecond)
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse([]byte(tt.output), tt.err)
+			driver.executor = executor
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+
+			result, err := driver.TestConnection("test-volume")
+			if (err != nil) != (tt.err != nil) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			if result == nil {
+				t.Fatal("Expected a result")
+			}
+			if tt.err != nil {
+				AssertContains(t, result.Error, tt.output, "result error")
+				result.Error = ""
+			}
+			AssertEqual(t, tt.expected, *result, "result")
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				t.Fatalf("Failed to marshal result: %v", err)
+			}
+			AssertContains(t, string(data), `"auth_ok":`, "json")
+		})
+	}
+
+	t.Run("real ssh reports what the classifier reads", func(t *testing.T) {
+		if _, err := exec.LookPath("ssh"); err != nil {
+			t.Skip("ssh is not installed")
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+		listener.Close()
+
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.executor = execCommandExecutor{}
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@127.0.0.1:/path", "port": port}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+
+		result, err := driver.TestConnection("test-volume")
+		if err == nil {
+			t.Fatal("Expected connection test to fail")
+		}
+		AssertEqual(t, "unreachable", result.ErrorClass, "error class")
+		AssertContains(t, result.Error, "Connection refused", "result error")
+	})
+
+	t.Run("cached result", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+
+		for _, cached := range []bool{false, true} {
+			result, err := driver.TestConnection("test-volume")
+			if err != nil {
+				t.Fatalf("Failed to test connection: %v", err)
+			}
+			AssertEqual(t, cached, result.Cached, "cached")
+			AssertEqual(t, true, result.AuthOK, "auth_ok")
+		}
+	})
+	t.Run("served on the control API", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("user@host: Permission denied (publickey)."), fmt.Errorf("exit status 255"))
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+
+		post := func(name string) (int, connectionResult) {
+			rec := httptest.NewRecorder()
+			driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/volumes/"+name+"/probe", nil))
+			var result connectionResult
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			return rec.Code, result
+		}
+
+		code, result := post("test-volume")
+		AssertEqual(t, http.StatusBadGateway, code, "status of a failed probe")
+		AssertEqual(t, "auth", result.ErrorClass, "error class")
+
+		code, result = post("test-volume")
+		AssertEqual(t, http.StatusOK, code, "status")
+		AssertEqual(t, true, result.AuthOK, "auth_ok")
+
+		code, _ = post("missing")
+		AssertEqual(t, http.StatusNotFound, code, "status of an unknown volume")
+	})
+}
+
+// TestClockSkew tests comparing the remote clock during connection tests
+func TestClockSkew(t *testing.T) {
+	// The session runs from 0s to 2s, so the local time is taken at 1s.
+	local := time.Date(2024, 1, 1, 0, 0, 1, 0, time.UTC).Unix()
+
+	tests := []struct {
+		name     string
+		remote   int64
+		wantSkew int64
+		wantWarn bool
+	}{
+		{"in sync", local, 0, false},
+		{"ahead within the limit", local + 20, 20, false},
+		{"ahead", local + 90, 90, true},
+		{"behind", local - 45, -45, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.config.MaxClockSkew = 30 * time.Second
+			driver.now = fakeClock(0, 0, 2*time.Second)
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse([]byte(fmt.Sprintf("%d\n", tt.remote)), nil)
+			driver.executor = executor
+			hook := test.NewGlobal()
+			defer hook.Reset()
+
+			result, err := driver.testConnection(&sshfsVolume{Sshcmd: "user@host:/path"})
+			if err != nil {
+				t.Fatalf("Failed to test connection: %v", err)
+			}
+			executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host date +%s")
+			AssertEqual(t, tt.wantSkew, result.ClockSkewSec, "clock skew")
+
+			warned := false
+			for _, entry := range hook.AllEntries() {
+				if entry.Level == logrus.WarnLevel {
+					warned = true
+					AssertContains(t, entry.Message, "remote clock is", "warning")
+				}
+			}
+			AssertEqual(t, tt.wantWarn, warned, "warned")
+		})
+	}
+
+	t.Run("off by default", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		result, err := driver.testConnection(&sshfsVolume{Sshcmd: "user@host:/path"})
+		if err != nil {
+			t.Fatalf("Failed to test connection: %v", err)
+		}
+		executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host true")
+		AssertEqual(t, int64(0), result.ClockSkewSec, "clock skew")
+	})
+}
+
+// TestProbeOnCreate tests the probe option of Create
+func TestProbeOnCreate(t *testing.T) {
+	options := map[string]string{"sshcmd": "user@host:/path", "probe": "true"}
+
+	t.Run("passing probe creates the volume", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host true")
+		AssertFileExists(t, driver.statePath)
+		if _, ok := driver.volumes["test-volume"]; !ok {
+			t.Error("Expected volume to be created")
+		}
+	})
+
+	t.Run("failing probe persists nothing", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("user@host: Permission denied (publickey)."), fmt.Errorf("exit status 255"))
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options})
+		if err == nil {
+			t.Fatal("Expected Create to fail when the probe fails")
+		}
+		AssertContains(t, err.Error(), "Permission denied", "create error")
+		if _, ok := driver.volumes["test-volume"]; ok {
+			t.Error("Expected volume not to be created")
+		}
+		AssertFileNotExists(t, driver.statePath)
+	})
+
+	t.Run("another volume's probe does not vouch for other credentials", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse([]byte("user@host: Permission denied (publickey)."), fmt.Errorf("exit status 255"))
+		driver.executor = executor
+
+		if err := driver.Create(&volume.CreateRequest{Name: "good", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		err := driver.Create(&volume.CreateRequest{Name: "bad", Options: map[string]string{
+			"sshcmd":       "user@host:/other",
+			"probe":        "true",
+			"IdentityFile": "/keys/wrong",
+		}})
+		AssertError(t, err, "create with wrong key")
+		AssertContains(t, err.Error(), "Permission denied", "create error")
+		AssertEqual(t, 2, executor.GetCommandCount(), "probes run")
+	})
+
+	t.Run("password volumes probe through the askpass helper", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{
+			"sshcmd":   "user@host:/path",
+			"probe":    "true",
+			"password": "secret",
+		}})
+		AssertNoError(t, err, "create")
+		executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host true")
+		env := strings.Join(executor.LastCmd().Env, "\n")
+		AssertContains(t, env, "SSH_ASKPASS_REQUIRE=force", "ssh environment")
+		AssertContains(t, env, askpassPasswordEnv+"=secret", "ssh environment")
+	})
+
+	t.Run("slow probe does not hold the driver lock", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.OnRun = func(cmd *exec.Cmd) {
+			close(started)
+			<-release
+		}
+		driver.executor = executor
+
+		created := make(chan error)
+		go func() {
+			created <- driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options})
+		}()
+		<-started
+
+		list, err := driver.List()
+		AssertNoError(t, err, "list during the probe")
+		AssertEqual(t, 0, len(list.Volumes), "volumes listed during the probe")
+		_, err = driver.Get(&volume.GetRequest{Name: "test-volume"})
+		AssertError(t, err, "get during the probe")
+
+		err = driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options})
+		AssertError(t, err, "same create during the probe")
+		AssertContains(t, err.Error(), "already being created", "create error")
+		err = driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/other"}})
+		AssertError(t, err, "conflicting create during the probe")
+		AssertContains(t, err.Error(), "already exists with different options", "create error")
+
+		close(release)
+		AssertNoError(t, <-created, "create")
+		if _, ok := driver.volumes["test-volume"]; !ok {
+			t.Error("Expected volume to be created")
+		}
+		AssertEqual(t, 0, len(driver.creating), "volumes being created")
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+	})
+}
+
+// TestRemoteCommandTimeout tests that slow remote commands are cancelled
+func TestRemoteCommandTimeout(t *testing.T) {
+	// An ssh that never answers.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\nexec sleep 10\n"), 0o755); err != nil {
+		t.Fatalf("Failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	setup := func(t *testing.T) (*sshfsDriver, string) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		driver.config.RemoteCommandTimeout = 100 * time.Millisecond
+		driver.executor = execCommandExecutor{}
+		return driver, tmpDir
+	}
+	v := &sshfsVolume{Sshcmd: "user@host:/path", SetupCommand: "mkdir -p /path"}
+
+	t.Run("connection test", func(t *testing.T) {
+		driver, tmpDir := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		start := time.Now()
+		result, err := driver.testConnection(v)
+		if err == nil {
+			t.Fatal("Expected the connection test to time out")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected cancellation after the timeout, took %v", elapsed)
+		}
+		AssertEqual(t, "timeout", result.ErrorClass, "error class")
+	})
+
+	t.Run("setup command", func(t *testing.T) {
+		driver, tmpDir := setup(t)
+		defer cleanupTestDriver(tmpDir)
+
+		start := time.Now()
+		err := driver.runSetupCommand(v)
+		if err == nil {
+			t.Fatal("Expected the setup command to time out")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected cancellation after the timeout, took %v", elapsed)
+		}
+		AssertContains(t, err.Error(), "timed out after 100ms", "setup error")
+	})
+}
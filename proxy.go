@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// proxyHelper is the netcat that tunnels ssh through a proxy. Only the
+// OpenBSD netcat has the -X and -x flags.
+const proxyHelper = "nc"
+
+// proxyCommand builds the ssh ProxyCommand that tunnels through the proxy
+// at rawURL: an HTTP CONNECT proxy for http_proxy, a SOCKS 4 or 5 proxy for
+// socks_proxy.
+func proxyCommand(key, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid value %q for option %s, expected a URL such as %s", rawURL, key, proxyExample(key))
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("option %s does not support proxy credentials", key)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid value %q for option %s, expected only a scheme, host and port", rawURL, key)
+	}
+	if _, port, err := net.SplitHostPort(u.Host); err != nil || port == "" {
+		return "", fmt.Errorf("invalid value %q for option %s, the proxy port is required", rawURL, key)
+	}
+
+	var protocol string
+	switch {
+	case key == "http_proxy" && u.Scheme == "http":
+		protocol = "connect"
+	case key == "socks_proxy" && (u.Scheme == "socks5" || u.Scheme == "socks"):
+		protocol = "5"
+	case key == "socks_proxy" && u.Scheme == "socks4":
+		protocol = "4"
+	default:
+		return "", fmt.Errorf("invalid scheme %q for option %s, expected a URL such as %s", u.Scheme, key, proxyExample(key))
+	}
+	return fmt.Sprintf("%s -X %s -x %s %%h %%p", proxyHelper, protocol, u.Host), nil
+}
+
+func proxyExample(key string) string {
+	if key == "http_proxy" {
+		return "http://proxy:3128"
+	}
+	return "socks5://proxy:1080"
+}
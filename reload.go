@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reloadResult reports what a reload of the state file changed.
+type reloadResult struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Changed   []string `json:"changed"`
+	Conflicts []string `json:"conflicts"`
+}
+
+// reloadState re-reads the state file and applies edits made to it while
+// the driver ran. Volumes whose definition is unchanged keep their mounts.
+// A volume that is in use, or whose mountpoint is still mounted, is never
+// changed or dropped: the edit is reported as a conflict and the running
+// definition stays, to be written back with the next state change. Secrets
+// the state file does not keep are carried over from the running volume.
+func (d *sshfsDriver) reloadState() (*reloadResult, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	loaded := map[string]*sshfsVolume{}
+	data, err := d.readState(d.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, logError("failed to read state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return nil, logError("state file %s is invalid: %v", d.statePath, err)
+		}
+	}
+
+	result := &reloadResult{Added: []string{}, Removed: []string{}, Changed: []string{}, Conflicts: []string{}}
+	for _, name := range sortedNames(d.volumes) {
+		v := d.volumes[name]
+		edited, ok := loaded[name]
+		if ok {
+			edited.Mountpoint = d.mountpointFor(edited)
+			restoreSecrets(edited, v)
+			if sameDefinition(v, edited) {
+				if v.Disabled != edited.Disabled {
+					v.Disabled = edited.Disabled
+					result.Changed = append(result.Changed, name)
+				}
+				continue
+			}
+		}
+		if d.inUse(v) {
+			result.Conflicts = append(result.Conflicts, name)
+			continue
+		}
+		if ok {
+			edited.history = v.history
+			d.volumes[name] = edited
+			result.Changed = append(result.Changed, name)
+		} else {
+			delete(d.volumes, name)
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	for _, name := range sortedNames(loaded) {
+		if _, ok := d.volumes[name]; ok {
+			continue
+		}
+		v := loaded[name]
+		v.Mountpoint = d.mountpointFor(v)
+		if err := d.mountpointCollision(name, v); err != nil {
+			logrus.WithField("volume", name).Warnf("not reloading volume: %v", err)
+			result.Conflicts = append(result.Conflicts, name)
+			continue
+		}
+		d.volumes[name] = v
+		result.Added = append(result.Added, name)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"added":     result.Added,
+		"removed":   result.Removed,
+		"changed":   result.Changed,
+		"conflicts": result.Conflicts,
+	}).Info("reloaded state")
+	return result, nil
+}
+
+// inUse reports whether a volume is held by a container, being mounted, or
+// still mounted at its mountpoint. The caller must hold the driver lock.
+func (d *sshfsDriver) inUse(v *sshfsVolume) bool {
+	if v.connections > 0 || v.mounting != nil {
+		return true
+	}
+	mounted, err := d.isMounted(v.Mountpoint)
+	if err != nil {
+		logrus.WithField("mountsPath", d.mountsPath).Warnf("failed to read mount table: %v", err)
+		return true
+	}
+	return mounted
+}
+
+func sortedNames(volumes map[string]*sshfsVolume) []string {
+	names := make([]string, 0, len(volumes))
+	for name := range volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
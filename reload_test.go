@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestReloadState tests that hand edits to the state file are applied by
+// reload without disturbing volumes in use
+func TestReloadState(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+
+	for _, name := range []string{"busy", "idle", "renamed"} {
+		options := map[string]string{"sshcmd": "user@host:/" + name}
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+			t.Fatalf("Failed to create volume %s: %v", name, err)
+		}
+	}
+	if _, err := driver.Mount(&volume.MountRequest{Name: "busy", ID: "c1"}); err != nil {
+		t.Fatalf("Failed to mount volume: %v", err)
+	}
+	busyMountpoint := driver.volumes["busy"].Mountpoint
+
+	// Edit the state file as an operator would: point both the busy and the
+	// idle volume elsewhere, drop one volume and add another.
+	data, err := os.ReadFile(driver.statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	var state map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("Failed to parse state: %v", err)
+	}
+	state["busy"]["Sshcmd"] = "user@other:/busy"
+	state["idle"]["Sshcmd"] = "user@other:/idle"
+	state["added"] = state["renamed"]
+	state["added"]["Sshcmd"] = "user@host:/added"
+	delete(state, "renamed")
+	if data, err = json.Marshal(state); err != nil {
+		t.Fatalf("Failed to encode state: %v", err)
+	}
+	if err := os.WriteFile(driver.statePath, data, 0o600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	AssertEqual(t, http.StatusOK, rec.Code, "status code")
+	var result reloadResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode reload result: %v", err)
+	}
+	AssertEqual(t, "added", strings.Join(result.Added, ","), "added")
+	AssertEqual(t, "renamed", strings.Join(result.Removed, ","), "removed")
+	AssertEqual(t, "idle", strings.Join(result.Changed, ","), "changed")
+	AssertEqual(t, "busy", strings.Join(result.Conflicts, ","), "conflicts")
+
+	list, err := driver.List()
+	if err != nil {
+		t.Fatalf("Failed to list volumes: %v", err)
+	}
+	var names []string
+	for _, v := range list.Volumes {
+		names = append(names, v.Name)
+	}
+	AssertEqual(t, "added,busy,idle", strings.Join(names, ","), "listed volumes")
+
+	busy := driver.volumes["busy"]
+	AssertEqual(t, "user@host:/busy", busy.Sshcmd, "busy volume keeps its definition")
+	AssertEqual(t, busyMountpoint, busy.Mountpoint, "busy mountpoint")
+	AssertEqual(t, 1, busy.connections, "busy connections")
+	AssertEqual(t, "user@other:/idle", driver.volumes["idle"].Sshcmd, "idle volume definition")
+	AssertEqual(t, driver.mountpointFor(driver.volumes["added"]), driver.volumes["added"].Mountpoint, "added mountpoint")
+	AssertEqual(t, 1, executor.GetCommandCount(), "commands run")
+
+	t.Run("invalid state file changes nothing", func(t *testing.T) {
+		if err := os.WriteFile(driver.statePath, []byte("{"), 0o600); err != nil {
+			t.Fatalf("Failed to write state: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+		AssertEqual(t, http.StatusInternalServerError, rec.Code, "status code")
+		AssertEqual(t, 3, len(driver.volumes), "volumes")
+	})
+}
+
+// TestReloadKeepsSecrets tests that reload neither reports volumes with
+// secrets the state file does not keep as changed nor drops those secrets
+func TestReloadKeepsSecrets(t *testing.T) {
+	driver, tmpDir := setupTestDriver(t)
+	defer cleanupTestDriver(tmpDir)
+
+	volumes := map[string]map[string]string{
+		"no-persist": {"sshcmd": "user@host:/a", "password": "pw", "no_persist_password": "true"},
+		"secret-env": {"sshcmd": "user@host:/b", "secret_env": "TOKEN=s3cr3t"},
+	}
+	for name, options := range volumes {
+		if err := driver.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+			t.Fatalf("Failed to create volume %s: %v", name, err)
+		}
+	}
+
+	result, err := driver.reloadState()
+	AssertNoError(t, err, "reload")
+	AssertEqual(t, "", strings.Join(result.Changed, ","), "changed")
+	AssertEqual(t, "", strings.Join(result.Conflicts, ","), "conflicts")
+	AssertEqual(t, "pw", driver.volumes["no-persist"].Password, "password")
+	AssertEqual(t, "TOKEN=s3cr3t", strings.Join(driver.volumes["secret-env"].SecretEnv, ","), "secret_env")
+
+	// An edit of another field replaces the volume, secrets included.
+	data, err := os.ReadFile(driver.statePath)
+	AssertNoError(t, err, "read state")
+	data = []byte(strings.Replace(string(data), "user@host:/b", "user@other:/b", 1))
+	if err := os.WriteFile(driver.statePath, data, 0o600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	result, err = driver.reloadState()
+	AssertNoError(t, err, "reload")
+	AssertEqual(t, "secret-env", strings.Join(result.Changed, ","), "changed")
+	AssertEqual(t, "user@other:/b", driver.volumes["secret-env"].Sshcmd, "edited sshcmd")
+	AssertEqual(t, "TOKEN=s3cr3t", strings.Join(driver.volumes["secret-env"].SecretEnv, ","), "secret_env after edit")
+
+	executor := NewTestCommandExecutor()
+	executor.AddMockResponse(nil, nil)
+	executor.AddMockResponse(nil, nil)
+	driver.executor = executor
+	for name := range volumes {
+		if _, err := driver.Mount(&volume.MountRequest{Name: name, ID: "c1"}); err != nil {
+			t.Errorf("Failed to mount volume %s after reload: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// remoteOSNoShell records a server that accepted the connection but could
+// not run uname, such as an SFTP-only appliance or a Windows server whose
+// shell is cmd.exe.
+const remoteOSNoShell = "unknown"
+
+// remoteOSDefaults returns the options a volume with detect_os gets by
+// default from a server running system, as reported by uname -s. OpenSSH on
+// Linux, the BSDs and macOS supports every extension sshfs relies on and
+// gets none. Windows and servers without a shell often lack the
+// posix-rename extension, so renaming onto an existing file is emulated.
+func remoteOSDefaults(system string) []string {
+	switch {
+	case system == remoteOSNoShell,
+		strings.HasPrefix(system, "CYGWIN"),
+		strings.HasPrefix(system, "MINGW"),
+		strings.HasPrefix(system, "MSYS"),
+		system == "Windows_NT":
+		return []string{"workaround=rename"}
+	}
+	return nil
+}
+
+// detectRemoteOS records the operating system of the volume's server. A
+// volume on a host another volume already detected reuses its result.
+// It runs from provision without the driver lock and only takes the read
+// lock to look for such a volume.
+func (d *sshfsDriver) detectRemoteOS(v *sshfsVolume) error {
+	if system := d.knownRemoteOS(probeKey(v)); system != "" {
+		v.RemoteOS = system
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.RemoteCommandTimeout)
+	defer cancel()
+
+	cmd, err := d.sshCommand(ctx, v, "uname", "-s")
+	if err != nil {
+		return err
+	}
+	logrus.Debug(cmd.Args)
+	output, err := d.executor.Run(cmd)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %v", d.config.RemoteCommandTimeout)
+		}
+		// ssh exits with 255 when it fails itself; any other status is
+		// the remote's answer to a command it could not run.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() == 255 {
+			return fmt.Errorf("%v (%s)", err, strings.TrimSpace(string(output)))
+		}
+		v.RemoteOS = remoteOSNoShell
+		return nil
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		v.RemoteOS = remoteOSNoShell
+		return nil
+	}
+	v.RemoteOS = fields[0]
+	return nil
+}
+
+// knownRemoteOS returns the OS already detected for a volume with the
+// given probe key, or "" when no volume has one.
+func (d *sshfsDriver) knownRemoteOS(key string) string {
+	d.RLock()
+	defer d.RUnlock()
+	for _, other := range d.volumes {
+		if other.RemoteOS != "" && probeKey(other) == key {
+			return other.RemoteOS
+		}
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// exitError returns the error of a command that exited with status.
+func exitError(t *testing.T, status int) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", status)).Run()
+	if err == nil {
+		t.Fatalf("Expected exit status %d", status)
+	}
+	return err
+}
+
+// TestDetectOS tests that detect_os picks mount defaults from uname
+func TestDetectOS(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		err      func(t *testing.T) error
+		options  map[string]string
+		remoteOS string
+		want     string
+		notWant  string
+	}{
+		{
+			name:     "linux gets no defaults",
+			output:   "Linux\n",
+			remoteOS: "Linux",
+			notWant:  "workaround",
+		},
+		{
+			name:     "freebsd gets no defaults",
+			output:   "FreeBSD\n",
+			remoteOS: "FreeBSD",
+			notWant:  "workaround",
+		},
+		{
+			name:     "windows emulates rename",
+			output:   "MINGW64_NT-10.0-19045\n",
+			remoteOS: "MINGW64_NT-10.0-19045",
+			want:     "-o workaround=rename",
+		},
+		{
+			name:     "server without a shell emulates rename",
+			output:   "This service allows sftp connections only.\n",
+			err:      func(t *testing.T) error { return exitError(t, 1) },
+			remoteOS: remoteOSNoShell,
+			want:     "-o workaround=rename",
+		},
+		{
+			name:     "explicit option wins",
+			output:   "MINGW64_NT-10.0-19045\n",
+			options:  map[string]string{"workaround": "none"},
+			remoteOS: "MINGW64_NT-10.0-19045",
+			want:     "-o workaround=none",
+			notWant:  "workaround=rename",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+
+			executor := NewTestCommandExecutor()
+			var err error
+			if tt.err != nil {
+				err = tt.err(t)
+			}
+			executor.AddMockResponse([]byte(tt.output), err)
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			options := map[string]string{"sshcmd": "user@host:/path", "detect_os": "true"}
+			for k, v := range tt.options {
+				options[k] = v
+			}
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host uname -s")
+			AssertEqual(t, tt.remoteOS, driver.volumes["test-volume"].RemoteOS, "remote OS")
+
+			if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+			if tt.want != "" {
+				executor.AssertCommandContains(t, tt.want)
+			}
+			if tt.notWant != "" {
+				AssertNotContains(t, strings.Join(executor.LastCmd().Args, " "), tt.notWant, "sshfs command")
+			}
+
+			resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+			AssertNoError(t, err, "get")
+			AssertEqual(t, tt.remoteOS, resp.Volume.Status["remote_os"], "status remote_os")
+		})
+	}
+
+	t.Run("result is cached per host", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("Linux\n"), nil)
+		driver.executor = executor
+
+		for _, name := range []string{"first", "second"} {
+			options := map[string]string{"sshcmd": "user@host:/" + name, "detect_os": "true"}
+			if err := driver.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+				t.Fatalf("Failed to create volume %s: %v", name, err)
+			}
+		}
+		AssertEqual(t, 1, executor.GetCommandCount(), "uname runs")
+		AssertEqual(t, "Linux", driver.volumes["second"].RemoteOS, "remote OS")
+
+		restarted, err := newSshfsDriver(tmpDir)
+		if err != nil {
+			t.Fatalf("Failed to restart driver: %v", err)
+		}
+		AssertEqual(t, "Linux", restarted.volumes["first"].RemoteOS, "remote OS after restart")
+	})
+
+	t.Run("concurrent creates", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		for i := 0; i < 8; i++ {
+			executor.AddMockResponse([]byte("Linux\n"), nil)
+		}
+		driver.executor = executor
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name := fmt.Sprintf("volume-%d", i)
+				options := map[string]string{"sshcmd": fmt.Sprintf("user@host%d:/data", i), "detect_os": "true"}
+				if err := driver.Create(&volume.CreateRequest{Name: name, Options: options}); err != nil {
+					t.Errorf("Failed to create volume %s: %v", name, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		AssertEqual(t, 8, len(driver.volumes), "volumes")
+	})
+
+	t.Run("unreachable server fails create", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte("ssh: connect to host host port 22: Connection refused"), exitError(t, 255))
+		driver.executor = executor
+
+		err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path", "detect_os": "true"}})
+		if err == nil {
+			t.Fatal("Expected Create to fail")
+		}
+		AssertContains(t, err.Error(), "Connection refused", "create error")
+		AssertFileNotExists(t, driver.statePath)
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// parseRemotePaths parses an ALLOWED_REMOTE_PATHS setting: comma-separated
+// remote path prefixes, each either for every host, like /srv/shared, or
+// for one host, like nas1:/data. The result maps hosts to their prefixes,
+// with "" holding those for every host.
+func parseRemotePaths(val string) (map[string][]string, error) {
+	paths := map[string][]string{}
+	for _, entry := range parseList(val) {
+		host, prefix := "", entry
+		if !strings.HasPrefix(entry, "/") {
+			dest, p := splitSshcmd(entry)
+			host, prefix = remoteHost(dest), p
+			if host == "" {
+				return nil, fmt.Errorf("entry %q has no host", entry)
+			}
+		}
+		if !path.IsAbs(prefix) {
+			return nil, fmt.Errorf("entry %q must name an absolute path", entry)
+		}
+		paths[host] = append(paths[host], path.Clean(prefix))
+	}
+	return paths, nil
+}
+
+// remoteHost returns the host of an ssh destination, without its user and
+// the brackets of an IPv6 address, in lower case.
+func remoteHost(dest string) string {
+	if i := strings.LastIndex(dest, "@"); i >= 0 {
+		dest = dest[i+1:]
+	}
+	return strings.ToLower(strings.Trim(dest, "[]"))
+}
+
+// checkRemotePath rejects a volume whose remote path, on its host or any
+// fallback host, lies outside the prefixes ALLOWED_REMOTE_PATHS allows
+// there. Relative paths, which depend on the remote home directory, are
+// rejected as well.
+func (d *sshfsDriver) checkRemotePath(v *sshfsVolume) error {
+	if len(d.config.AllowedRemotePaths) == 0 {
+		return nil
+	}
+	for _, target := range mountTargets(v) {
+		dest, remotePath := splitSshcmd(target.Sshcmd)
+		host := remoteHost(dest)
+		prefixes := append(append([]string(nil), d.config.AllowedRemotePaths[""]...), d.config.AllowedRemotePaths[host]...)
+		if len(prefixes) == 0 {
+			return fmt.Errorf("no remote paths are allowed on host %s", host)
+		}
+		if !path.IsAbs(remotePath) {
+			return fmt.Errorf("remote path %q on host %s must be absolute", remotePath, host)
+		}
+		allowed := false
+		for _, prefix := range prefixes {
+			if isWithin(prefix, remotePath) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			sort.Strings(prefixes)
+			return fmt.Errorf("remote path %s is not allowed on host %s, only paths below %s", remotePath, host, strings.Join(prefixes, ", "))
+		}
+	}
+	return nil
+}
+
+// redirectOptions are the ssh options that make ssh connect to another
+// host than the one in the sshcmd.
+var redirectOptions = []string{"HostName", "ProxyCommand", "ProxyJump"}
+
+// checkRemoteRedirect rejects, while ALLOWED_REMOTE_PATHS is set, the
+// options of a volume that could point ssh at another host than the one
+// checkRemotePath checked: HostName, ProxyCommand and ProxyJump, an
+// ssh_config, whose host aliases can do the same, and an ssh_command. It
+// runs before the driver adds a ProxyCommand of its own for http_proxy or
+// socks_proxy, which still connects to the sshcmd's host.
+func (d *sshfsDriver) checkRemoteRedirect(v *sshfsVolume) error {
+	if len(d.config.AllowedRemotePaths) == 0 {
+		return nil
+	}
+	key := ""
+	for _, option := range redirectOptions {
+		if hasOption(v, option) {
+			key = option
+			break
+		}
+	}
+	switch {
+	case key != "":
+	case v.SSHConfig != "":
+		key = "ssh_config"
+	case v.SSHCommand != "":
+		key = "ssh_command"
+	default:
+		return nil
+	}
+	return fmt.Errorf("option %s is not allowed with ALLOWED_REMOTE_PATHS, as it could connect to another host", key)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// selfTestResult is the outcome of a SelfTest run. Stage names the step
+// that failed: "mount", "verify", "list" or "unmount".
+type selfTestResult struct {
+	Mounted    bool    `json:"mounted"`
+	Listed     bool    `json:"listed"`
+	Entries    int     `json:"entries"`
+	Unmounted  bool    `json:"unmounted"`
+	DurationMs float64 `json:"duration_ms"`
+	Stage      string  `json:"failed_stage,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// SelfTest mounts the volume from the host in its sshcmd at a temporary
+// mountpoint, checks that the mount is live and its root can be listed, and
+// unmounts it again. The volume's own mountpoint and connections are left
+// alone. Mounting and listing are each bounded by RemoteCommandTimeout. A
+// failed test returns both the result and an error.
+func (d *sshfsDriver) SelfTest(name string) (*selfTestResult, error) {
+	logrus.WithField("method", "self test").Debug(name)
+
+	d.RLock()
+	v, ok := d.volumes[name]
+	if !ok {
+		d.RUnlock()
+		return nil, logError("volume %s: %w", name, ErrVolumeNotFound)
+	}
+	vol := *v
+	d.RUnlock()
+
+	mountpoint, err := os.MkdirTemp(d.root, ".selftest-")
+	if err != nil {
+		return nil, logError("failed to create a temporary mountpoint: %v", err)
+	}
+	defer os.Remove(mountpoint)
+	vol.Mountpoint = mountpoint
+
+	result := &selfTestResult{}
+	start := d.now()
+	err = d.selfTest(name, &vol, result)
+	result.DurationMs = float64(d.now().Sub(start)) / float64(time.Millisecond)
+	if err != nil {
+		result.Error = err.Error()
+		return result, logError("self test of volume %s failed to %s: %v", name, result.Stage, err)
+	}
+	return result, nil
+}
+
+// selfTest runs the steps of SelfTest, recording their progress in result.
+func (d *sshfsDriver) selfTest(name string, vol *sshfsVolume, result *selfTestResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.RemoteCommandTimeout)
+	defer cancel()
+
+	result.Stage = "mount"
+	cmd, err := d.sshfsCommand(name, vol)
+	if err != nil {
+		return err
+	}
+	// The test mount counts against MAX_HOST_MOUNTS like any other, until
+	// it is unmounted again.
+	release, err := d.hostLimit.acquire(ctx, hostOf(vol.Sshcmd), d.config.MaxHostMounts, d.config.HostLimitPolicy != "fail")
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %v waiting for a mount slot of %s", d.config.RemoteCommandTimeout, hostOf(vol.Sshcmd))
+		}
+		return err
+	}
+	defer release()
+	logrus.Debug(cmd.Args)
+	if output, err := runContext(ctx, d.executor, cmd); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %v", d.config.RemoteCommandTimeout)
+		}
+		return fmt.Errorf("%v (%s)", err, output)
+	}
+	result.Mounted = true
+
+	checkErr := d.checkSelfTestMount(vol.Mountpoint, result)
+	if err := d.unmountVolume(vol.Mountpoint); err != nil {
+		if checkErr == nil {
+			result.Stage = "unmount"
+			return err
+		}
+		logrus.WithField("mountpoint", vol.Mountpoint).Warnf("failed to unmount self test: %v", err)
+	} else {
+		result.Unmounted = true
+	}
+	if checkErr == nil {
+		result.Stage = ""
+	}
+	return checkErr
+}
+
+// checkSelfTestMount checks that mountpoint is in the mount table and lists
+// its root, giving up after RemoteCommandTimeout as a dead connection can
+// hang the listing.
+func (d *sshfsDriver) checkSelfTestMount(mountpoint string, result *selfTestResult) error {
+	result.Stage = "verify"
+	mounted, err := d.isMounted(mountpoint)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return fmt.Errorf("%s is not in the mount table", mountpoint)
+	}
+
+	result.Stage = "list"
+	type listing struct {
+		entries []os.DirEntry
+		err     error
+	}
+	done := make(chan listing, 1)
+	go func() {
+		entries, err := os.ReadDir(mountpoint)
+		done <- listing{entries, err}
+	}()
+	select {
+	case l := <-done:
+		if l.err != nil {
+			return l.err
+		}
+		result.Listed = true
+		result.Entries = len(l.entries)
+		return nil
+	case <-time.After(d.config.RemoteCommandTimeout):
+		return fmt.Errorf("timed out after %v", d.config.RemoteCommandTimeout)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestSelfTest tests the mount, verify, list and unmount round-trip
+func TestSelfTest(t *testing.T) {
+	setup := func(t *testing.T, live bool) (*sshfsDriver, string, *TestCommandExecutor) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		driver.mountsPath = filepath.Join(tmpDir, "mounts")
+		if err := os.WriteFile(driver.mountsPath, nil, 0o644); err != nil {
+			t.Fatalf("Failed to write mount table: %v", err)
+		}
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		// Stand in for sshfs: a live mount shows up in the mount table and
+		// serves the remote files.
+		executor.OnRun = func(cmd *exec.Cmd) {
+			if cmd.Args[0] != "sshfs" || !live {
+				return
+			}
+			mountpoint := cmd.Args[3]
+			os.WriteFile(driver.mountsPath, []byte("user@host:/path "+mountpoint+" fuse.sshfs rw 0 0\n"), 0o644)
+			os.WriteFile(filepath.Join(mountpoint, "remote-file"), nil, 0o644)
+		}
+		return driver, tmpDir, executor
+	}
+
+	t.Run("round-trip", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, true)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+
+		result, err := driver.SelfTest("test-volume")
+		AssertNoError(t, err, "self test")
+		AssertEqual(t, true, result.Mounted, "mounted")
+		AssertEqual(t, true, result.Listed, "listed")
+		AssertEqual(t, 1, result.Entries, "entries")
+		AssertEqual(t, true, result.Unmounted, "unmounted")
+		AssertEqual(t, "", result.Stage, "failed stage")
+
+		commands := executor.GetCommands()
+		AssertEqual(t, 2, len(commands), "commands run")
+		mount, unmount := commands[0], commands[1]
+		AssertEqual(t, "sshfs", mount[0], "mount command")
+		mountpoint := mount[3]
+		AssertNotEqual(t, driver.volumes["test-volume"].Mountpoint, mountpoint, "temporary mountpoint")
+		AssertEqual(t, filepath.Join(tmpDir, "volumes"), filepath.Dir(mountpoint), "temporary mountpoint directory")
+		AssertEqual(t, mountpoint, unmount[len(unmount)-1], "unmount target")
+		AssertEqual(t, driver.unmountTool, filepath.Base(unmount[0]), "unmount command")
+
+		AssertEqual(t, 0, driver.volumes["test-volume"].connections, "connections")
+		AssertDirNotExists(t, driver.volumes["test-volume"].Mountpoint)
+	})
+
+	t.Run("mount that is not live is unmounted", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, false)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+
+		result, err := driver.SelfTest("test-volume")
+		AssertError(t, err, "self test")
+		AssertContains(t, err.Error(), "failed to verify", "self test error")
+		AssertEqual(t, "verify", result.Stage, "failed stage")
+		AssertEqual(t, true, result.Mounted, "mounted")
+		AssertEqual(t, false, result.Listed, "listed")
+		AssertEqual(t, true, result.Unmounted, "unmounted")
+		AssertContains(t, result.Error, "is not in the mount table", "result error")
+		AssertEqual(t, 2, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("failed mount", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, false)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse([]byte("Connection reset by peer"), errors.New("exit status 1"))
+
+		result, err := driver.SelfTest("test-volume")
+		AssertError(t, err, "self test")
+		AssertEqual(t, "mount", result.Stage, "failed stage")
+		AssertEqual(t, false, result.Mounted, "mounted")
+		AssertContains(t, result.Error, "Connection reset by peer", "result error")
+		AssertEqual(t, 1, executor.GetCommandCount(), "commands run")
+
+		entries, err := os.ReadDir(filepath.Join(tmpDir, "volumes"))
+		AssertNoError(t, err, "read volumes root")
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".selftest-") {
+				t.Errorf("Expected temporary mountpoint %s to be removed", e.Name())
+			}
+		}
+	})
+
+	t.Run("host limit applies", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, true)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.MaxHostMounts = 1
+		driver.config.HostLimitPolicy = "fail"
+
+		release, err := driver.hostLimit.acquire(context.Background(), "host", 1, false)
+		AssertNoError(t, err, "take the host's slot")
+		result, err := driver.SelfTest("test-volume")
+		AssertError(t, err, "self test while the host is at its limit")
+		AssertEqual(t, "mount", result.Stage, "failed stage")
+		AssertContains(t, result.Error, "too many concurrent mounts", "result error")
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		release()
+
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		_, err = driver.SelfTest("test-volume")
+		AssertNoError(t, err, "self test")
+		release, err = driver.hostLimit.acquire(context.Background(), "host", 1, false)
+		AssertNoError(t, err, "slot after the self test")
+		release()
+	})
+
+	t.Run("unknown volume", func(t *testing.T) {
+		driver, tmpDir, _ := setup(t, true)
+		defer cleanupTestDriver(tmpDir)
+
+		_, err := driver.SelfTest("missing")
+		AssertEqual(t, true, errors.Is(err, ErrVolumeNotFound), "not found error")
+	})
+	t.Run("served on the control API", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, true)
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse([]byte("Connection reset by peer"), errors.New("exit status 1"))
+
+		post := func(name string) (int, selfTestResult) {
+			rec := httptest.NewRecorder()
+			driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/volumes/"+name+"/selftest", nil))
+			var result selfTestResult
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			return rec.Code, result
+		}
+
+		code, result := post("test-volume")
+		AssertEqual(t, http.StatusOK, code, "status")
+		AssertEqual(t, true, result.Unmounted, "unmounted")
+
+		code, result = post("test-volume")
+		AssertEqual(t, http.StatusBadGateway, code, "status of a failed test")
+		AssertEqual(t, "mount", result.Stage, "failed stage")
+		AssertContains(t, result.Error, "Connection reset by peer", "result error")
+
+		code, _ = post("missing")
+		AssertEqual(t, http.StatusNotFound, code, "status of an unknown volume")
+	})
+}
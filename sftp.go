@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sftpRefused is how ssh reports a server that accepted the session but
+// has no sftp subsystem, which sshfs needs for every file operation. ssh
+// logs it as an error, which -q would suppress.
+const sftpRefused = "subsystem request failed"
+
+// checkSFTP starts the sftp subsystem on the volume's host, the way sshfs
+// does, and records in result whether it came up. With its input closed,
+// a working sftp server exits as soon as it started.
+func (d *sshfsDriver) checkSFTP(vol *sshfsVolume, result *connectionResult) error {
+	key := probeKey(vol)
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.RemoteCommandTimeout)
+	defer cancel()
+
+	cmd, err := d.sshCommand(ctx, vol, "-s", "sftp")
+	if err != nil {
+		return logError("%s", err.Error())
+	}
+	logrus.Debug(cmd.Args)
+	output, err := d.executor.Run(cmd)
+	if err == nil {
+		result.SFTPOK = true
+		return nil
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.ErrorClass = "timeout"
+	case strings.Contains(string(output), sftpRefused):
+		result.ErrorClass = "sftp"
+		result.Error = fmt.Sprintf("%s accepted the ssh session but its sftp subsystem is disabled or missing", key)
+		return logError("%s: %s", result.Error, strings.TrimSpace(string(output)))
+	default:
+		classifyConnection(result, output)
+	}
+	result.Error = strings.TrimSpace(fmt.Sprintf("%v %s", err, output))
+	return logError("sftp check of %s failed: %v (%s)", key, err, output)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestVerifySFTP tests that verify_sftp tells a disabled sftp subsystem
+// apart from other connection failures
+func TestVerifySFTP(t *testing.T) {
+	create := func(t *testing.T, driver *sshfsDriver) error {
+		t.Helper()
+		return driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", "verify_sftp": "true"},
+		})
+	}
+
+	t.Run("working subsystem", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		if err := create(t, driver); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host true")
+		executor.AssertCommand(t, "ssh -oStrictHostKeyChecking=no -oLogLevel=ERROR user@host -s sftp")
+
+		// The session probe is cached, the sftp check is not.
+		result, err := driver.TestConnection("test-volume")
+		AssertNoError(t, err, "test connection")
+		AssertEqual(t, true, result.Cached, "cached session probe")
+		AssertEqual(t, true, result.SFTPOK, "sftp ok")
+		AssertEqual(t, 3, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("disabled subsystem", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse([]byte("subsystem request failed on channel 0\n"), exitError(t, 255))
+		driver.executor = executor
+
+		err := create(t, driver)
+		AssertError(t, err, "create")
+		AssertContains(t, err.Error(), "sftp subsystem is disabled or missing", "create error")
+		args := executor.LastCmd().Args
+		AssertContains(t, strings.Join(args, " "), "-oLogLevel=ERROR", "sftp check args")
+		for _, arg := range args {
+			if arg == "-q" {
+				t.Errorf("Expected the sftp check to keep ssh errors, got %v", args)
+			}
+		}
+		if _, ok := driver.volumes["test-volume"]; ok {
+			t.Error("Expected the volume not to be created")
+		}
+
+		// The session itself worked, so its probe stays cached.
+		driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path", VerifySFTP: true}
+		executor.AddMockResponse([]byte("subsystem request failed on channel 0\n"), exitError(t, 255))
+		result, err := driver.TestConnection("test-volume")
+		AssertError(t, err, "test connection")
+		AssertEqual(t, true, result.Cached, "cached session probe")
+		AssertEqual(t, "sftp", result.ErrorClass, "error class")
+		AssertEqual(t, true, result.AuthOK, "auth ok")
+		AssertEqual(t, false, result.SFTPOK, "sftp ok")
+	})
+
+	t.Run("other failures keep their class", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		executor.AddMockResponse([]byte("ssh: connect to host host port 22: Connection refused\n"), exitError(t, 255))
+		driver.executor = executor
+		driver.volumes["test-volume"] = &sshfsVolume{Sshcmd: "user@host:/path", VerifySFTP: true}
+
+		result, err := driver.TestConnection("test-volume")
+		AssertError(t, err, "test connection")
+		AssertEqual(t, "unreachable", result.ErrorClass, "error class")
+	})
+}
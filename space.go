@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parseDf parses the size and available bytes out of `df -P -B1` output.
+func parseDf(output []byte) (total, avail int64, err error) {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) < 2 {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 6 {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	if total, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	if avail, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	return total, avail, nil
+}
+
+// belowMinFree reports whether avail of total bytes breaks either of the
+// volume's free-space thresholds.
+func belowMinFree(v *sshfsVolume, total, avail int64) bool {
+	if v.MinFreeBytes > 0 && avail < v.MinFreeBytes {
+		return true
+	}
+	return v.MinFreePercent > 0 && avail*100 < total*int64(v.MinFreePercent)
+}
+
+// checkSpace reports whether the named volume's remote has less free space
+// than its min_free_bytes or min_free_percent allow. sshfs passes the
+// remote's figures through statfs, so df on the mountpoint measures the
+// remote. Only mounted volumes with a threshold are checked.
+func (d *sshfsDriver) checkSpace(name string) bool {
+	d.RLock()
+	v, ok := d.volumes[name]
+	if !ok || v.connections == 0 || (v.MinFreeBytes == 0 && v.MinFreePercent == 0) {
+		d.RUnlock()
+		return false
+	}
+	vol := *v
+	d.RUnlock()
+
+	cmd := d.inMountNamespace(exec.Command("df", "-P", "-B1", vol.Mountpoint))
+	logrus.Debug(cmd.Args)
+	output, err := d.executor.Run(cmd)
+	if err != nil {
+		logrus.WithField("volume", name).Debugf("df failed: %v (%s)", err, output)
+		return false
+	}
+	total, avail, err := parseDf(output)
+	if err != nil {
+		logrus.WithField("volume", name).Debug(err)
+		return false
+	}
+
+	low := belowMinFree(&vol, total, avail)
+	if low {
+		logrus.WithField("volume", name).Warnf("remote is low on space: %d of %d bytes free", avail, total)
+	}
+	d.Lock()
+	if v, ok := d.volumes[name]; ok {
+		v.lowSpace = low
+	}
+	d.Unlock()
+	return low
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// dfOutput is df -P -B1 output for a 1000000 byte filesystem
+func dfOutput(avail string) []byte {
+	return []byte("Filesystem 1-blocks Used Available Capacity Mounted on\n" +
+		"user@host:/path 1000000 0 " + avail + " 0% /mnt/volumes/test\n")
+}
+
+// TestFreeSpaceThresholds tests low_space reporting against min_free thresholds
+func TestFreeSpaceThresholds(t *testing.T) {
+	setup := func(t *testing.T, options map[string]string) (*sshfsDriver, string, *TestCommandExecutor) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse(nil, nil)
+		driver.executor = executor
+
+		options["sshcmd"] = "user@host:/path"
+		if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: options}); err != nil {
+			t.Fatalf("Failed to create volume: %v", err)
+		}
+		if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to mount volume: %v", err)
+		}
+		return driver, tmpDir, executor
+	}
+	get := func(t *testing.T, driver *sshfsDriver) map[string]interface{} {
+		t.Helper()
+		resp, err := driver.Get(&volume.GetRequest{Name: "test-volume"})
+		if err != nil {
+			t.Fatalf("Failed to get volume: %v", err)
+		}
+		return resp.Volume.Status
+	}
+
+	t.Run("below threshold warns and flags low space", func(t *testing.T) {
+		for _, options := range []map[string]string{{"min_free_percent": "10"}, {"min_free_bytes": "200000"}} {
+			driver, tmpDir, executor := setup(t, options)
+			hook := test.NewGlobal()
+			executor.AddMockResponse(dfOutput("50000"), nil)
+
+			status := get(t, driver)
+			AssertEqual(t, true, status["low_space"], "low_space status")
+			executor.AssertCommand(t, "df -P -B1 "+driver.volumes["test-volume"].Mountpoint)
+			if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.WarnLevel {
+				t.Errorf("Expected a warning, got %v", entry)
+			} else {
+				AssertContains(t, entry.Message, "low on space", "warning")
+			}
+
+			rec := httptest.NewRecorder()
+			driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			AssertContains(t, rec.Body.String(), `sshfs_low_space{volume="test-volume"} 1`, "metrics")
+
+			hook.Reset()
+			cleanupTestDriver(tmpDir)
+		}
+	})
+
+	t.Run("above threshold is not low", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, map[string]string{"min_free_percent": "10", "min_free_bytes": "1000"})
+		defer cleanupTestDriver(tmpDir)
+		executor.AddMockResponse(dfOutput("500000"), nil)
+
+		AssertEqual(t, false, get(t, driver)["low_space"], "low_space status")
+	})
+
+	t.Run("health check refreshes low space", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, map[string]string{"min_free_percent": "10"})
+		defer cleanupTestDriver(tmpDir)
+		metrics := func() string {
+			rec := httptest.NewRecorder()
+			driver.controlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			return rec.Body.String()
+		}
+
+		executor.AddMockResponse(dfOutput("50000"), nil)
+		AssertEqual(t, 0, driver.checkHealth(), "degraded mounts")
+		AssertContains(t, metrics(), `sshfs_low_space{volume="test-volume"} 1`, "metrics when low")
+
+		executor.AddMockResponse(dfOutput("500000"), nil)
+		driver.checkHealth()
+		AssertContains(t, metrics(), `sshfs_low_space{volume="test-volume"} 0`, "metrics after space was freed")
+
+		executor.AddMockResponse(dfOutput("50000"), nil)
+		driver.checkHealth()
+		executor.AddMockResponse(nil, nil)
+		if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+			t.Fatalf("Failed to unmount volume: %v", err)
+		}
+		AssertContains(t, metrics(), `sshfs_low_space{volume="test-volume"} 0`, "metrics after unmount")
+	})
+
+	t.Run("volumes without thresholds are not checked", func(t *testing.T) {
+		driver, tmpDir, executor := setup(t, map[string]string{})
+		defer cleanupTestDriver(tmpDir)
+
+		if _, ok := get(t, driver)["low_space"]; ok {
+			t.Error("Expected no low_space status")
+		}
+		AssertEqual(t, 1, executor.GetCommandCount(), "commands run")
+	})
+
+	t.Run("invalid thresholds are rejected", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+
+		for _, opts := range []map[string]string{
+			{"min_free_bytes": "-1"},
+			{"min_free_bytes": "10G"},
+			{"min_free_percent": "101"},
+			{"min_free_percent": "half"},
+		} {
+			opts["sshcmd"] = "user@host:/path"
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: opts}); err == nil {
+				t.Errorf("Expected options %v to be rejected", opts)
+			}
+		}
+	})
+}
+
+// TestParseDf tests parsing of df output
+func TestParseDf(t *testing.T) {
+	total, avail, err := parseDf(dfOutput("1234"))
+	if err != nil {
+		t.Fatalf("Failed to parse df output: %v", err)
+	}
+	AssertEqual(t, int64(1000000), total, "total")
+	AssertEqual(t, int64(1234), avail, "available")
+
+	if _, _, err := parseDf([]byte("df: /mnt: Transport endpoint is not connected\n")); err == nil {
+		t.Error("Expected error for unexpected df output")
+	}
+}
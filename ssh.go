@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+// splitSshcmd splits an sshcmd of the form [user@]host:[path] into the ssh
+// destination and the remote path. IPv6 hosts may be given in brackets.
+func splitSshcmd(sshcmd string) (dest, remotePath string) {
+	inBrackets := false
+	for i, c := range sshcmd {
+		switch c {
+		case '[':
+			inBrackets = true
+		case ']':
+			inBrackets = false
+		case ':':
+			if !inBrackets {
+				return sshcmd[:i], sshcmd[i+1:]
+			}
+		}
+	}
+	return sshcmd, ""
+}
+
+// canonicalSshcmd cleans the remote path of an sshcmd, so that equivalent
+// spellings such as host:/data and host:/data/ mount the same directory.
+// An empty path, meaning the remote home directory, is kept as is.
+func canonicalSshcmd(sshcmd string) string {
+	dest, remotePath := splitSshcmd(sshcmd)
+	if remotePath == "" {
+		return sshcmd
+	}
+	return dest + ":" + path.Clean(remotePath)
+}
+
+// isSSHOption reports whether a "key=value" volume option is meant for ssh
+// rather than sshfs or FUSE. ssh options are CamelCase, the others are not.
+func isSSHOption(option string) bool {
+	r := []rune(option)
+	return len(r) > 0 && unicode.IsUpper(r[0])
+}
+
+// sshCommand builds an ssh invocation of remoteCmd against the volume's
+// host, using the same port, config and ssh options the mount would use.
+func (d *sshfsDriver) sshCommand(ctx context.Context, v *sshfsVolume, remoteCmd ...string) (*exec.Cmd, error) {
+	return d.sshCommandWith(ctx, v, nil, remoteCmd...)
+}
+
+// sshCommandWith is sshCommand with further ssh arguments, such as those of
+// connection multiplexing, placed before the destination.
+func (d *sshfsDriver) sshCommandWith(ctx context.Context, v *sshfsVolume, sshArgs []string, remoteCmd ...string) (*exec.Cmd, error) {
+	dest, _ := splitSshcmd(v.Sshcmd)
+
+	// LogLevel=ERROR drops banners and warnings from the output but keeps
+	// the errors probes and the sftp check classify failures by.
+	args := append(d.hostKeyOptions(v), "-oLogLevel=ERROR")
+	if v.Port != "" {
+		args = append(args, "-p", v.Port)
+	}
+	if v.SSHConfig != "" {
+		sshConfig, err := d.expandPath(v.SSHConfig)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-F", sshConfig)
+	}
+	credentials, err := d.credentialOptions(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range credentials {
+		args = append(args, "-o", option)
+	}
+	for _, option := range keepaliveOptions(v, false) {
+		if !isSSHOption(option) {
+			continue
+		}
+		option, err := d.expandOption(option)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-o", option)
+	}
+	args = append(args, sshArgs...)
+	args = append(args, dest)
+	args = append(args, remoteCmd...)
+
+	password, err := d.volumePassword(v)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	if password != "" {
+		cmd.Env = d.askpassEnv(password)
+	}
+	if err := applyVolumeEnv(cmd, v); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// runSetupCommand runs the volume's setup_command on its host.
+func (d *sshfsDriver) runSetupCommand(v *sshfsVolume) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.RemoteCommandTimeout)
+	defer cancel()
+
+	cmd, err := d.sshCommand(ctx, v, v.SetupCommand)
+	if err != nil {
+		return err
+	}
+	logrus.Debug(cmd.Args)
+	if output, err := d.executor.Run(cmd); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %v", d.config.RemoteCommandTimeout)
+		}
+		return fmt.Errorf("%v (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// askpassPasswordEnv carries the password to the askpass helper.
+const askpassPasswordEnv = "SSHFS_ASKPASS_PASSWORD"
+
+// askpassShellEnv holds the real shell while SHELL points at the driver
+// binary, which strips the password before running it; see askpassShell.
+const askpassShellEnv = "SSHFS_ASKPASS_SHELL"
+
+// askpassPath returns the path of the driver binary, which doubles as the
+// SSH_ASKPASS helper.
+func askpassPath() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return "/docker-volume-sshfs"
+	}
+	return exe
+}
+
+// askpassEnv returns the environment for an sshfs or ssh process that
+// answers ssh's password prompts through the askpass helper. Unlike sshfs's
+// password_stdin this also satisfies keyboard-interactive authentication,
+// which PAM-based servers often require. ssh runs ProxyCommand,
+// LocalCommand and Match exec through $SHELL, so SHELL is pointed at the
+// driver binary too, which keeps the password from those commands.
+func (d *sshfsDriver) askpassEnv(password string) []string {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	env := append(os.Environ(),
+		"SSH_ASKPASS="+d.askpass,
+		"SSH_ASKPASS_REQUIRE=force",
+		askpassPasswordEnv+"="+password,
+		"SHELL="+d.askpass,
+		askpassShellEnv+"="+shell,
+	)
+	// ssh before 8.4 ignores SSH_ASKPASS_REQUIRE and needs DISPLAY instead.
+	if os.Getenv("DISPLAY") == "" {
+		env = append(env, "DISPLAY=:0")
+	}
+	return env
+}
+
+// askpassShell returns the command line and environment of the real shell
+// for the driver binary run by ssh as SHELL with args, such as
+// ["-c", "exec nc proxy 1080"]: the askpass variables are dropped, so the
+// command neither sees the password nor runs the helper, and SHELL is the
+// real shell again.
+func askpassShell(shell string, args, environ []string) ([]string, []string) {
+	var env []string
+	for _, pair := range environ {
+		switch name, _, _ := strings.Cut(pair, "="); name {
+		case "SHELL", "SSH_ASKPASS", "SSH_ASKPASS_REQUIRE", askpassPasswordEnv, askpassShellEnv:
+		default:
+			env = append(env, pair)
+		}
+	}
+	env = append(env, "SHELL="+shell)
+	return append([]string{shell}, args...), env
+}
+
+// volumePassword returns the password to authenticate the volume with,
+// reading it from password_file, or from the password_file of its host's
+// entry in CREDENTIALS_FILE, when one is configured. A volume whose
+// password was not persisted has none after a restart, which is an error
+// rather than a silent fallback to other authentication, as is a password
+// on a driver with sshpass disabled.
+func (d *sshfsDriver) volumePassword(v *sshfsVolume) (string, error) {
+	if d.config.DisableSshpass && (v.Password != "" || v.PasswordFile != "") {
+		return "", fmt.Errorf("password authentication is disabled; use a key or ssh agent")
+	}
+	if v.Password != "" {
+		return v.Password, nil
+	}
+	if v.PasswordFile != "" {
+		return d.readPasswordFile(v.PasswordFile)
+	}
+	if v.NoPersistPassword {
+		return "", fmt.Errorf("password was not persisted; create the volume again with the same options or supply it with password_file")
+	}
+	c, err := d.volumeCredentials(v)
+	if err != nil || c == nil || c.PasswordFile == "" {
+		return "", err
+	}
+	if d.config.DisableSshpass {
+		return "", fmt.Errorf("password authentication is disabled; use a key or ssh agent")
+	}
+	return d.readPasswordFile(c.PasswordFile)
+}
+
+// readPasswordFile reads a password from a file, without its trailing line
+// break.
+func (d *sshfsDriver) readPasswordFile(path string) (string, error) {
+	p, err := d.expandPath(path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password_file: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -21,9 +22,15 @@ func (e *RealCommandExecutor) Execute(name string, args ...string) ([]byte, erro
 	return cmd.CombinedOutput()
 }
 
-// TestCommandExecutor is a mock for testing
+// TestCommandExecutor is a mock for testing. It is safe for concurrent use.
 type TestCommandExecutor struct {
+	mu sync.Mutex
+	// OnRun, when set, is called with each command passed to Run before
+	// its mock response is returned
+	OnRun func(cmd *exec.Cmd)
+
 	commands [][]string
+	cmds     []*exec.Cmd
 	outputs  [][]byte
 	errors   []error
 	callIdx  int
@@ -39,11 +46,19 @@ func NewTestCommandExecutor() *TestCommandExecutor {
 }
 
 func (e *TestCommandExecutor) AddMockResponse(output []byte, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.outputs = append(e.outputs, output)
 	e.errors = append(e.errors, err)
 }
 
 func (e *TestCommandExecutor) Execute(name string, args ...string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.execute(name, args...)
+}
+
+func (e *TestCommandExecutor) execute(name string, args ...string) ([]byte, error) {
 	fullCmd := append([]string{name}, args...)
 	e.commands = append(e.commands, fullCmd)
 
@@ -57,16 +72,46 @@ func (e *TestCommandExecutor) Execute(name string, args ...string) ([]byte, erro
 	return nil, fmt.Errorf("no mock response configured for call %d", e.callIdx)
 }
 
+// Run implements CommandExecutor, keeping the command so tests can inspect
+// its environment and input as well as its arguments
+func (e *TestCommandExecutor) Run(cmd *exec.Cmd) ([]byte, error) {
+	if e.OnRun != nil {
+		e.OnRun(cmd)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cmds = append(e.cmds, cmd)
+	return e.execute(cmd.Args[0], cmd.Args[1:]...)
+}
+
+// LastCmd returns the most recent command passed to Run
+func (e *TestCommandExecutor) LastCmd() *exec.Cmd {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.cmds) == 0 {
+		return nil
+	}
+	return e.cmds[len(e.cmds)-1]
+}
+
 func (e *TestCommandExecutor) GetCommands() [][]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	return e.commands
 }
 
 func (e *TestCommandExecutor) GetCommandCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	return len(e.commands)
 }
 
 func (e *TestCommandExecutor) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.commands = make([][]string, 0)
+	e.cmds = nil
 	e.outputs = make([][]byte, 0)
 	e.errors = make([]error, 0)
 	e.callIdx = 0
@@ -75,6 +120,8 @@ func (e *TestCommandExecutor) Reset() {
 // AssertCommand verifies that a specific command was executed
 func (e *TestCommandExecutor) AssertCommand(t *testing.T, expectedCmd string) bool {
 	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	for _, cmd := range e.commands {
 		if strings.Join(cmd, " ") == expectedCmd {
 			return true
@@ -87,6 +134,8 @@ func (e *TestCommandExecutor) AssertCommand(t *testing.T, expectedCmd string) bo
 // AssertCommandContains verifies that a command containing the substring was executed
 func (e *TestCommandExecutor) AssertCommandContains(t *testing.T, substring string) bool {
 	t.Helper()
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	for _, cmd := range e.commands {
 		if strings.Contains(strings.Join(cmd, " "), substring) {
 			return true
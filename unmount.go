@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unmountTools are the supported unmount tools in their default order of
+// preference.
+var unmountTools = []string{"fusermount3", "fusermount", "umount"}
+
+// parseUnmountTools parses a comma-separated preference list of unmount
+// tools.
+func parseUnmountTools(val string) ([]string, error) {
+	var tools []string
+	for _, tool := range strings.Split(val, ",") {
+		tool = strings.TrimSpace(tool)
+		if !isUnmountTool(tool) {
+			return nil, fmt.Errorf("unsupported unmount tool %q", tool)
+		}
+		tools = append(tools, tool)
+	}
+	return tools, nil
+}
+
+func isUnmountTool(tool string) bool {
+	for _, t := range unmountTools {
+		if t == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// selectUnmountTool returns the first of the preferred tools that lookPath
+// can find.
+func selectUnmountTool(preferred []string, lookPath func(string) (string, error)) (string, error) {
+	for _, tool := range preferred {
+		if _, err := lookPath(tool); err == nil {
+			return tool, nil
+		}
+	}
+	return "", fmt.Errorf("none of the unmount tools %s is installed", strings.Join(preferred, ", "))
+}
+
+// unmountCommand builds the command that unmounts target with tool. A lazy
+// unmount detaches the mount even while it is busy.
+func unmountCommand(tool, target string, lazy bool) *exec.Cmd {
+	if tool == "umount" {
+		if lazy {
+			return exec.Command(tool, "-l", target)
+		}
+		return exec.Command(tool, target)
+	}
+	if lazy {
+		return exec.Command(tool, "-uz", target)
+	}
+	return exec.Command(tool, "-u", target)
+}
+
+// unmountStep is one attempted unmount command and how it failed.
+type unmountStep struct {
+	Args   []string
+	Output string
+	Err    error
+}
+
+// UnmountError is returned when every step of an unmount failed.
+type UnmountError struct {
+	Target string
+	Steps  []unmountStep
+}
+
+func (e *UnmountError) Error() string {
+	steps := make([]string, len(e.Steps))
+	for i, step := range e.Steps {
+		steps[i] = fmt.Sprintf("%s: %v (%s)", strings.Join(step.Args, " "), step.Err, step.Output)
+	}
+	return fmt.Sprintf("umount of %s failed: %s", e.Target, strings.Join(steps, "; "))
+}
+
+// busy reports whether a step failed because the mount is in use.
+func (e *UnmountError) busy() bool {
+	for _, step := range e.Steps {
+		if strings.Contains(strings.ToLower(step.Output), "busy") {
+			return true
+		}
+	}
+	return false
+}
+
+// unmountChain returns the tools to unmount with in order: the selected
+// tool, then plain umount when that is installed as well.
+func (d *sshfsDriver) unmountChain() []string {
+	tools := []string{d.unmountTool}
+	if d.unmountTool != "umount" {
+		if _, err := d.lookPath("umount"); err == nil {
+			tools = append(tools, "umount")
+		}
+	}
+	return tools
+}
+
+// unmountVolume unmounts target, escalating through the unmount tools and,
+// if the mount turned out to be busy, through lazy unmounts with each, which
+// detach the mount even while a process holds it open. Once it is
+// unmounted, the host slot the mount held is freed.
+func (d *sshfsDriver) unmountVolume(target string) error {
+	uerr := &UnmountError{Target: target}
+	tools := d.unmountChain()
+	for _, lazy := range []bool{false, true} {
+		if lazy && !uerr.busy() {
+			break
+		}
+		for _, tool := range tools {
+			cmd := d.inMountNamespace(unmountCommand(tool, target, lazy))
+			logrus.Debug(cmd.Args)
+			output, err := d.executor.Run(cmd)
+			if err == nil {
+				d.hostLimit.drop(target)
+				return nil
+			}
+			logrus.Warnf("%s failed: %v (%s)", strings.Join(cmd.Args, " "), err, output)
+			uerr.Steps = append(uerr.Steps, unmountStep{Args: cmd.Args, Output: strings.TrimSpace(string(output)), Err: err})
+		}
+	}
+	return uerr
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// TestUnmountTool tests selection of the unmount tool and the commands built with it
+func TestUnmountTool(t *testing.T) {
+	lookPath := func(installed ...string) func(string) (string, error) {
+		return func(name string) (string, error) {
+			for _, i := range installed {
+				if i == name {
+					return "/usr/bin/" + name, nil
+				}
+			}
+			return "", exec.ErrNotFound
+		}
+	}
+
+	t.Run("auto-detection honors availability", func(t *testing.T) {
+		tests := []struct {
+			installed []string
+			expected  string
+		}{
+			{[]string{"fusermount3", "fusermount", "umount"}, "fusermount3"},
+			{[]string{"fusermount", "umount"}, "fusermount"},
+			{[]string{"umount"}, "umount"},
+		}
+		for _, tt := range tests {
+			tool, err := selectUnmountTool(unmountTools, lookPath(tt.installed...))
+			if err != nil {
+				t.Fatalf("Failed to select unmount tool from %v: %v", tt.installed, err)
+			}
+			if tool != tt.expected {
+				t.Errorf("Expected %s with %v installed, got %s", tt.expected, tt.installed, tool)
+			}
+		}
+	})
+
+	t.Run("configured preference order", func(t *testing.T) {
+		tools, err := parseUnmountTools("umount, fusermount3")
+		if err != nil {
+			t.Fatalf("Failed to parse unmount tools: %v", err)
+		}
+		tool, err := selectUnmountTool(tools, lookPath("fusermount3", "umount"))
+		if err != nil {
+			t.Fatalf("Failed to select unmount tool: %v", err)
+		}
+		if tool != "umount" {
+			t.Errorf("Expected umount, got %s", tool)
+		}
+	})
+
+	t.Run("no tool installed", func(t *testing.T) {
+		if _, err := selectUnmountTool(unmountTools, lookPath()); err == nil {
+			t.Error("Expected error when no unmount tool is installed")
+		}
+	})
+
+	t.Run("unsupported tool", func(t *testing.T) {
+		if _, err := parseUnmountTools("fusermount,rm"); err == nil {
+			t.Error("Expected error for unsupported unmount tool")
+		}
+	})
+
+	t.Run("selected tool is used by Unmount", func(t *testing.T) {
+		tests := map[string][]string{
+			"fusermount3": {"fusermount3", "-u"},
+			"fusermount":  {"fusermount", "-u"},
+			"umount":      {"umount"},
+		}
+		for tool, prefix := range tests {
+			driver, tmpDir := setupTestDriver(t)
+			driver.unmountTool = tool
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse(nil, nil)
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			if err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: map[string]string{"sshcmd": "user@host:/path"}}); err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			if _, err := driver.Mount(&volume.MountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to mount volume: %v", err)
+			}
+			if err := driver.Unmount(&volume.UnmountRequest{Name: "test-volume", ID: "c1"}); err != nil {
+				t.Fatalf("Failed to unmount volume: %v", err)
+			}
+
+			expected := append(prefix, driver.volumes["test-volume"].Mountpoint)
+			AssertEqual(t, fmt.Sprint(expected), fmt.Sprint(executor.LastCmd().Args), "unmount command")
+			cleanupTestDriver(tmpDir)
+		}
+	})
+
+	t.Run("lazy fallback", func(t *testing.T) {
+		tests := map[string]string{
+			"fusermount3": "-uz",
+			"umount":      "-l",
+		}
+		for tool, flag := range tests {
+			driver, tmpDir := setupTestDriver(t)
+			driver.unmountTool = tool
+			driver.lookPath = lookPath(tool)
+
+			executor := NewTestCommandExecutor()
+			executor.AddMockResponse([]byte("target is busy"), fmt.Errorf("exit status 1"))
+			executor.AddMockResponse(nil, nil)
+			driver.executor = executor
+
+			if err := driver.unmountVolume("/mnt/volumes/abc"); err != nil {
+				t.Fatalf("Expected lazy unmount to succeed with %s, got %v", tool, err)
+			}
+			AssertEqual(t, fmt.Sprint([]string{tool, flag, "/mnt/volumes/abc"}), fmt.Sprint(executor.LastCmd().Args), "lazy unmount command")
+
+			executor.Reset()
+			executor.AddMockResponse([]byte("target is busy"), fmt.Errorf("exit status 1"))
+			executor.AddMockResponse([]byte("not mounted"), fmt.Errorf("exit status 1"))
+			err := driver.unmountVolume("/mnt/volumes/abc")
+			if err == nil {
+				t.Errorf("Expected error when lazy unmount with %s also fails", tool)
+			} else {
+				AssertContains(t, err.Error(), "target is busy", "unmount error")
+			}
+			cleanupTestDriver(tmpDir)
+		}
+	})
+}
+
+// TestUnmountEscalation tests each step of the unmount escalation
+func TestUnmountEscalation(t *testing.T) {
+	const target = "/mnt/volumes/abc"
+	busy := func() ([]byte, error) { return []byte("Device or resource busy"), fmt.Errorf("exit status 1") }
+	failed := func() ([]byte, error) { return []byte("permission denied"), fmt.Errorf("exit status 1") }
+	ok := func() ([]byte, error) { return nil, nil }
+
+	tests := []struct {
+		name      string
+		responses []func() ([]byte, error)
+		commands  []string
+		steps     int
+	}{
+		{"fusermount succeeds", []func() ([]byte, error){ok},
+			[]string{"fusermount3 -u " + target}, 0},
+		{"umount after fusermount fails", []func() ([]byte, error){failed, ok},
+			[]string{"fusermount3 -u " + target, "umount " + target}, 0},
+		{"lazy fusermount when busy", []func() ([]byte, error){busy, busy, ok},
+			[]string{"fusermount3 -u " + target, "umount " + target, "fusermount3 -uz " + target}, 0},
+		{"lazy umount last", []func() ([]byte, error){busy, busy, failed, ok},
+			[]string{"fusermount3 -u " + target, "umount " + target, "fusermount3 -uz " + target, "umount -l " + target}, 0},
+		{"every step fails", []func() ([]byte, error){busy, busy, failed, failed},
+			[]string{"fusermount3 -u " + target, "umount " + target, "fusermount3 -uz " + target, "umount -l " + target}, 4},
+		{"no lazy attempt unless busy", []func() ([]byte, error){failed, failed},
+			[]string{"fusermount3 -u " + target, "umount " + target}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+			driver.unmountTool = "fusermount3"
+			driver.lookPath = func(name string) (string, error) { return "/usr/bin/" + name, nil }
+
+			executor := NewTestCommandExecutor()
+			for _, response := range tt.responses {
+				executor.AddMockResponse(response())
+			}
+			driver.executor = executor
+
+			err := driver.unmountVolume(target)
+			var commands []string
+			for _, cmd := range executor.GetCommands() {
+				commands = append(commands, strings.Join(cmd, " "))
+			}
+			AssertEqual(t, strings.Join(tt.commands, "\n"), strings.Join(commands, "\n"), "unmount commands")
+
+			if tt.steps == 0 {
+				if err != nil {
+					t.Fatalf("Expected unmount to succeed, got %v", err)
+				}
+				return
+			}
+			var uerr *UnmountError
+			if !errors.As(err, &uerr) {
+				t.Fatalf("Expected an UnmountError, got %v", err)
+			}
+			AssertEqual(t, tt.steps, len(uerr.Steps), "failed steps")
+			AssertEqual(t, target, uerr.Target, "target")
+			AssertContains(t, err.Error(), "permission denied", "unmount error")
+		})
+	}
+}
+
+// TestUnmountUnknownVolume tests UNKNOWN_UNMOUNT_POLICY
+func TestUnmountUnknownVolume(t *testing.T) {
+	t.Run("ignore", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		executor := NewTestCommandExecutor()
+		driver.executor = executor
+
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		err := driver.Unmount(&volume.UnmountRequest{Name: "missing", ID: "c1"})
+		AssertNoError(t, err, "unmount of an unknown volume")
+		AssertEqual(t, 0, executor.GetCommandCount(), "commands run")
+		entry := hook.LastEntry()
+		if entry == nil {
+			t.Fatal("Expected the unknown volume to be logged")
+		}
+		AssertEqual(t, logrus.WarnLevel, entry.Level, "log level")
+		AssertEqual(t, "missing", entry.Data["volume"], "logged volume")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.UnknownUnmountPolicy = "error"
+
+		err := driver.Unmount(&volume.UnmountRequest{Name: "missing", ID: "c1"})
+		AssertError(t, err, "unmount of an unknown volume")
+		AssertEqual(t, true, errors.Is(err, ErrVolumeNotFound), "not found error")
+	})
+
+	t.Run("invalid setting", func(t *testing.T) {
+		t.Setenv("UNKNOWN_UNMOUNT_POLICY", "retry")
+		_, err := driverConfigFromEnv()
+		AssertError(t, err, "config")
+		AssertContains(t, err.Error(), "invalid UNKNOWN_UNMOUNT_POLICY", "config error")
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// volumeName is the rule Docker applies to the names of local volumes. The
+// name also ends up in paths on the plugin host, so it is enforced here too.
+var volumeName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// validateVolumeName rejects a volume name Docker itself would not accept.
+func validateVolumeName(name string) error {
+	if name == "" {
+		return fmt.Errorf("volume name required")
+	}
+	if !volumeName.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q: only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// optionValue returns the value of a pass-through option, matching the key
+// case-insensitively as ssh does.
+func optionValue(v *sshfsVolume, key string) (string, bool) {
+	for _, option := range v.Options {
+		k, val, _ := strings.Cut(option, "=")
+		if strings.EqualFold(k, key) {
+			return val, true
+		}
+	}
+	return "", false
+}
+
+func hasOption(v *sshfsVolume, key string) bool {
+	_, ok := optionValue(v, key)
+	return ok
+}
+
+// countOption returns how often a pass-through option is given under any
+// spelling of its key.
+func countOption(v *sshfsVolume, key string) int {
+	n := 0
+	for _, option := range v.Options {
+		if k, _, _ := strings.Cut(option, "="); strings.EqualFold(k, key) {
+			n++
+		}
+	}
+	return n
+}
+
+// volumeRules are combinations of options that cannot work together. Each
+// check reports whether the volume breaks the rule.
+var volumeRules = []struct {
+	check   func(v *sshfsVolume) bool
+	message string
+}{
+	{
+		func(v *sshfsVolume) bool { return v.CopyIdentityFile && !hasOption(v, "IdentityFile") },
+		"copy_identity_file requires an IdentityFile option",
+	},
+	{
+		func(v *sshfsVolume) bool { return v.NoPersistPassword && v.Password == "" && v.PasswordFile == "" },
+		"no_persist_password requires a password or password_file option",
+	},
+	{
+		func(v *sshfsVolume) bool {
+			return v.ServerAliveCountMax != "" && v.ServerAliveInterval == "" && !hasOption(v, "ServerAliveInterval")
+		},
+		"server_alive_count_max requires server_alive_interval, without it ssh sends no keepalives",
+	},
+	{
+		func(v *sshfsVolume) bool {
+			idmap, _ := optionValue(v, "idmap")
+			return idmap == "file" && !hasOption(v, "uidfile") && !hasOption(v, "gidfile")
+		},
+		"idmap=file requires a uidfile or gidfile option",
+	},
+	{
+		func(v *sshfsVolume) bool {
+			idmap, _ := optionValue(v, "idmap")
+			return idmap != "file" && (hasOption(v, "uidfile") || hasOption(v, "gidfile"))
+		},
+		"uidfile and gidfile require idmap=file",
+	},
+	{
+		func(v *sshfsVolume) bool { return hasOption(v, "allow_root") && hasOption(v, "allow_other") },
+		"allow_root and allow_other cannot be combined",
+	},
+	{
+		func(v *sshfsVolume) bool { return countOption(v, "compression") > 1 },
+		"compression can only be given once",
+	},
+	{
+		func(v *sshfsVolume) bool { return v.AutoCompression && hasOption(v, "compression") },
+		"auto_compression cannot be combined with compression",
+	},
+	{
+		func(v *sshfsVolume) bool {
+			if !v.NoCache {
+				return false
+			}
+			if len(cacheTimeouts(v)) > 0 {
+				return true
+			}
+			for _, key := range cacheOptions {
+				if hasOption(v, key) {
+					return true
+				}
+			}
+			return false
+		},
+		"no_cache cannot be combined with options that tune the cache",
+	},
+	{
+		func(v *sshfsVolume) bool {
+			names := envNames(v.Env)
+			for _, name := range envNames(v.SecretEnv) {
+				if containsFold(names, name) {
+					return true
+				}
+			}
+			return false
+		},
+		"env and secret_env cannot set the same variable",
+	},
+	{
+		func(v *sshfsVolume) bool { return hasOption(v, "ProxyJump") && hasOption(v, "ProxyCommand") },
+		"ProxyJump and ProxyCommand cannot be combined",
+	},
+}
+
+// validateVolume rejects volumes whose options contradict each other, which
+// would otherwise only fail, or silently misbehave, at mount time.
+func validateVolume(v *sshfsVolume) error {
+	for _, rule := range volumeRules {
+		if rule.check(v) {
+			return fmt.Errorf("%s", rule.message)
+		}
+	}
+	return nil
+}
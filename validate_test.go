@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+// TestValidateVolume tests rejection of incoherent option combinations
+func TestValidateVolume(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr string
+	}{
+		{"valid baseline", map[string]string{
+			"IdentityFile": "/keys/id", "copy_identity_file": "false", "password": "secret", "no_persist_password": "",
+			"server_alive_interval": "15", "server_alive_count_max": "3", "idmap": "file", "uidfile": "/etc/uids", "ProxyJump": "bastion",
+		}, ""},
+		{"copy without key", map[string]string{"copy_identity_file": "true"}, "copy_identity_file requires an IdentityFile option"},
+		{"no_persist_password without password", map[string]string{"no_persist_password": ""}, "no_persist_password requires a password"},
+		{"count max without interval", map[string]string{"server_alive_count_max": "3"}, "server_alive_count_max requires server_alive_interval"},
+		{"count max with plain interval", map[string]string{"server_alive_count_max": "3", "ServerAliveInterval": "15"}, ""},
+		{"idmap=file without files", map[string]string{"idmap": "file"}, "idmap=file requires a uidfile or gidfile option"},
+		{"gidfile without idmap=file", map[string]string{"idmap": "user", "gidfile": "/etc/gids"}, "uidfile and gidfile require idmap=file"},
+		{"allow_root and allow_other", map[string]string{"allow_root": "", "allow_other": ""}, "allow_root and allow_other cannot be combined"},
+		{"compression twice", map[string]string{"compression": "yes", "Compression": "yes"}, "compression can only be given once"},
+		{"no_cache and cache_timeout", map[string]string{"no_cache": "", "cache_timeout": "60"}, "no_cache cannot be combined with options that tune the cache"},
+		{"no_cache and attr_timeout", map[string]string{"no_cache": "true", "Attr_Timeout": "5"}, "no_cache cannot be combined with options that tune the cache"},
+		{"disabled no_cache and cache_timeout", map[string]string{"no_cache": "false", "cache_timeout": "60"}, ""},
+		{"proxy jump and command", map[string]string{"ProxyJump": "bastion", "proxycommand": "nc %h %p"}, "ProxyJump and ProxyCommand cannot be combined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver, tmpDir := setupTestDriver(t)
+			defer cleanupTestDriver(tmpDir)
+
+			tt.options["sshcmd"] = "user@host:/path"
+			err := driver.Create(&volume.CreateRequest{Name: "test-volume", Options: tt.options})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Expected options to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected error %q", tt.wantErr)
+			}
+			AssertContains(t, err.Error(), tt.wantErr, "create error")
+			if _, ok := driver.volumes["test-volume"]; ok {
+				t.Error("Expected volume not to be created")
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sshfsFeatures maps options to the first sshfs version that supports them.
+// Older versions reject them or silently ignore them.
+var sshfsFeatures = map[string]string{
+	"dir_cache":   "3.0.0",
+	"passive":     "3.3.0",
+	"max_conns":   "3.7.0",
+	"idmap=file":  "2.5.0",
+	"idmap=user":  "2.0.0",
+	"nomap=error": "2.5.0",
+}
+
+var sshfsVersionRe = regexp.MustCompile(`SSHFS version (\d+(?:\.\d+)*)`)
+
+// detectSshfsVersion records the installed sshfs version, so volumes that
+// request options it lacks can be flagged when they are created.
+func (d *sshfsDriver) detectSshfsVersion() error {
+	output, err := d.executor.Run(exec.Command("sshfs", "--version"))
+	m := sshfsVersionRe.FindSubmatch(output)
+	if m == nil {
+		return fmt.Errorf("failed to detect sshfs version: %v (%s)", err, output)
+	}
+	d.sshfsVersion = string(m[1])
+	logrus.WithField("version", d.sshfsVersion).Info("detected sshfs")
+	return nil
+}
+
+// checkFeatures reports the volume options the detected sshfs version does
+// not support, as a warning or an error depending on the configuration.
+func (d *sshfsDriver) checkFeatures(v *sshfsVolume) error {
+	if d.sshfsVersion == "" || d.config.FeatureCheck == "off" {
+		return nil
+	}
+	for _, option := range v.Options {
+		key, _, _ := strings.Cut(option, "=")
+		required, ok := sshfsFeatures[option]
+		if !ok {
+			required, ok = sshfsFeatures[key]
+		}
+		if !ok || compareVersions(d.sshfsVersion, required) >= 0 {
+			continue
+		}
+		msg := fmt.Sprintf("option %s requires sshfs %s, found %s", option, required, d.sshfsVersion)
+		if d.config.FeatureCheck == "error" {
+			return fmt.Errorf("%s", msg)
+		}
+		logrus.Warn(msg)
+	}
+	return nil
+}
+
+// compareVersions compares dotted version numbers, treating missing
+// components as zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// TestSshfsFeatureCheck tests that options unsupported by the detected sshfs version are reported
+func TestSshfsFeatureCheck(t *testing.T) {
+	setup := func(t *testing.T, version string) (*sshfsDriver, string) {
+		t.Helper()
+		driver, tmpDir := setupTestDriver(t)
+		executor := NewTestCommandExecutor()
+		executor.AddMockResponse([]byte(version), nil)
+		driver.executor = executor
+		if err := driver.detectSshfsVersion(); err != nil {
+			cleanupTestDriver(tmpDir)
+			t.Fatalf("Failed to detect sshfs version: %v", err)
+		}
+		executor.AssertCommand(t, "sshfs --version")
+		return driver, tmpDir
+	}
+	create := func(driver *sshfsDriver, option string) error {
+		return driver.Create(&volume.CreateRequest{
+			Name:    "test-volume",
+			Options: map[string]string{"sshcmd": "user@host:/path", option: "4"},
+		})
+	}
+
+	t.Run("version is detected", func(t *testing.T) {
+		driver, tmpDir := setup(t, "SSHFS version 3.7.3\nFUSE library version 3.14.0\nusing FUSE kernel interface version 7.31\n")
+		defer cleanupTestDriver(tmpDir)
+		AssertEqual(t, "3.7.3", driver.sshfsVersion, "sshfs version")
+	})
+
+	t.Run("unsupported option warns", func(t *testing.T) {
+		driver, tmpDir := setup(t, "SSHFS version 2.8\n")
+		defer cleanupTestDriver(tmpDir)
+		hook := test.NewGlobal()
+		defer hook.Reset()
+
+		if err := create(driver, "max_conns"); err != nil {
+			t.Fatalf("Expected volume to be created with a warning, got %v", err)
+		}
+		entry := hook.LastEntry()
+		if entry == nil || entry.Level != logrus.WarnLevel {
+			t.Fatalf("Expected a warning, got %v", entry)
+		}
+		AssertContains(t, entry.Message, "option max_conns=4 requires sshfs 3.7.0, found 2.8", "warning")
+	})
+
+	t.Run("unsupported option errors", func(t *testing.T) {
+		driver, tmpDir := setup(t, "SSHFS version 2.8\n")
+		defer cleanupTestDriver(tmpDir)
+		driver.config.FeatureCheck = "error"
+
+		err := create(driver, "max_conns")
+		if err == nil {
+			t.Fatal("Expected error for an option unsupported by sshfs 2.8")
+		}
+		AssertContains(t, err.Error(), "requires sshfs 3.7.0", "create error")
+		if _, ok := driver.volumes["test-volume"]; ok {
+			t.Error("Expected volume not to be created")
+		}
+	})
+
+	t.Run("supported option", func(t *testing.T) {
+		driver, tmpDir := setup(t, "SSHFS version 3.7.3\n")
+		defer cleanupTestDriver(tmpDir)
+		driver.config.FeatureCheck = "error"
+
+		if err := create(driver, "max_conns"); err != nil {
+			t.Errorf("Expected no error for a supported option, got %v", err)
+		}
+	})
+
+	t.Run("unknown version is not checked", func(t *testing.T) {
+		driver, tmpDir := setupTestDriver(t)
+		defer cleanupTestDriver(tmpDir)
+		driver.config.FeatureCheck = "error"
+
+		if err := create(driver, "max_conns"); err != nil {
+			t.Errorf("Expected no error without a detected version, got %v", err)
+		}
+	})
+}